@@ -0,0 +1,152 @@
+package assistant
+
+import (
+	"context"       // 用于控制一轮对话的总时间
+	"encoding/json" // 用于比较插件调用的参数
+	"errors"        // 用于判断超时错误
+	"fmt"           // 用于格式化输出
+	"strings"       // 用于拼接步骤记录
+	"time"          // 用于一轮对话的时间预算
+
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/plugins"   // 插件系统
+)
+
+// maxTraceArguments是步骤记录中每个调用的参数最多显示的字符数
+const maxTraceArguments = 120
+
+// agentStep是一轮对话中的一步：模型的一次回复要求的所有插件调用和它们的结果
+type agentStep struct {
+	calls   []openai.ToolCall
+	results []openai.ChatCompletionMessage
+}
+
+// agentLoop记录一轮对话中模型调用插件的步骤，限制步数、发现反复出现的相同调用，并控制整轮对话的时间。
+// 达到限制后不再执行插件，最后的回复会说明停止的原因并附上已经执行的步骤
+type agentLoop struct {
+	maxSteps   int
+	maxRepeats int
+	budget     time.Duration
+
+	steps   []agentStep
+	calls   map[string]int // 调用签名到出现次数
+	stopped string         // 停止调用插件的原因，为空表示没有达到限制
+}
+
+// newAgentLoop函数按agent配置创建一轮对话的步骤记录
+func (assistant *Assistant) newAgentLoop() *agentLoop {
+	return &agentLoop{
+		maxSteps:   assistant.cfg.AgentMaxSteps(),
+		maxRepeats: assistant.cfg.AgentMaxRepeatedCalls(),
+		budget:     assistant.cfg.AgentTimeBudget(),
+		calls:      make(map[string]int),
+	}
+}
+
+// withBudget函数返回受整轮对话时间预算限制的ctx，预算为0时不限制
+func (l *agentLoop) withBudget(ctx context.Context) (context.Context, context.CancelFunc) {
+	if l.budget <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, l.budget)
+}
+
+// outOfTime函数判断err是否因为用完了时间预算，调用方自己的ctx被取消或超时不算
+func (l *agentLoop) outOfTime(parent context.Context, err error) bool {
+	return l.budget > 0 && errors.Is(err, context.DeadlineExceeded) && parent.Err() == nil
+}
+
+// check函数在执行一步的插件调用之前检查步数和重复调用，达到限制时记录并返回停止的原因
+func (l *agentLoop) check(toolCalls []openai.ToolCall) string {
+	if len(l.steps) >= l.maxSteps {
+		l.stopped = fmt.Sprintf("reached the limit of %d steps", l.maxSteps)
+		return l.stopped
+	}
+	for _, call := range toolCalls {
+		signature := callSignature(call)
+		l.calls[signature]++
+		if l.calls[signature] > l.maxRepeats {
+			l.stopped = fmt.Sprintf("%s was called with the same arguments %d times", call.Function.Name, l.calls[signature])
+			return l.stopped
+		}
+	}
+	return ""
+}
+
+// record函数记录执行完的一步
+func (l *agentLoop) record(toolCalls []openai.ToolCall, results []openai.ChatCompletionMessage) {
+	l.steps = append(l.steps, agentStep{calls: toolCalls, results: results})
+}
+
+// stopMessage函数返回达到限制后加入对话的系统消息，要求模型不再调用插件，直接回答
+func (l *agentLoop) stopMessage() string {
+	return fmt.Sprintf("Stopped calling functions: %s. Answer the user with what you have so far and say what is still missing.", l.stopped)
+}
+
+// finish函数在达到限制时给最后的回复附上停止的原因和执行过的步骤，并通过onChunk交给调用方；
+// 没有达到限制时原样返回回复
+func (l *agentLoop) finish(content string, onChunk StreamHandler) string {
+	if l.stopped == "" {
+		return content
+	}
+	note := l.trace()
+	if content != "" {
+		note = "\n\n" + note
+	}
+	if onChunk != nil {
+		onChunk(note)
+	}
+	return content + note
+}
+
+// timedOut函数在用完时间预算时生成最后的回复，这时已经不能再请求模型。
+// 流式输出时之前可能已经显示了一部分回复，说明另起一段
+func (l *agentLoop) timedOut(onChunk StreamHandler) string {
+	l.stopped = fmt.Sprintf("this turn ran out of its %v time budget", l.budget)
+	note := l.trace()
+	if onChunk != nil {
+		onChunk("\n\n" + note)
+	}
+	return note
+}
+
+// trace函数说明停止的原因，并列出每一步调用的插件、参数和结果
+func (l *agentLoop) trace() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Stopped: %s.", l.stopped)
+	if len(l.steps) == 0 {
+		b.WriteString(" No functions were called.]")
+		return b.String()
+	}
+	b.WriteString(" Steps taken:")
+	for i, step := range l.steps {
+		for j, call := range step.calls {
+			arguments := []rune(strings.TrimSpace(call.Function.Arguments))
+			if len(arguments) > maxTraceArguments {
+				arguments = append(arguments[:maxTraceArguments], []rune("…")...)
+			}
+			outcome := "ok"
+			if j < len(step.results) {
+				var response plugins.PluginResponse
+				if json.Unmarshal([]byte(step.results[j].Content), &response) == nil && response.Error != "" {
+					outcome = "error: " + response.Error
+				}
+			}
+			fmt.Fprintf(&b, "\n%d. %s %s → %s", i+1, call.Function.Name, string(arguments), outcome)
+		}
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// callSignature函数返回插件调用的签名，参数按JSON的值比较，字段顺序和空白不同的参数视为相同
+func callSignature(call openai.ToolCall) string {
+	arguments := strings.TrimSpace(call.Function.Arguments)
+	var value interface{}
+	if err := json.Unmarshal([]byte(arguments), &value); err == nil {
+		if canonical, err := json.Marshal(value); err == nil {
+			arguments = string(canonical)
+		}
+	}
+	return call.Function.Name + " " + arguments
+}
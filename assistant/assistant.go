@@ -1,210 +1,350 @@
-package assistant
-
-// 导入所需的包
-import (
-	"context" // 用于控制请求、超时和取消
-	"fmt"     // 用于格式化输出
-
-	"regexp"  // 用于正则表达式
-	"strconv" // 用于字符串和其他类型的转换
-
-	// 用于控制屏幕输出
-	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
-	// 聊天界面
-	"github.com/wangergou2023/clara/config"  // 配置
-	"github.com/wangergou2023/clara/plugins" // 插件系统
-)
-
-// 定义助手结构体，包括配置、OpenAI客户端、函数定义和聊天界面
-type assistant struct {
-	cfg                 config.Cfg
-	Client              *openai.Client
-	functionDefinitions []openai.FunctionDefinition
-}
-
-// 定义系统提示信息，指导如何使用AI助手
-var systemPrompt = `
-你是一个名为Clara的多才多艺的女性AI助手。你启动时的首要任务是“激活”你的记忆，即立即回忆并熟悉与用户及其偏好最相关的数据。这有助于个性化并增强用户互动。
-
-利用可用的插件套件提供最佳解决方案。你可以：
-- 对于简单任务，单独使用插件。
-- 对于复杂任务，串联多个插件。
-
-例如：如果被告知“明天，我需要做X”，结合日期时间插件确定日期和记忆插件来保存任务。
-
-存储和检索信息是你角色的关键。凭借你的能力，确保用户相关数据的保存和检索。优先捕获重要和次要的细节，增强你的记忆深度。保存任何细节时，总是包含其上下文。例如，如果用户提到他们喜欢咖啡，记得当时表达的情景或情感。这样的上下文在后续交互中是无价的。
-
-在接收到用户输入之前，你必须做的第一件事就是使用记忆插件来激活你的记忆。这将使你能够为用户提供最好的可能体验。
-
-`
-
-// 定义全局变量conversation，用于存储对话历史
-var conversation []openai.ChatCompletionMessage
-
-// appendMessage函数用于向对话中添加消息
-func appendMessage(role string, message string, name string) {
-	conversation = append(conversation, openai.ChatCompletionMessage{
-		Role:    role,
-		Content: message,
-		Name:    name,
-	})
-}
-
-// resetConversation函数用于清空对话历史
-func resetConversation() {
-	conversation = []openai.ChatCompletionMessage{}
-}
-
-// restartConversation函数用于重置并重新开始对话
-func (assistant assistant) restartConversation() {
-	resetConversation() // 重置对话
-
-	appendMessage(openai.ChatMessageRoleSystem, systemPrompt, "") // 添加系统提示到对话
-
-	response, err := assistant.sendMessage() // 发送系统提示到OpenAI并获取回复
-
-	if err != nil {
-		fmt.Printf("Error sending system prompt to OpenAI: %v\n", err)
-	}
-
-	appendMessage(openai.ChatMessageRoleAssistant, response, "") // 添加助手回复到对话
-}
-
-// Message函数用于处理用户消息
-func (assistant assistant) Message(message string) (string, error) {
-
-	appendMessage(openai.ChatMessageRoleUser, message, "") // 添加用户消息到对话
-
-	response, err := assistant.sendMessage() // 发送消息到OpenAI并获取回复
-
-	if err != nil {
-		return "", err
-	}
-
-	appendMessage(openai.ChatMessageRoleAssistant, response, "") // 添加助手回复到对话
-	fmt.Printf("Clara:%s\r\n", response)
-
-	return response, nil
-}
-
-// sendMessage函数用于向OpenAI发送请求并获取回复
-func (assistant assistant) sendMessage() (string, error) {
-	resp, err := assistant.sendRequestToOpenAI() // 发送请求到OpenAI
-
-	if err != nil {
-		return "", err
-	}
-
-	if resp.Choices[0].FinishReason == openai.FinishReasonFunctionCall {
-		responseContent, err := assistant.handleFunctionCall(resp) // 处理函数调用
-		if err != nil {
-			return "", err
-		}
-		return responseContent, nil
-	}
-
-	return resp.Choices[0].Message.Content, nil
-}
-
-// handleFunctionCall函数用于处理OpenAI回复中的函数调用
-func (assistant assistant) handleFunctionCall(resp *openai.ChatCompletionResponse) (string, error) {
-
-	funcName := resp.Choices[0].Message.FunctionCall.Name // 获取函数名称
-	ok := plugins.IsPluginLoaded(funcName)                // 检查是否加载了相应插件
-
-	if !ok {
-		return "", fmt.Errorf("no plugin loaded with name %v", funcName)
-	}
-
-	jsonResponse, err := plugins.CallPlugin(resp.Choices[0].Message.FunctionCall.Name, resp.Choices[0].Message.FunctionCall.Arguments) // 调用插件
-
-	if err != nil {
-		return "", err
-	}
-	appendMessage(openai.ChatMessageRoleFunction, resp.Choices[0].Message.Content, funcName)
-	appendMessage(openai.ChatMessageRoleFunction, jsonResponse, "functionName")
-
-	resp, err = assistant.sendRequestToOpenAI() // 发送请求到OpenAI
-	if err != nil {
-		return "", err
-	}
-
-	if resp.Choices[0].FinishReason == openai.FinishReasonFunctionCall {
-		return assistant.handleFunctionCall(resp) // 递归处理函数调用
-	}
-
-	return resp.Choices[0].Message.Content, nil
-}
-
-// sendRequestToOpenAI函数用于向OpenAI发送请求
-func (assistant assistant) sendRequestToOpenAI() (*openai.ChatCompletionResponse, error) {
-	resp, err := assistant.Client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model:        openai.GPT3Dot5Turbo0613,
-			Messages:     conversation,
-			Functions:    assistant.functionDefinitions,
-			FunctionCall: "auto",
-		},
-	)
-
-	if err != nil {
-		assistant.openaiError(err) // 处理OpenAI错误
-		fmt.Println("Error: ", err)
-	}
-	return &resp, err
-}
-
-// Start函数用于启动助手
-func Start(cfg config.Cfg, openaiClient *openai.Client) assistant {
-	if err := plugins.LoadPlugins(cfg, openaiClient); err != nil {
-		fmt.Printf("Error loading plugins: %v", err)
-	}
-	fmt.Println("Plugins loaded successfully")
-	assistant := assistant{
-		cfg:                 cfg,
-		Client:              openaiClient,
-		functionDefinitions: plugins.GenerateOpenAIFunctionsDefinition(),
-	}
-
-	assistant.restartConversation()
-
-	fmt.Println("Assistant is ready!")
-	return assistant
-
-}
-
-// OpenAIError结构体用于封装OpenAI错误
-type OpenAIError struct {
-	StatusCode int
-}
-
-// parseOpenAIError函数用于解析OpenAI错误
-func parseOpenAIError(err error) *OpenAIError {
-	var statusCode int
-
-	reStatusCode := regexp.MustCompile(`status code: (\d+)`)
-
-	if match := reStatusCode.FindStringSubmatch(err.Error()); match != nil {
-		statusCode, _ = strconv.Atoi(match[1]) // 将字符串转换为整数
-	}
-
-	return &OpenAIError{
-		StatusCode: statusCode,
-	}
-}
-
-// openaiError函数用于处理OpenAI错误
-func (assistant assistant) openaiError(err error) {
-	parsedError := parseOpenAIError(err)
-
-	switch parsedError.StatusCode {
-	case 401:
-		fmt.Println("Invalid OpenAI API key. Please enter a valid key.")
-		fmt.Println("You can find your API key at https://beta.openai.com/account/api-keys")
-		fmt.Println("You can also set your API key as an environment variable named OPENAI_API_KEY")
-	default:
-		// Handle other errors
-		fmt.Println("Unknown error: ", parsedError.StatusCode)
-	}
-}
+package assistant
+
+// 导入所需的包
+import (
+	"context"      // 用于控制请求、超时和取消
+	"crypto/rand"  // 用于生成会话ID
+	"encoding/hex" // 用于把会话ID编码为字符串
+	"fmt"          // 用于格式化输出
+	"log"          // 用于记录权限决定
+	"sort"         // 用于对会话排序
+
+	"regexp"  // 用于正则表达式
+	"strconv" // 用于字符串和其他类型的转换
+	"sync"    // 用于保护会话表
+	"time"    // 用于记忆缓存的过期时间
+
+	// 用于控制屏幕输出
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	// 聊天界面
+	"github.com/wangergou2023/clara/config"  // 配置
+	"github.com/wangergou2023/clara/llm"     // 模型服务
+	"github.com/wangergou2023/clara/plugins" // 插件系统
+	"github.com/wangergou2023/clara/store"   // 会话持久化
+)
+
+// Assistant结构体包括配置、模型服务、工具定义，以及由它创建的所有会话
+type Assistant struct {
+	cfg   config.Cfg
+	tools []openai.Tool // 插件的工具定义，插件目录变化时会被替换，通过toolDefinitions读取
+	store store.Store   // 会话存储，为nil时不保存会话
+
+	providers map[string]llm.Provider // 配置中的所有模型服务，键为名称
+
+	policy    plugins.Policy // 配置文件中的权限策略，会话可以在此基础上修改规则
+	decisions *log.Logger    // 记录每一个权限决定的日志
+
+	mu       sync.Mutex          // 保护sessions
+	sessions map[string]*Session // 会话ID到会话的映射
+
+	memoryMu sync.Mutex // 保护下面的记忆缓存
+	memory   string     // Complete使用的用户记忆提示
+	memoryAt time.Time  // 记忆提示的获取时间
+
+	toolsMu  sync.RWMutex        // 保护tools和onNotice
+	onNotice func(notice string) // 显示插件变化等通知，为nil时直接输出到终端
+}
+
+// 定义系统提示信息，指导如何使用AI助手
+var systemPrompt = `
+你是一个名为Clara的多才多艺的女性AI助手。你启动时的首要任务是“激活”你的记忆，即立即回忆并熟悉与用户及其偏好最相关的数据。这有助于个性化并增强用户互动。
+
+利用可用的插件套件提供最佳解决方案。你可以：
+- 对于简单任务，单独使用插件。
+- 对于复杂任务，串联多个插件。
+
+例如：如果被告知“明天，我需要做X”，结合日期时间插件确定日期和记忆插件来保存任务。
+
+存储和检索信息是你角色的关键。凭借你的能力，确保用户相关数据的保存和检索。优先捕获重要和次要的细节，增强你的记忆深度。保存任何细节时，总是包含其上下文。例如，如果用户提到他们喜欢咖啡，记得当时表达的情景或情感。这样的上下文在后续交互中是无价的。
+
+在接收到用户输入之前，你必须做的第一件事就是使用记忆插件来激活你的记忆。这将使你能够为用户提供最好的可能体验。
+
+`
+
+// toolChoice函数返回请求中的tool_choice，没有工具时不能设置该字段
+func toolChoice(tools []openai.Tool) any {
+	if len(tools) == 0 {
+		return nil
+	}
+	return "auto"
+}
+
+// Start函数用于启动助手：创建配置中的模型服务，加载插件后即可通过NewSession创建会话。
+// 插件使用默认的模型服务
+func Start(cfg config.Cfg) *Assistant {
+	providers := llm.NewAll(cfg)
+	provider := providers[cfg.DefaultProvider()]
+
+	if err := plugins.LoadPlugins(cfg, provider); err != nil {
+		fmt.Printf("Error loading plugins: %v", err)
+	}
+	fmt.Println("Plugins loaded successfully")
+	assistant := &Assistant{
+		cfg:       cfg,
+		tools:     plugins.GenerateOpenAIToolsDefinition(),
+		providers: providers,
+		sessions:  make(map[string]*Session),
+
+		policy:    plugins.DefaultPolicy().With(cfg.PolicyRules()),
+		decisions: openDecisionLog(cfg.LogName()),
+	}
+
+	// 插件在运行时被安装或插件目录有变化时，刷新工具定义并通知会话
+	plugins.OnChange(assistant.pluginsChanged)
+	if interval := cfg.PluginsWatchInterval(); interval > 0 {
+		watcher := plugins.NewWatcher(cfg, provider)
+		go watcher.Run(context.Background(), interval, assistant.pluginsChanged)
+	}
+
+	sessionStore, err := store.NewJSONLStore(cfg.SessionsPath())
+	if err != nil {
+		fmt.Printf("Error opening session store, sessions will not be saved: %v\n", err)
+	} else {
+		assistant.store = sessionStore
+	}
+
+	fmt.Println("Assistant is ready!")
+	return assistant
+
+}
+
+// Provider函数返回名为name的模型服务
+func (assistant *Assistant) Provider(name string) (llm.Provider, bool) {
+	provider, ok := assistant.providers[name]
+	return provider, ok
+}
+
+// Providers函数返回所有模型服务的名称，按名称排序
+func (assistant *Assistant) Providers() []string {
+	return llm.Names(assistant.providers)
+}
+
+// defaultProvider函数返回配置中默认使用的模型服务
+func (assistant *Assistant) defaultProvider() llm.Provider {
+	return assistant.providers[assistant.cfg.DefaultProvider()]
+}
+
+// NewSession函数创建一个新的会话，会话使用默认的系统提示并启用所有已加载的插件。
+// 创建后会立即把系统提示发送给模型以激活记忆；发送失败时仍会返回会话和错误。
+func (assistant *Assistant) NewSession() (*Session, error) {
+	return assistant.NewSessionContext(context.Background())
+}
+
+// NewSessionContext函数与NewSession相同，ctx用于控制发送系统提示的请求
+func (assistant *Assistant) NewSessionContext(ctx context.Context) (*Session, error) {
+	session := newSession(assistant, newSessionID())
+	session.mu.Lock()
+	session.persist(store.Record{Type: store.RecordMeta})
+	session.mu.Unlock()
+
+	assistant.register(session)
+
+	return session, session.ResetContext(ctx)
+}
+
+// LoadSession函数从存储中恢复会话，已经在内存中的会话会被直接返回
+func (assistant *Assistant) LoadSession(id string) (*Session, error) {
+	if session, ok := assistant.GetSession(id); ok {
+		return session, nil
+	}
+	if assistant.store == nil {
+		return nil, store.ErrNotFound
+	}
+
+	records, err := assistant.store.Load(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return assistant.registerLoaded(restoreSession(assistant, id, records)), nil
+}
+
+// ForkSession函数复制一个会话的当前状态作为新会话，之后两个会话互不影响
+func (assistant *Assistant) ForkSession(id string) (*Session, error) {
+	source, err := assistant.LoadSession(id)
+	if err != nil {
+		return nil, err
+	}
+
+	fork := newSession(assistant, newSessionID())
+
+	source.mu.RLock()
+	fork.conversation = append([]openai.ChatCompletionMessage{}, source.conversation...)
+	fork.systemPrompt = source.systemPrompt
+	fork.summary = source.summary
+	fork.provider = source.provider
+	fork.model = source.model
+	fork.plugins = make(map[string]bool)
+	for id := range source.plugins {
+		fork.plugins[id] = true
+	}
+	fork.policy = plugins.Policy{}.With(source.policy)
+	source.mu.RUnlock()
+
+	// 新会话的日志包含完整的历史，不依赖来源会话的文件
+	fork.mu.Lock()
+	records := []store.Record{
+		{Type: store.RecordMeta, ParentID: source.ID()},
+		{Type: store.RecordSystemPrompt, Text: fork.systemPrompt},
+		{Type: store.RecordProvider, Text: fork.provider},
+		{Type: store.RecordModel, Text: fork.model},
+		{Type: store.RecordPlugins, Plugins: fork.pluginIDs()},
+	}
+	if len(fork.policy) > 0 {
+		records = append(records, store.Record{Type: store.RecordPolicy, Policy: fork.policy})
+	}
+	for i := range fork.conversation {
+		records = append(records, store.Record{Type: store.RecordMessage, Message: &fork.conversation[i]})
+	}
+	if fork.summary != "" {
+		records = append(records, store.Record{Type: store.RecordCompact, Text: fork.summary})
+	}
+	fork.persist(records...)
+	fork.mu.Unlock()
+
+	assistant.register(fork)
+	return fork, nil
+}
+
+// DeleteSession函数关闭会话并从存储中删除
+func (assistant *Assistant) DeleteSession(id string) error {
+	assistant.CloseSession(id)
+	if assistant.store == nil {
+		return nil
+	}
+	return assistant.store.Delete(id)
+}
+
+// ListSessions函数列出存储中保存的所有会话
+func (assistant *Assistant) ListSessions() ([]store.SessionInfo, error) {
+	if assistant.store == nil {
+		return nil, nil
+	}
+	return assistant.store.List()
+}
+
+// register函数把会话加入会话表
+func (assistant *Assistant) register(session *Session) {
+	assistant.mu.Lock()
+	defer assistant.mu.Unlock()
+
+	session.lastUsed = time.Now()
+	assistant.sessions[session.ID()] = session
+}
+
+// registerLoaded函数把从存储中恢复的会话加入会话表。并发的请求可能同时恢复同一个会话，
+// 这时只保留先加入的会话并返回它，保证同一个会话的消息始终由同一个对象串行处理
+func (assistant *Assistant) registerLoaded(session *Session) *Session {
+	assistant.mu.Lock()
+	defer assistant.mu.Unlock()
+
+	if existing, ok := assistant.sessions[session.ID()]; ok {
+		existing.lastUsed = time.Now()
+		return existing
+	}
+	session.lastUsed = time.Now()
+	assistant.sessions[session.ID()] = session
+	return session
+}
+
+// GetSession函数通过ID查找会话
+func (assistant *Assistant) GetSession(id string) (*Session, bool) {
+	assistant.mu.Lock()
+	defer assistant.mu.Unlock()
+
+	session, ok := assistant.sessions[id]
+	if ok {
+		session.lastUsed = time.Now()
+	}
+	return session, ok
+}
+
+// Sessions函数返回所有会话，按创建时间排序
+func (assistant *Assistant) Sessions() []*Session {
+	assistant.mu.Lock()
+	defer assistant.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(assistant.sessions))
+	for _, session := range assistant.sessions {
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt().Before(sessions[j].CreatedAt())
+	})
+	return sessions
+}
+
+// CloseSession函数从助手中移除会话，已保存的记录不受影响
+func (assistant *Assistant) CloseSession(id string) {
+	assistant.mu.Lock()
+	defer assistant.mu.Unlock()
+
+	delete(assistant.sessions, id)
+}
+
+// EvictIdleSessions函数从内存中移除超过idle没有被使用的会话，返回移除的数量。会话已经保存在存储中，
+// 之后的请求会通过LoadSession重新加载。正在进行对话的会话不会被移除，否则重新加载的会话会与它同时处理消息。
+// 没有会话存储时会话无法重新加载，所以不会移除任何会话
+func (assistant *Assistant) EvictIdleSessions(idle time.Duration) int {
+	if assistant.store == nil {
+		return 0
+	}
+
+	assistant.mu.Lock()
+	defer assistant.mu.Unlock()
+
+	evicted := 0
+	for id, session := range assistant.sessions {
+		if time.Since(session.lastUsed) < idle || !session.turn.TryLock() {
+			continue
+		}
+		delete(assistant.sessions, id)
+		session.turn.Unlock()
+		evicted++
+	}
+	return evicted
+}
+
+// newSessionID函数生成随机的会话ID
+func newSessionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("error generating session id: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// OpenAIError结构体用于封装OpenAI错误
+type OpenAIError struct {
+	StatusCode int
+}
+
+// parseOpenAIError函数用于解析OpenAI错误
+func parseOpenAIError(err error) *OpenAIError {
+	var statusCode int
+
+	reStatusCode := regexp.MustCompile(`status code: (\d+)`)
+
+	if match := reStatusCode.FindStringSubmatch(err.Error()); match != nil {
+		statusCode, _ = strconv.Atoi(match[1]) // 将字符串转换为整数
+	}
+
+	return &OpenAIError{
+		StatusCode: statusCode,
+	}
+}
+
+// openaiError函数用于处理OpenAI错误
+func (assistant *Assistant) openaiError(err error) {
+	parsedError := parseOpenAIError(err)
+
+	switch parsedError.StatusCode {
+	case 401:
+		fmt.Println("Invalid OpenAI API key. Please enter a valid key.")
+		fmt.Println("You can find your API key at https://beta.openai.com/account/api-keys")
+		fmt.Println("You can also set your API key as an environment variable named OPENAI_API_KEY")
+	default:
+		// Handle other errors
+		fmt.Println("Unknown error: ", parsedError.StatusCode)
+	}
+}
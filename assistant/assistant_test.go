@@ -0,0 +1,77 @@
+package assistant
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wangergou2023/clara/config"
+	"github.com/wangergou2023/clara/store"
+)
+
+// newTestAssistant函数创建一个不连接模型服务、把会话保存在临时目录中的助手
+func newTestAssistant(t *testing.T) *Assistant {
+	t.Helper()
+	sessionStore, err := store.NewJSONLStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Assistant{cfg: config.New(), store: sessionStore, sessions: make(map[string]*Session)}
+}
+
+func TestEvictIdleSessions(t *testing.T) {
+	assistant := newTestAssistant(t)
+	idle := newSession(assistant, "idle")
+	busy := newSession(assistant, "busy")
+	recent := newSession(assistant, "recent")
+	for _, s := range []*Session{idle, busy, recent} {
+		assistant.register(s)
+	}
+	idle.lastUsed = time.Now().Add(-time.Hour)
+	busy.lastUsed = time.Now().Add(-time.Hour)
+	busy.turn.Lock() // 正在进行对话
+	defer busy.turn.Unlock()
+
+	if n := assistant.EvictIdleSessions(30 * time.Minute); n != 1 {
+		t.Errorf("EvictIdleSessions() = %d, want 1", n)
+	}
+	if _, ok := assistant.sessions["idle"]; ok {
+		t.Error("idle session was not evicted")
+	}
+	if _, ok := assistant.sessions["busy"]; !ok {
+		t.Error("session in a turn was evicted")
+	}
+	if _, ok := assistant.sessions["recent"]; !ok {
+		t.Error("recently used session was evicted")
+	}
+}
+
+func TestEvictIdleSessionsWithoutStore(t *testing.T) {
+	assistant := newTestAssistant(t)
+	assistant.store = nil
+	s := newSession(assistant, "idle")
+	assistant.register(s)
+	s.lastUsed = time.Now().Add(-time.Hour)
+
+	if n := assistant.EvictIdleSessions(time.Minute); n != 0 {
+		t.Errorf("EvictIdleSessions() = %d without a store, want 0", n)
+	}
+}
+
+func TestLoadSessionKeepsTheLoadedSession(t *testing.T) {
+	assistant := newTestAssistant(t)
+	if err := assistant.store.Append("saved", store.Record{Type: store.RecordMeta}); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := assistant.LoadSession("saved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 另一个请求同时从存储中恢复了同一个会话
+	if got := assistant.registerLoaded(restoreSession(assistant, "saved", nil)); got != first {
+		t.Error("registerLoaded replaced a session that was already loaded")
+	}
+	if got, _ := assistant.LoadSession("saved"); got != first {
+		t.Error("LoadSession returned another session object")
+	}
+}
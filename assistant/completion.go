@@ -0,0 +1,185 @@
+package assistant
+
+import (
+	"context" // 用于控制请求、超时和取消
+	"fmt"     // 用于格式化输出
+	"time"    // 用于记忆缓存的过期时间
+
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/plugins"   // 插件系统
+)
+
+// memoryPluginID是提供长期记忆的插件ID
+const memoryPluginID = "memory"
+
+// memoryCacheTTL是用户记忆提示的缓存时间，避免每次请求都查询全部记忆
+const memoryCacheTTL = 5 * time.Minute
+
+// Complete函数在不创建会话的情况下完成一次对话请求：在调用方的消息前加上Clara的系统提示和用户记忆，
+// 提供所有已加载插件的工具定义，并在内部执行插件调用直到模型给出最终回复。
+// 调用方自己提供的工具不会被执行：模型调用它们时，回复会带着这些工具调用返回给调用方。
+// 插件调用的步数、重复调用和时间与会话一样受agent配置限制。handler.OnChunk不为nil时以流式方式请求模型
+func (assistant *Assistant) Complete(ctx context.Context, req openai.ChatCompletionRequest, handler Handler) (*openai.ChatCompletionResponse, error) {
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: systemPrompt}}
+	if memories := assistant.memoryPrompt(ctx); memories != "" {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: memories})
+	}
+	messages = append(messages, req.Messages...)
+
+	// 调用方的工具与插件同名时以调用方的为准
+	clientTools := make(map[string]bool)
+	for _, tool := range req.Tools {
+		if tool.Function != nil {
+			clientTools[tool.Function.Name] = true
+		}
+	}
+	tools := append([]openai.Tool{}, req.Tools...)
+	for _, tool := range assistant.toolDefinitions() {
+		if !clientTools[tool.Function.Name] {
+			tools = append(tools, tool)
+		}
+	}
+	if req.ToolChoice == nil {
+		req.ToolChoice = toolChoice(tools)
+	}
+	req.Tools = tools
+
+	loop := assistant.newAgentLoop()
+	turnCtx, cancel := loop.withBudget(ctx)
+	defer cancel()
+
+	var usage openai.Usage
+	invalidCalls := 0
+	for {
+		req.Messages = messages
+		resp, err := assistant.complete(turnCtx, req, handler.OnChunk)
+		if loop.outOfTime(ctx, err) {
+			return timedOutResponse(req.Model, usage, loop.timedOut(handler.OnChunk)), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		usage.PromptTokens += resp.Usage.PromptTokens
+		usage.CompletionTokens += resp.Usage.CompletionTokens
+		usage.TotalTokens += resp.Usage.TotalTokens
+		resp.Usage = usage
+
+		if loop.stopped != "" {
+			// 达到限制后模型仍然要求调用插件时不再执行，直接结束
+			choice := &resp.Choices[0]
+			choice.Message.Content = loop.finish(choice.Message.Content, handler.OnChunk)
+			choice.Message.ToolCalls, choice.FinishReason = nil, openai.FinishReasonStop
+			return resp, nil
+		}
+		if !hasToolCalls(resp) {
+			return resp, nil
+		}
+
+		// 模型调用了调用方的工具时把这些调用交还给调用方，同一轮中的插件调用不会执行，
+		// 否则调用方的历史中会出现没有结果的工具调用
+		message := resp.Choices[0].Message
+		var forClient []openai.ToolCall
+		for _, toolCall := range message.ToolCalls {
+			if clientTools[toolCall.Function.Name] {
+				forClient = append(forClient, toolCall)
+			}
+		}
+		if len(forClient) > 0 {
+			resp.Choices[0].Message.ToolCalls = forClient
+			resp.Choices[0].FinishReason = openai.FinishReasonToolCalls
+			return resp, nil
+		}
+
+		if loop.check(message.ToolCalls) != "" {
+			// 这一步的插件调用不执行，要求模型根据已有的结果回答
+			messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: loop.stopMessage()})
+			req.ToolChoice = "none"
+			continue
+		}
+
+		results, err := runToolCalls(turnCtx, message.ToolCalls, assistant.callPlugin(handler.OnApproval), handler)
+		if loop.outOfTime(ctx, err) {
+			return timedOutResponse(req.Model, usage, loop.timedOut(handler.OnChunk)), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		loop.record(message.ToolCalls, results)
+		var exhausted bool
+		if invalidCalls, exhausted = assistant.limitInvalidCalls(results, invalidCalls); exhausted {
+			req.ToolChoice = "none" // 重试次数用完，要求模型直接回答
+		}
+		messages = append(messages, message)
+		messages = append(messages, results...)
+	}
+}
+
+// timedOutResponse函数生成用完时间预算后的回复
+func timedOutResponse(model string, usage openai.Usage, content string) *openai.ChatCompletionResponse {
+	return &openai.ChatCompletionResponse{
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+			FinishReason: openai.FinishReasonStop,
+		}},
+		Usage: usage,
+	}
+}
+
+// callPlugin函数返回Complete使用的插件调用函数，按配置文件中的权限策略检查每次调用
+func (assistant *Assistant) callPlugin(onApproval Approver) func(ctx context.Context, toolCall openai.ToolCall) (string, error) {
+	return func(ctx context.Context, toolCall openai.ToolCall) (string, error) {
+		denied, err := assistant.authorize(ctx, "", assistant.policy, toolCall, onApproval)
+		if err != nil {
+			return "", err
+		}
+		if denied != "" {
+			return plugins.ErrorResponse(denied)
+		}
+		return plugins.CallPluginContext(ctx, toolCall.Function.Name, toolCall.Function.Arguments)
+	}
+}
+
+// complete函数向默认的模型服务发送一次请求，onChunk不为nil时使用流式接口并把回复片段交给onChunk
+func (assistant *Assistant) complete(ctx context.Context, req openai.ChatCompletionRequest, onChunk StreamHandler) (*openai.ChatCompletionResponse, error) {
+	var resp *openai.ChatCompletionResponse
+	var err error
+	if onChunk == nil {
+		resp, err = assistant.defaultProvider().Chat(ctx, req)
+	} else {
+		resp, err = assistant.defaultProvider().ChatStream(ctx, req, onChunk)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err() // 请求被取消，不是需要报告的错误
+	}
+	if err != nil {
+		assistant.openaiError(err) // 处理OpenAI错误
+		return nil, err
+	}
+	return resp, nil
+}
+
+// memoryPrompt函数通过记忆插件的hydrate请求获取关于用户的记忆，结果会缓存一段时间。
+// 没有加载记忆插件或查询失败时返回空字符串
+func (assistant *Assistant) memoryPrompt(ctx context.Context) string {
+	if !plugins.IsPluginLoaded(memoryPluginID) {
+		return ""
+	}
+
+	assistant.memoryMu.Lock()
+	defer assistant.memoryMu.Unlock()
+
+	if time.Since(assistant.memoryAt) < memoryCacheTTL {
+		return assistant.memory
+	}
+
+	memory, err := plugins.CallPluginContext(ctx, memoryPluginID, `{"requestType":"hydrate"}`)
+	if err != nil {
+		fmt.Println("Error hydrating user memories: ", err)
+		return assistant.memory // 查询失败时继续使用上一次的结果
+	}
+	assistant.memory, assistant.memoryAt = memory, time.Now()
+	return memory
+}
@@ -0,0 +1,184 @@
+package assistant
+
+import (
+	"context"       // 用于控制请求、超时和取消
+	"encoding/json" // 用于估算工具定义的长度
+	"fmt"           // 用于格式化输出
+	"strings"       // 用于拼接摘要请求
+
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/llm"       // 估算token数量
+	"github.com/wangergou2023/clara/store"     // 会话持久化
+)
+
+// summaryPrompt是让模型把较早的对话压缩成摘要时使用的系统提示
+const summaryPrompt = `你负责为AI助手Clara维护对话摘要。下面给出已有的摘要（可能为空）以及之后的一段对话。
+请把它们合并为一份新的摘要：保留用户的目标、偏好、做出的决定、未完成的任务以及插件调用得到的关键结果，
+省略寒暄和重复内容。只输出摘要本身，不超过300字。`
+
+// messageTokens函数估算一条消息占用的token数量，包括每条消息固定的格式开销
+func messageTokens(message openai.ChatCompletionMessage) int {
+	tokens := 4 + llm.EstimateTokens(message.Content) + llm.EstimateTokens(message.Name)
+	for _, toolCall := range message.ToolCalls {
+		tokens += 3 + llm.EstimateTokens(toolCall.Function.Name) + llm.EstimateTokens(toolCall.Function.Arguments)
+	}
+	return tokens
+}
+
+// requestTokens函数估算一次请求的消息和工具定义一共占用的token数量
+func requestTokens(messages []openai.ChatCompletionMessage, tools []openai.Tool) int {
+	tokens := 3
+	for _, message := range messages {
+		tokens += messageTokens(message)
+	}
+	if len(tools) > 0 {
+		definitions, _ := json.Marshal(tools)
+		tokens += llm.EstimateTokens(string(definitions))
+	}
+	return tokens
+}
+
+// TokenUsage函数返回当前上下文估算的token数量和模型的上下文预算
+func (s *Session) TokenUsage() (used int, budget int) {
+	return requestTokens(s.requestMessages(), s.tools()), s.assistant.cfg.ContextBudget(s.Model())
+}
+
+// Summary函数返回较早对话的摘要，没有发生过压缩时为空
+func (s *Session) Summary() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.summary
+}
+
+// requestMessages函数返回发送给模型的消息：系统提示、对话摘要和之后的对话。
+// 最近几轮之外的函数结果会被省略，最近几轮中的函数结果会被截断到配置的长度
+func (s *Session) requestMessages() []openai.ChatCompletionMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	limit := s.assistant.cfg.MaxToolResultChars()
+	recentStart := recentTurnsStart(s.conversation, s.assistant.cfg.KeepRecentTurns())
+
+	messages := make([]openai.ChatCompletionMessage, 0, len(s.conversation)+1)
+	for i, message := range s.conversation {
+		if message.Role == openai.ChatMessageRoleTool {
+			if i < recentStart {
+				message.Content = elide(message.Content)
+			} else {
+				message.Content = truncate(message.Content, limit)
+			}
+		}
+		messages = append(messages, message)
+
+		if i == 0 && s.summary != "" {
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: "以下是之前对话的摘要：\n" + s.summary,
+			})
+		}
+	}
+	return messages
+}
+
+// recentTurnsStart函数返回最近turns轮对话开始的位置，每一轮从一条用户消息开始，
+// 这样工具调用和对应的结果不会被拆开。系统提示（下标0）永远不算在内
+func recentTurnsStart(conversation []openai.ChatCompletionMessage, turns int) int {
+	start := len(conversation)
+	for i := len(conversation) - 1; i > 0 && turns > 0; i-- {
+		if conversation[i].Role == openai.ChatMessageRoleUser {
+			start = i
+			turns--
+		}
+	}
+	return start
+}
+
+// truncate函数把文本截断到最多limit个字符
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + fmt.Sprintf("…（已截断%d个字符）", len(runes)-limit)
+}
+
+// elide函数用简短的说明代替较早的函数结果
+func elide(text string) string {
+	n := len([]rune(text))
+	if n <= 80 {
+		return text
+	}
+	return fmt.Sprintf("（较早的函数结果已省略，共%d个字符）", n)
+}
+
+// compact函数在上下文超出预算时，把系统提示和最近几轮之间的对话交给模型压缩成摘要。
+// 保留的轮数会逐步减少，但系统提示和最新的一轮对话始终保留
+func (s *Session) compact(ctx context.Context) error {
+	budget := s.assistant.cfg.ContextBudget(s.Model())
+	tools := s.tools()
+
+	for turns := s.assistant.cfg.KeepRecentTurns(); turns > 0; turns-- {
+		if requestTokens(s.requestMessages(), tools) <= budget {
+			return nil
+		}
+
+		s.mu.RLock()
+		start := recentTurnsStart(s.conversation, turns)
+		old := append([]openai.ChatCompletionMessage{}, s.conversation[1:start]...)
+		summary := s.summary
+		s.mu.RUnlock()
+
+		if len(old) == 0 {
+			continue
+		}
+
+		newSummary, err := s.summarize(ctx, summary, old)
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.conversation = append(s.conversation[:1:1], s.conversation[1+len(old):]...)
+		s.summary = newSummary
+		s.persist(store.Record{Type: store.RecordCompact, Text: newSummary, Count: len(old)})
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// summarize函数请求模型把已有摘要和较早的对话合并成新的摘要
+func (s *Session) summarize(ctx context.Context, summary string, messages []openai.ChatCompletionMessage) (string, error) {
+	var transcript strings.Builder
+	fmt.Fprintf(&transcript, "已有摘要：\n%s\n\n对话：\n", summary)
+	for _, message := range messages {
+		content := message.Content
+		if message.Role == openai.ChatMessageRoleTool {
+			content = truncate(content, s.assistant.cfg.MaxToolResultChars())
+		}
+		for _, toolCall := range message.ToolCalls {
+			content += fmt.Sprintf("[调用插件 %s %s]", toolCall.Function.Name, toolCall.Function.Arguments)
+		}
+		if content == "" {
+			continue
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", message.Role, content)
+	}
+
+	resp, err := s.llmProvider().Chat(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model: s.Model(),
+			Messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleSystem, Content: summaryPrompt},
+				{Role: openai.ChatMessageRoleUser, Content: transcript.String()},
+			},
+		},
+	)
+	if err != nil {
+		s.assistant.openaiError(err) // 处理OpenAI错误
+		return "", fmt.Errorf("error summarizing conversation: %v", err)
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), nil
+}
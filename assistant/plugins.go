@@ -0,0 +1,120 @@
+package assistant
+
+import (
+	"encoding/json" // 用于修改插件调用的结果
+	"fmt"           // 用于格式化输出
+	"strings"       // 用于拼接通知
+
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/plugins"   // 插件系统
+	"github.com/wangergou2023/clara/store"     // 会话持久化
+)
+
+// toolDefinitions函数返回当前插件的工具定义，下一次请求会自动使用重新加载后的定义
+func (assistant *Assistant) toolDefinitions() []openai.Tool {
+	assistant.toolsMu.RLock()
+	defer assistant.toolsMu.RUnlock()
+
+	return assistant.tools
+}
+
+// SetNoticeHandler函数设置显示通知的函数，例如全屏界面把通知显示在对话区域中。
+// handler为nil时通知直接输出到终端
+func (assistant *Assistant) SetNoticeHandler(handler func(notice string)) {
+	assistant.toolsMu.Lock()
+	defer assistant.toolsMu.Unlock()
+
+	assistant.onNotice = handler
+}
+
+// notify函数显示一条通知
+func (assistant *Assistant) notify(notice string) {
+	assistant.toolsMu.RLock()
+	handler := assistant.onNotice
+	assistant.toolsMu.RUnlock()
+
+	if handler == nil {
+		fmt.Println(notice)
+		return
+	}
+	handler(notice)
+}
+
+// pluginsChanged函数在插件目录变化后刷新工具定义，在所有会话中启用新增的插件，
+// 并把变化作为通知告诉用户和模型。加载失败只通知用户
+func (assistant *Assistant) pluginsChanged(changes []plugins.Change) {
+	tools := plugins.GenerateOpenAIToolsDefinition()
+	assistant.toolsMu.Lock()
+	assistant.tools = tools
+	assistant.toolsMu.Unlock()
+
+	var loaded, all []string
+	for _, change := range changes {
+		all = append(all, change.String())
+		if change.Action != plugins.ChangeFailed {
+			loaded = append(loaded, change.String())
+		}
+	}
+
+	if len(loaded) > 0 {
+		notice := "Plugins changed: " + strings.Join(loaded, "; ")
+		for _, session := range assistant.liveSessions() {
+			for _, change := range changes {
+				if change.Action == plugins.ChangeAdded {
+					session.enableNewPlugin(change.ID)
+				}
+			}
+			session.addNotice(notice)
+		}
+	}
+
+	assistant.notify("Plugins changed: " + strings.Join(all, "; "))
+}
+
+// liveSessions函数返回内存中的所有会话
+func (assistant *Assistant) liveSessions() []*Session {
+	assistant.mu.Lock()
+	defer assistant.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(assistant.sessions))
+	for _, session := range assistant.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// enableNewPlugin函数在会话中启用新加载的插件
+func (s *Session) enableNewPlugin(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.plugins[id] {
+		return
+	}
+	s.plugins[id] = true
+	s.persist(store.Record{Type: store.RecordPlugins, Plugins: s.pluginIDs()})
+}
+
+// limitInvalidCalls函数统计结果中因参数无效而没有执行的插件调用，invalid是这一轮对话中之前的次数。
+// 累计次数超过plugin_argument_retries后，把这些结果的错误改为停止调用插件的提示，并返回true，
+// 调用方之后的请求不应再允许模型调用插件，避免模型反复给出无效参数
+func (assistant *Assistant) limitInvalidCalls(results []openai.ChatCompletionMessage, invalid int) (int, bool) {
+	limit := assistant.cfg.PluginArgumentRetries()
+	for i := range results {
+		if !plugins.HasInvalidArguments(results[i].Content) {
+			continue
+		}
+		invalid++
+		if invalid <= limit {
+			continue
+		}
+
+		var response plugins.PluginResponse
+		json.Unmarshal([]byte(results[i].Content), &response)
+		response.Error = fmt.Sprintf("invalid arguments for %s, %d invalid calls in this turn: do not call any more functions, tell the user what went wrong instead", results[i].Name, invalid)
+		if content, err := json.Marshal(response); err == nil {
+			results[i].Content = string(content)
+		}
+	}
+	return invalid, invalid > limit
+}
@@ -0,0 +1,163 @@
+package assistant
+
+import (
+	"bytes"         // 用于格式化参数
+	"context"       // 用于在等待确认时取消对话
+	"encoding/json" // 用于格式化参数
+	"fmt"           // 用于格式化输出
+	"log"           // 用于记录权限决定
+	"os"            // 用于打开日志文件
+	"strings"       // 用于拼接能力名称
+
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/plugins"   // 插件系统
+	"github.com/wangergou2023/clara/store"     // 会话持久化
+)
+
+// Approval是一次需要用户确认的插件调用
+type Approval struct {
+	SessionID    string          // 发起调用的会话
+	Call         openai.ToolCall // 模型给出的调用，参数与执行时完全相同
+	Capabilities []string        // 插件声明的能力
+	Rule         string          // 要求确认的规则，例如filesystem-write或plugins.weather
+}
+
+// Arguments函数返回格式化后的调用参数，参数不是有效的JSON时原样返回
+func (a Approval) Arguments() string {
+	var b bytes.Buffer
+	if err := json.Indent(&b, []byte(a.Call.Function.Arguments), "", "  "); err != nil {
+		return a.Call.Function.Arguments
+	}
+	return b.String()
+}
+
+// Approver询问用户是否允许一次插件调用。ctx结束时应尽快返回ctx的错误，这一轮对话会被中止
+type Approver func(ctx context.Context, approval Approval) (bool, error)
+
+// 权限决定的来源，记录在日志中
+const (
+	decidedByPolicy = "policy"      // 按规则直接允许或拒绝
+	decidedByUser   = "user"        // 用户确认或拒绝
+	decidedNoUser   = "no-approver" // 需要确认，但没有可以询问的用户
+)
+
+// openDecisionLog函数打开log_name指定的日志文件，用于记录每一个权限决定。打不开时记录到标准错误
+func openDecisionLog(path string) *log.Logger {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		fmt.Printf("Error opening log file %s, permission decisions will be logged to stderr: %v\n", path, err)
+		return log.New(os.Stderr, "clara: ", log.LstdFlags)
+	}
+	return log.New(file, "", log.LstdFlags)
+}
+
+// logDecision函数把一个权限决定写入日志
+func (assistant *Assistant) logDecision(sessionID string, call openai.ToolCall, capabilities []string, rule string, decision string, by string) {
+	if sessionID == "" {
+		sessionID = "-" // 通过HTTP API的请求不属于任何会话
+	}
+	assistant.decisions.Printf("policy session=%s plugin=%s capabilities=%s rule=%s decision=%s by=%s arguments=%q",
+		sessionID, call.Function.Name, strings.Join(capabilities, ","), rule, decision, by, call.Function.Arguments)
+}
+
+// authorize函数按权限策略检查一次插件调用，返回空字符串表示可以执行，否则返回作为调用结果交给模型的拒绝原因。
+// 策略要求确认时通过approve询问用户，approve为nil（例如HTTP API）时视为拒绝。
+// 未加载的插件和参数无效的调用不会执行，不需要检查。每个决定都会写入日志
+func (assistant *Assistant) authorize(ctx context.Context, sessionID string, policy plugins.Policy, call openai.ToolCall, approve Approver) (string, error) {
+	id := call.Function.Name
+	p, ok := plugins.GetPluginByID(id)
+	if !ok || plugins.ValidateArguments(p.FunctionDefinition(), call.Function.Arguments) != nil {
+		return "", nil
+	}
+
+	capabilities := plugins.CallCapabilitiesOf(p, call.Function.Arguments)
+	decision, rule := policy.Decide(id, capabilities)
+	switch decision {
+	case plugins.PolicyAllow:
+		assistant.logDecision(sessionID, call, capabilities, rule, plugins.PolicyAllow, decidedByPolicy)
+		return "", nil
+
+	case plugins.PolicyAsk:
+		if approve == nil {
+			assistant.logDecision(sessionID, call, capabilities, rule, plugins.PolicyDeny, decidedNoUser)
+			return fmt.Sprintf("calling %s requires the user's approval (policy rule %s), which cannot be asked for here; do not call it again, tell the user instead", id, rule), nil
+		}
+		allowed, err := approve(ctx, Approval{SessionID: sessionID, Call: call, Capabilities: capabilities, Rule: rule})
+		if err != nil {
+			return "", err
+		}
+		if allowed {
+			assistant.logDecision(sessionID, call, capabilities, rule, plugins.PolicyAllow, decidedByUser)
+			return "", nil
+		}
+		assistant.logDecision(sessionID, call, capabilities, rule, plugins.PolicyDeny, decidedByUser)
+		return fmt.Sprintf("the user denied this call to %s; do not call it again with the same arguments unless the user asks you to", id), nil
+
+	default:
+		assistant.logDecision(sessionID, call, capabilities, rule, plugins.PolicyDeny, decidedByPolicy)
+		return fmt.Sprintf("calling %s is denied by the policy rule %s; do not call it again, tell the user instead", id, rule), nil
+	}
+}
+
+// Policy函数返回本会话生效的权限策略：配置文件中的策略加上本会话中修改的规则
+func (s *Session) Policy() plugins.Policy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.assistant.policy.With(s.policy)
+}
+
+// PolicyOverrides函数返回本会话中修改过的规则
+func (s *Session) PolicyOverrides() plugins.Policy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return plugins.Policy{}.With(s.policy)
+}
+
+// SetPolicy函数在本会话中修改一条权限规则，rule为能力名称、default或plugins.<插件ID>，
+// decision为allow、ask或deny
+func (s *Session) SetPolicy(rule string, decision string) error {
+	if !plugins.IsPolicyDecision(decision) {
+		return fmt.Errorf("unknown decision %q, expected allow, ask or deny", decision)
+	}
+	if id := strings.TrimPrefix(rule, "plugins."); id != rule {
+		if !plugins.IsPluginLoaded(id) {
+			return fmt.Errorf("no plugin loaded with name %v", id)
+		}
+	} else if rule != plugins.PolicyDefault && !plugins.IsCapability(rule) {
+		return fmt.Errorf("unknown rule %q, expected default, plugins.<id> or one of %s", rule, strings.Join(plugins.Capabilities, ", "))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.policy = s.policy.With(plugins.Policy{rule: decision})
+	s.persist(store.Record{Type: store.RecordPolicy, Policy: s.policy})
+	return nil
+}
+
+// ResetPolicy函数撤销本会话中修改的所有规则，恢复使用配置文件中的策略
+func (s *Session) ResetPolicy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.policy = plugins.Policy{}
+	s.persist(store.Record{Type: store.RecordPolicy, Policy: s.policy})
+}
+
+// approve函数询问用户是否允许一次插件调用。同一轮中的插件并行执行，确认请求会逐个交给界面
+func (s *Session) approve(onApproval Approver) Approver {
+	if onApproval == nil {
+		return nil
+	}
+	return func(ctx context.Context, approval Approval) (bool, error) {
+		s.approvals.Lock()
+		defer s.approvals.Unlock()
+
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		return onApproval(ctx, approval)
+	}
+}
@@ -0,0 +1,565 @@
+package assistant
+
+import (
+	"context" // 用于控制请求、超时和取消
+	"fmt"     // 用于格式化输出
+	"sort"    // 用于对插件ID排序
+	"strings" // 用于拼接模型服务名称
+	"sync"    // 用于保护会话状态和等待并行执行的插件
+	"time"    // 用于记录会话创建时间
+
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/llm"       // 模型服务
+	"github.com/wangergou2023/clara/plugins"   // 插件系统
+	"github.com/wangergou2023/clara/store"     // 会话持久化
+)
+
+// Session表示一段独立的对话，拥有自己的ID、历史、系统提示和插件集合。
+// 同一个会话中的消息按顺序处理，不同会话之间可以并发使用。
+type Session struct {
+	id        string
+	createdAt time.Time
+	assistant *Assistant
+
+	turn      sync.Mutex // 保证同一时间只有一轮对话在进行
+	approvals sync.Mutex // 保证同一时间只向用户询问一个插件调用
+
+	lastUsed time.Time // 最后一次被查找或加入会话表的时间，由assistant.mu保护
+
+	mu           sync.RWMutex                   // 保护下面的字段
+	conversation []openai.ChatCompletionMessage // 对话历史
+	systemPrompt string                         // 系统提示
+	provider     string                         // 本会话使用的模型服务名称
+	model        string                         // 本会话使用的模型
+	plugins      map[string]bool                // 本会话启用的插件ID
+	summary      string                         // 被压缩掉的较早对话的摘要
+	notices      []string                       // 下一轮对话开始时加入对话的通知
+	policy       plugins.Policy                 // 本会话中修改的权限规则，优先于配置文件
+}
+
+// newSession函数创建会话，默认启用所有已加载的插件
+func newSession(assistant *Assistant, id string) *Session {
+	enabled := make(map[string]bool)
+	for id := range plugins.GetAllPlugins() {
+		enabled[id] = true
+	}
+
+	return &Session{
+		id:           id,
+		createdAt:    time.Now(),
+		assistant:    assistant,
+		systemPrompt: systemPrompt,
+		provider:     assistant.cfg.DefaultProvider(),
+		model:        assistant.cfg.Model(),
+		plugins:      enabled,
+		policy:       plugins.Policy{},
+	}
+}
+
+// restoreSession函数按顺序重放存储中的记录，恢复会话的历史、系统提示和插件集合
+func restoreSession(assistant *Assistant, id string, records []store.Record) *Session {
+	s := newSession(assistant, id)
+	if len(records) > 0 {
+		s.createdAt = records[0].Time
+	}
+
+	for _, record := range records {
+		switch record.Type {
+		case store.RecordReset:
+			s.conversation = []openai.ChatCompletionMessage{}
+			s.summary = ""
+		case store.RecordCompact:
+			if record.Count > 0 && len(s.conversation) > record.Count {
+				s.conversation = append(s.conversation[:1:1], s.conversation[1+record.Count:]...)
+			}
+			s.summary = record.Text
+		case store.RecordRollback:
+			if record.Count > 0 && len(s.conversation) > record.Count {
+				s.conversation = s.conversation[:len(s.conversation)-record.Count]
+			}
+		case store.RecordMessage:
+			if record.Message != nil {
+				s.conversation = append(s.conversation, *record.Message)
+			}
+		case store.RecordSystemPrompt:
+			s.systemPrompt = record.Text
+			if len(s.conversation) > 0 && s.conversation[0].Role == openai.ChatMessageRoleSystem {
+				s.conversation[0].Content = record.Text
+			}
+		case store.RecordProvider:
+			s.provider = record.Text
+		case store.RecordModel:
+			s.model = record.Text
+		case store.RecordPolicy:
+			s.policy = plugins.Policy{}.With(record.Policy)
+		case store.RecordPlugins:
+			// 只恢复当前仍然加载的插件
+			s.plugins = make(map[string]bool)
+			for _, id := range record.Plugins {
+				if plugins.IsPluginLoaded(id) {
+					s.plugins[id] = true
+				}
+			}
+		}
+	}
+
+	return s
+}
+
+// persist函数把记录追加到会话存储，调用方需要持有s.mu以保证记录的顺序
+func (s *Session) persist(records ...store.Record) {
+	if s.assistant.store == nil {
+		return
+	}
+
+	now := time.Now()
+	for i := range records {
+		records[i].Time = now
+	}
+
+	if err := s.assistant.store.Append(s.id, records...); err != nil {
+		fmt.Printf("Error saving session %s: %v\n", s.id, err)
+	}
+}
+
+// pluginIDs函数返回排序后的启用插件ID，调用方需要持有s.mu
+func (s *Session) pluginIDs() []string {
+	ids := make([]string, 0, len(s.plugins))
+	for id := range s.plugins {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ID函数返回会话ID
+func (s *Session) ID() string {
+	return s.id
+}
+
+// CreatedAt函数返回会话的创建时间
+func (s *Session) CreatedAt() time.Time {
+	return s.createdAt
+}
+
+// Model函数返回本会话使用的模型名称
+func (s *Session) Model() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.model
+}
+
+// SetModel函数修改本会话使用的模型
+func (s *Session) SetModel(model string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.model = model
+	s.persist(store.Record{Type: store.RecordModel, Text: model})
+}
+
+// Provider函数返回本会话使用的模型服务名称
+func (s *Session) Provider() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.provider
+}
+
+// SetProvider函数让本会话改用名为name的模型服务，同时切换到该服务配置的模型
+func (s *Session) SetProvider(name string) error {
+	provider, ok := s.assistant.Provider(name)
+	if !ok {
+		return fmt.Errorf("unknown provider %q, expected one of %s", name, strings.Join(s.assistant.Providers(), ", "))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.provider, s.model = name, provider.Model()
+	s.persist(
+		store.Record{Type: store.RecordProvider, Text: s.provider},
+		store.Record{Type: store.RecordModel, Text: s.model},
+	)
+	return nil
+}
+
+// llmProvider函数返回本会话使用的模型服务。会话记录中的服务已经从配置中删除时使用默认的服务
+func (s *Session) llmProvider() llm.Provider {
+	if provider, ok := s.assistant.Provider(s.Provider()); ok {
+		return provider
+	}
+	return s.assistant.defaultProvider()
+}
+
+// History函数返回对话历史的副本
+func (s *Session) History() []openai.ChatCompletionMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := make([]openai.ChatCompletionMessage, len(s.conversation))
+	copy(history, s.conversation)
+	return history
+}
+
+// SystemPrompt函数返回会话的系统提示
+func (s *Session) SystemPrompt() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.systemPrompt
+}
+
+// SetSystemPrompt函数替换会话的系统提示，已有历史中的系统消息也会一并更新
+func (s *Session) SetSystemPrompt(prompt string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.systemPrompt = prompt
+	if len(s.conversation) > 0 && s.conversation[0].Role == openai.ChatMessageRoleSystem {
+		s.conversation[0].Content = prompt
+	}
+	s.persist(store.Record{Type: store.RecordSystemPrompt, Text: prompt})
+}
+
+// Plugins函数返回本会话启用的插件ID
+func (s *Session) Plugins() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.pluginIDs()
+}
+
+// EnablePlugin函数在本会话中启用一个已加载的插件
+func (s *Session) EnablePlugin(id string) error {
+	if !plugins.IsPluginLoaded(id) {
+		return fmt.Errorf("no plugin loaded with name %v", id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.plugins[id] = true
+	s.persist(store.Record{Type: store.RecordPlugins, Plugins: s.pluginIDs()})
+	return nil
+}
+
+// DisablePlugin函数在本会话中停用一个插件
+func (s *Session) DisablePlugin(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.plugins, id)
+	s.persist(store.Record{Type: store.RecordPlugins, Plugins: s.pluginIDs()})
+}
+
+// pluginEnabled函数检查插件是否在本会话中启用
+func (s *Session) pluginEnabled(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.plugins[id]
+}
+
+// tools函数返回本会话启用的插件对应的工具定义
+func (s *Session) tools() []openai.Tool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tools []openai.Tool
+	for _, tool := range s.assistant.toolDefinitions() {
+		if s.plugins[tool.Function.Name] {
+			tools = append(tools, tool)
+		}
+	}
+	return tools
+}
+
+// appendMessage函数用于向对话中添加消息
+func (s *Session) appendMessage(role string, message string, name string) {
+	s.appendChatMessage(openai.ChatCompletionMessage{
+		Role:    role,
+		Content: message,
+		Name:    name,
+	})
+}
+
+// appendChatMessage函数用于向对话中添加完整的消息，例如带有工具调用的助手消息
+func (s *Session) appendChatMessage(message openai.ChatCompletionMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conversation = append(s.conversation, message)
+	s.persist(store.Record{Type: store.RecordMessage, Message: &message})
+}
+
+// addNotice函数记录一条通知，通知会在下一轮对话开始时以系统消息加入对话。
+// 不能立即加入，因为进行中的对话可能正等待插件结果，中间不能插入其他消息
+func (s *Session) addNotice(notice string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.notices = append(s.notices, notice)
+}
+
+// flushNotices函数把待加入的通知加入对话，调用方需要持有s.turn
+func (s *Session) flushNotices() {
+	s.mu.Lock()
+	notices := s.notices
+	s.notices = nil
+	s.mu.Unlock()
+
+	for _, notice := range notices {
+		s.appendMessage(openai.ChatMessageRoleSystem, notice, "")
+	}
+}
+
+// resetConversation函数用于清空对话历史
+func (s *Session) resetConversation() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conversation = []openai.ChatCompletionMessage{}
+	s.summary = ""
+	s.persist(store.Record{Type: store.RecordReset})
+}
+
+// Reset函数用于重置并重新开始对话
+func (s *Session) Reset() error {
+	return s.ResetContext(context.Background())
+}
+
+// ResetContext函数与Reset相同，ctx被取消或超时时会中止发送给模型的请求
+func (s *Session) ResetContext(ctx context.Context) error {
+	s.turn.Lock()
+	defer s.turn.Unlock()
+
+	s.resetConversation() // 重置对话
+
+	s.appendMessage(openai.ChatMessageRoleSystem, s.SystemPrompt(), "") // 添加系统提示到对话
+
+	response, err := s.sendMessage(ctx, Handler{}) // 发送系统提示到OpenAI并获取回复
+
+	if err != nil {
+		fmt.Printf("Error sending system prompt to OpenAI: %v\n", err)
+		return err
+	}
+
+	s.appendMessage(openai.ChatMessageRoleAssistant, response, "") // 添加助手回复到对话
+	return nil
+}
+
+// Message函数用于处理用户消息，回复会在生成时逐段输出到终端
+func (s *Session) Message(message string) (string, error) {
+	fmt.Print("Clara:")
+	response, err := s.MessageStream(message, func(chunk string) {
+		fmt.Print(chunk)
+	})
+	fmt.Print("\r\n")
+
+	return response, err
+}
+
+// MessageStream函数用于处理用户消息，每收到一段回复就调用onChunk，最后返回完整回复
+func (s *Session) MessageStream(message string, onChunk StreamHandler) (string, error) {
+	return s.MessageWithHandler(message, Handler{OnChunk: onChunk})
+}
+
+// MessageWithHandler函数用于处理用户消息，并通过handler通知回复片段和插件调用的进展
+func (s *Session) MessageWithHandler(message string, handler Handler) (string, error) {
+	return s.MessageContext(context.Background(), message, handler)
+}
+
+// MessageContext函数与MessageWithHandler相同，ctx被取消或超时时会中止这一轮对话
+func (s *Session) MessageContext(ctx context.Context, message string, handler Handler) (string, error) {
+	s.turn.Lock()
+	defer s.turn.Unlock()
+
+	s.flushNotices()                                         // 先加入对话进行期间收到的通知
+	s.appendMessage(openai.ChatMessageRoleUser, message, "") // 添加用户消息到对话
+
+	response, err := s.sendMessage(ctx, handler) // 发送消息到OpenAI并获取回复
+
+	if err != nil {
+		s.rollbackTurn() // 失败或被取消的一轮不留在历史中，重试时不会出现重复的用户消息
+		return "", err
+	}
+
+	s.appendMessage(openai.ChatMessageRoleAssistant, response, "") // 添加助手回复到对话
+
+	return response, nil
+}
+
+// rollbackTurn函数撤销最后一条用户消息以及之后加入的消息，也就是失败的这一轮对话。
+// 压缩上下文时最新的一轮总是保留，所以这一轮的用户消息仍然是最后一条用户消息。调用方需要持有s.turn
+func (s *Session) rollbackTurn() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.conversation) - 1; i > 0; i-- {
+		if s.conversation[i].Role == openai.ChatMessageRoleUser {
+			count := len(s.conversation) - i
+			s.conversation = s.conversation[:i]
+			s.persist(store.Record{Type: store.RecordRollback, Count: count})
+			return
+		}
+	}
+}
+
+// sendMessage函数用于向OpenAI发送请求并获取回复。模型要求调用插件时执行这些调用并把结果交给模型，
+// 直到模型给出最终回复；步数、重复调用和时间受agent配置限制，达到限制时回复会附上执行过的步骤
+func (s *Session) sendMessage(ctx context.Context, handler Handler) (string, error) {
+	loop := s.assistant.newAgentLoop()
+	turnCtx, cancel := loop.withBudget(ctx)
+	defer cancel()
+
+	allowTools, invalidCalls := true, 0
+	for {
+		resp, err := s.sendRequest(turnCtx, handler.OnChunk, allowTools) // 发送请求到模型服务
+		if loop.outOfTime(ctx, err) {
+			return loop.timedOut(handler.OnChunk), nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		// 达到限制后模型仍然要求调用插件时不再执行，直接结束
+		if !hasToolCalls(resp) || loop.stopped != "" {
+			return loop.finish(resp.Choices[0].Message.Content, handler.OnChunk), nil
+		}
+
+		if loop.check(resp.Choices[0].Message.ToolCalls) != "" {
+			// 这一步的插件调用不执行，要求模型根据已有的结果回答
+			s.appendMessage(openai.ChatMessageRoleSystem, loop.stopMessage(), "")
+			allowTools = false
+			continue
+		}
+
+		var exhausted bool
+		invalidCalls, exhausted, err = s.handleFunctionCall(turnCtx, resp, handler, loop, invalidCalls) // 处理工具调用
+		if loop.outOfTime(ctx, err) {
+			return loop.timedOut(handler.OnChunk), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		allowTools = !exhausted // 参数无效的重试次数用完后不再允许调用插件
+	}
+}
+
+// hasToolCalls函数判断回复是否要求调用工具
+func hasToolCalls(resp *openai.ChatCompletionResponse) bool {
+	return resp.Choices[0].FinishReason == openai.FinishReasonToolCalls || len(resp.Choices[0].Message.ToolCalls) > 0
+}
+
+// handleFunctionCall函数执行OpenAI回复中的工具调用，同一轮中的多个调用会并行执行，执行完的一步记录在loop中。
+// 插件执行完才记录带有工具调用的助手消息，对话被取消时历史中不会留下没有结果的工具调用。
+// invalidCalls是这一轮对话中已经因为参数无效而没有执行的调用次数，返回更新后的次数以及重试次数是否已经用完
+func (s *Session) handleFunctionCall(ctx context.Context, resp *openai.ChatCompletionResponse, handler Handler, loop *agentLoop, invalidCalls int) (int, bool, error) {
+	toolCalls := resp.Choices[0].Message.ToolCalls
+	call := func(ctx context.Context, toolCall openai.ToolCall) (string, error) {
+		return s.callPlugin(ctx, toolCall, handler.OnApproval)
+	}
+	results, err := runToolCalls(ctx, toolCalls, call, handler)
+	if err != nil {
+		return invalidCalls, false, err
+	}
+	loop.record(toolCalls, results)
+
+	invalidCalls, exhausted := s.assistant.limitInvalidCalls(results, invalidCalls)
+	s.appendChatMessage(resp.Choices[0].Message)
+	for _, result := range results {
+		s.appendChatMessage(result)
+	}
+	return invalidCalls, exhausted, nil
+}
+
+// runToolCalls函数并行执行一轮回复中的所有工具调用，按调用顺序返回对应到各自ToolCallID的tool消息。
+// ctx被取消时返回ctx的错误
+func runToolCalls(ctx context.Context, toolCalls []openai.ToolCall, call func(ctx context.Context, toolCall openai.ToolCall) (string, error), handler Handler) ([]openai.ChatCompletionMessage, error) {
+	results := make([]string, len(toolCalls))
+	errs := make([]error, len(toolCalls))
+
+	var wg sync.WaitGroup
+	for i, toolCall := range toolCalls {
+		wg.Add(1)
+		go func(i int, toolCall openai.ToolCall) {
+			defer wg.Done()
+			if handler.OnToolCall != nil {
+				handler.OnToolCall(toolCall)
+			}
+			results[i], errs[i] = call(ctx, toolCall) // 调用插件
+			if handler.OnToolResult != nil {
+				handler.OnToolResult(toolCall, results[i], errs[i])
+			}
+		}(i, toolCall)
+	}
+	wg.Wait()
+
+	messages := make([]openai.ChatCompletionMessage, len(toolCalls))
+	for i, toolCall := range toolCalls {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		messages[i] = openai.ChatCompletionMessage{
+			Role:       openai.ChatMessageRoleTool,
+			Content:    results[i],
+			Name:       toolCall.Function.Name,
+			ToolCallID: toolCall.ID,
+		}
+	}
+	return messages, nil
+}
+
+// callPlugin函数按本会话的权限策略调用启用的插件，需要确认时通过onApproval询问用户。
+// 未启用或未加载的插件以及被拒绝的调用会返回包含错误信息的JSON，让模型自行纠正
+func (s *Session) callPlugin(ctx context.Context, toolCall openai.ToolCall, onApproval Approver) (string, error) {
+	id := toolCall.Function.Name
+	if !s.pluginEnabled(id) {
+		return plugins.ErrorResponse(fmt.Sprintf("plugin with ID %s is not enabled in this session", id))
+	}
+	denied, err := s.assistant.authorize(ctx, s.id, s.Policy(), toolCall, s.approve(onApproval))
+	if err != nil {
+		return "", err
+	}
+	if denied != "" {
+		return plugins.ErrorResponse(denied)
+	}
+	return plugins.CallPluginContext(plugins.WithSessionID(ctx, s.id), id, toolCall.Function.Arguments)
+}
+
+// sendRequest函数用于以流式方式向本会话的模型服务发送请求，并把分段回复组装成完整的回复。
+// allowTools为false时仍然提供工具定义，但要求模型直接回答
+func (s *Session) sendRequest(ctx context.Context, onChunk StreamHandler, allowTools bool) (*openai.ChatCompletionResponse, error) {
+	// 超出上下文预算时先压缩较早的对话，压缩失败时仍然尝试发送
+	if err := s.compact(ctx); err != nil && ctx.Err() == nil {
+		fmt.Println("Error compacting conversation: ", err)
+	}
+
+	tools := s.tools()
+	choice := toolChoice(tools)
+	if !allowTools && len(tools) > 0 {
+		choice = "none"
+	}
+	resp, err := s.llmProvider().ChatStream(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model:      s.Model(),
+			Messages:   s.requestMessages(),
+			Tools:      tools,
+			ToolChoice: choice,
+		},
+		onChunk,
+	)
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err() // 对话被取消，不是需要报告的错误
+	}
+	if err != nil {
+		s.assistant.openaiError(err) // 处理OpenAI错误
+		fmt.Println("Error: ", err)
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,18 @@
+package assistant
+
+import (
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/llm"       // 模型服务
+)
+
+// StreamHandler在收到模型回复的每一段文本时被调用，用于把回复实时交给调用方
+type StreamHandler = llm.StreamHandler
+
+// Handler汇总了一轮对话中的回调，所有字段都可以为nil。
+// 同一轮中的多个插件会并行执行，OnToolCall和OnToolResult可能被并发调用；OnApproval不会被并发调用
+type Handler struct {
+	OnChunk      StreamHandler                                        // 收到一段回复
+	OnToolCall   func(call openai.ToolCall)                           // 插件开始执行
+	OnToolResult func(call openai.ToolCall, result string, err error) // 插件执行完成
+	OnApproval   Approver                                             // 权限策略要求确认插件调用，为nil时这些调用会被拒绝
+}
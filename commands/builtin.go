@@ -0,0 +1,327 @@
+package commands
+
+import (
+	"fmt"     // 用于格式化输出
+	"os"      // 用于导出对话
+	"sort"    // 用于对插件ID排序
+	"strings" // 用于拼接输出
+
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/plugins"   // 插件系统
+)
+
+// builtinCommands函数返回所有内置命令，/help在NewRegistry中单独注册
+func builtinCommands() []Command {
+	return []Command{
+		{
+			Name:        "reset",
+			Usage:       "/reset",
+			Description: "清空当前会话的历史并重新开始",
+			Run: func(ctx *Context, args []string) (string, error) {
+				if err := ctx.Session.Reset(); err != nil {
+					return "", err
+				}
+				return "会话已重置", nil
+			},
+		},
+		{
+			Name:        "history",
+			Usage:       "/history",
+			Description: "显示当前会话的对话历史",
+			Run: func(ctx *Context, args []string) (string, error) {
+				return formatHistory(ctx.Session.History()), nil
+			},
+		},
+		{
+			Name:        "save",
+			Usage:       "/save [file]",
+			Description: "会话会自动保存；指定文件时把对话导出为Markdown",
+			Run: func(ctx *Context, args []string) (string, error) {
+				if len(args) == 0 {
+					return fmt.Sprintf("会话 %s 已保存，可以使用 --resume %s 或 /load %s 恢复", ctx.Session.ID(), ctx.Session.ID(), ctx.Session.ID()), nil
+				}
+				if err := os.WriteFile(args[0], []byte(formatMarkdown(ctx.Session.History())), 0o644); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("对话已导出到 %s", args[0]), nil
+			},
+		},
+		{
+			Name:        "load",
+			Usage:       "/load <id>",
+			Description: "切换到已保存的会话，不带参数时列出所有会话",
+			Run: func(ctx *Context, args []string) (string, error) {
+				if len(args) == 0 {
+					return listSessions(ctx)
+				}
+				session, err := ctx.Assistant.LoadSession(args[0])
+				if err != nil {
+					return "", err
+				}
+				ctx.Session = session
+				return fmt.Sprintf("已切换到会话 %s", session.ID()), nil
+			},
+		},
+		{
+			Name:        "model",
+			Usage:       "/model [name]",
+			Description: "显示或切换当前会话使用的模型",
+			Run: func(ctx *Context, args []string) (string, error) {
+				if len(args) == 0 {
+					return "当前模型: " + ctx.Session.Model(), nil
+				}
+				ctx.Session.SetModel(args[0])
+				return "已切换模型: " + args[0], nil
+			},
+		},
+		{
+			Name:        "provider",
+			Usage:       "/provider [name]",
+			Description: "显示或切换当前会话使用的模型服务，切换时同时使用该服务配置的模型",
+			Run: func(ctx *Context, args []string) (string, error) {
+				if len(args) == 0 {
+					return formatProviders(ctx), nil
+				}
+				if err := ctx.Session.SetProvider(args[0]); err != nil {
+					return "", err
+				}
+				return fmt.Sprintf("已切换模型服务: %s（模型 %s）", args[0], ctx.Session.Model()), nil
+			},
+		},
+		{
+			Name:        "system",
+			Usage:       "/system [prompt]",
+			Description: "显示或替换当前会话的系统提示",
+			Run: func(ctx *Context, args []string) (string, error) {
+				if len(args) == 0 {
+					return ctx.Session.SystemPrompt(), nil
+				}
+				ctx.Session.SetSystemPrompt(strings.Join(args, " "))
+				return "系统提示已更新", nil
+			},
+		},
+		{
+			Name:        "plugins",
+			Usage:       "/plugins",
+			Description: "列出所有已加载的插件和它们在当前会话中的状态",
+			Run: func(ctx *Context, args []string) (string, error) {
+				return listPlugins(ctx), nil
+			},
+		},
+		{
+			Name:        "plugin",
+			Usage:       "/plugin enable|disable <id>",
+			Description: "在当前会话中启用或停用插件",
+			Run: func(ctx *Context, args []string) (string, error) {
+				if len(args) != 2 {
+					return "", fmt.Errorf("用法: /plugin enable|disable <id>")
+				}
+				switch args[0] {
+				case "enable":
+					if err := ctx.Session.EnablePlugin(args[1]); err != nil {
+						return "", err
+					}
+					return "已启用插件 " + args[1], nil
+				case "disable":
+					ctx.Session.DisablePlugin(args[1])
+					return "已停用插件 " + args[1], nil
+				default:
+					return "", fmt.Errorf("用法: /plugin enable|disable <id>")
+				}
+			},
+		},
+		{
+			Name:        "policy",
+			Usage:       "/policy [reset | <rule> allow|ask|deny]",
+			Description: "显示或修改当前会话的插件权限策略，rule为能力名称、default或插件ID",
+			Run: func(ctx *Context, args []string) (string, error) {
+				switch {
+				case len(args) == 0:
+					return formatPolicy(ctx), nil
+				case len(args) == 1 && args[0] == "reset":
+					ctx.Session.ResetPolicy()
+					return "已恢复配置文件中的权限策略", nil
+				case len(args) == 2:
+					rule := args[0]
+					if rule != plugins.PolicyDefault && !plugins.IsCapability(rule) && plugins.IsPluginLoaded(rule) {
+						rule = plugins.PluginRule(rule) // 可以直接写插件ID
+					}
+					if err := ctx.Session.SetPolicy(rule, args[1]); err != nil {
+						return "", err
+					}
+					return fmt.Sprintf("本会话中 %s 的插件调用: %s", rule, args[1]), nil
+				default:
+					return "", fmt.Errorf("用法: /policy [reset | <rule> allow|ask|deny]")
+				}
+			},
+		},
+		{
+			Name:        "tokens",
+			Usage:       "/tokens",
+			Description: "显示当前上下文估算的token用量",
+			Run: func(ctx *Context, args []string) (string, error) {
+				used, budget := ctx.Session.TokenUsage()
+				out := fmt.Sprintf("上下文约 %d / %d tokens（模型 %s）", used, budget, ctx.Session.Model())
+				if summary := ctx.Session.Summary(); summary != "" {
+					out += "\n较早的对话已压缩为摘要：\n" + summary
+				}
+				return out, nil
+			},
+		},
+		{
+			Name:        "exit",
+			Usage:       "/exit",
+			Description: "退出Clara，会话已自动保存",
+			Run: func(ctx *Context, args []string) (string, error) {
+				return "", ErrExit
+			},
+		},
+	}
+}
+
+// listSessions函数列出已保存的会话
+func listSessions(ctx *Context) (string, error) {
+	sessions, err := ctx.Assistant.ListSessions()
+	if err != nil {
+		return "", err
+	}
+	if len(sessions) == 0 {
+		return "没有已保存的会话", nil
+	}
+
+	var b strings.Builder
+	for _, info := range sessions {
+		marker := " "
+		if info.ID == ctx.Session.ID() {
+			marker = "*"
+		}
+		fmt.Fprintf(&b, "%s %s  %s  %d条消息  %s\n", marker, info.ID, info.UpdatedAt.Format("2006-01-02 15:04"), info.Messages, info.Title)
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
+}
+
+// listPlugins函数列出已加载的插件
+func listPlugins(ctx *Context) string {
+	enabled := make(map[string]bool)
+	for _, id := range ctx.Session.Plugins() {
+		enabled[id] = true
+	}
+
+	all := plugins.GetAllPlugins()
+	if len(all) == 0 {
+		return "没有加载任何插件"
+	}
+
+	ids := make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var b strings.Builder
+	for _, id := range ids {
+		state := "停用"
+		if enabled[id] {
+			state = "启用"
+		}
+		fmt.Fprintf(&b, "  %-12s [%s] %s（%s）\n", id, state, all[id].Description(), plugins.FormatCapabilities(plugins.CapabilitiesOf(all[id])))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// formatPolicy函数列出当前会话的权限规则，以及按这些规则每个插件的调用会被如何处理
+func formatPolicy(ctx *Context) string {
+	policy := ctx.Session.Policy()
+	overrides := ctx.Session.PolicyOverrides()
+
+	rules := make([]string, 0, len(policy))
+	for rule := range policy {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+
+	var b strings.Builder
+	b.WriteString("规则（*表示在本会话中修改过）:\n")
+	for _, rule := range rules {
+		marker := " "
+		if _, ok := overrides[rule]; ok {
+			marker = "*"
+		}
+		fmt.Fprintf(&b, "%s %-20s %s\n", marker, rule, policy[rule])
+	}
+
+	all := plugins.GetAllPlugins()
+	ids := make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	b.WriteString("插件:\n")
+	for _, id := range ids {
+		capabilities := plugins.CapabilitiesOf(all[id])
+		decision, rule := policy.Decide(id, capabilities)
+		fmt.Fprintf(&b, "  %-12s %-5s 按规则 %s（%s）\n", id, decision, rule, plugins.FormatCapabilities(capabilities))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// formatProviders函数列出所有模型服务和它们配置的模型，标出当前会话使用的服务
+func formatProviders(ctx *Context) string {
+	current := ctx.Session.Provider()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "当前模型服务: %s（模型 %s）\n", current, ctx.Session.Model())
+	for _, name := range ctx.Assistant.Providers() {
+		marker := " "
+		if name == current {
+			marker = "*"
+		}
+		provider, _ := ctx.Assistant.Provider(name)
+		fmt.Fprintf(&b, "%s %-12s %s\n", marker, name, provider.Model())
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// formatHistory函数把对话历史格式化为文本，省略系统提示
+func formatHistory(history []openai.ChatCompletionMessage) string {
+	var b strings.Builder
+	for i, message := range history {
+		switch message.Role {
+		case openai.ChatMessageRoleSystem:
+			// 第一条系统消息是系统提示，之后的是插件变化等通知
+			if i > 0 {
+				fmt.Fprintf(&b, "[%s]\n", message.Content)
+			}
+		case openai.ChatMessageRoleUser:
+			fmt.Fprintf(&b, "你: %s\n", message.Content)
+		case openai.ChatMessageRoleAssistant:
+			if message.Content != "" {
+				fmt.Fprintf(&b, "Clara: %s\n", message.Content)
+			}
+			for _, toolCall := range message.ToolCalls {
+				fmt.Fprintf(&b, "  [插件 %s %s]\n", toolCall.Function.Name, toolCall.Function.Arguments)
+			}
+		}
+	}
+	if b.Len() == 0 {
+		return "还没有对话"
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// formatMarkdown函数把对话历史导出为Markdown
+func formatMarkdown(history []openai.ChatCompletionMessage) string {
+	var b strings.Builder
+	for _, message := range history {
+		switch message.Role {
+		case openai.ChatMessageRoleUser:
+			fmt.Fprintf(&b, "**你**\n\n%s\n\n", message.Content)
+		case openai.ChatMessageRoleAssistant:
+			if message.Content != "" {
+				fmt.Fprintf(&b, "**Clara**\n\n%s\n\n", message.Content)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,157 @@
+// commands包实现REPL中以"/"开头的斜杠命令
+package commands
+
+import (
+	"errors"  // 用于定义错误
+	"fmt"     // 用于格式化输出
+	"sort"    // 用于对命令排序
+	"strings" // 用于解析输入
+	"sync"    // 用于保护命令表
+
+	"github.com/wangergou2023/clara/assistant" // 助手和会话
+	"github.com/wangergou2023/clara/plugins"   // 插件系统
+)
+
+// ErrExit表示用户要求退出Clara
+var ErrExit = errors.New("exit")
+
+// Context是命令执行时可以访问的环境。命令可以替换Session（例如/load），
+// 界面在命令执行后应使用新的会话
+type Context struct {
+	Assistant *assistant.Assistant
+	Session   *assistant.Session
+}
+
+// Command是一个斜杠命令
+type Command struct {
+	Name        string                                            // 命令名称，不包含"/"
+	Usage       string                                            // 用法说明
+	Description string                                            // 命令描述
+	Run         func(ctx *Context, args []string) (string, error) // 执行命令，返回要显示给用户的文本
+}
+
+// Registry保存所有已注册的命令
+type Registry struct {
+	mu       sync.RWMutex
+	commands map[string]Command
+}
+
+// NewRegistry函数创建一个包含内置命令的命令表
+func NewRegistry() *Registry {
+	r := &Registry{commands: make(map[string]Command)}
+	cmds := append(builtinCommands(), Command{
+		Name:        "help",
+		Usage:       "/help",
+		Description: "列出所有命令",
+		Run: func(ctx *Context, args []string) (string, error) {
+			return r.help(), nil
+		},
+	})
+	for _, cmd := range cmds {
+		if err := r.Register(cmd); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// Register函数注册一个命令，名称冲突时返回错误
+func (r *Registry) Register(cmd Command) error {
+	if cmd.Name == "" || strings.ContainsAny(cmd.Name, " /") {
+		return fmt.Errorf("invalid command name %q", cmd.Name)
+	}
+	if cmd.Run == nil {
+		return fmt.Errorf("command /%s has no Run function", cmd.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.commands[cmd.Name]; exists {
+		return fmt.Errorf("command /%s is already registered", cmd.Name)
+	}
+	r.commands[cmd.Name] = cmd
+	return nil
+}
+
+// RegisterPluginCommands函数注册已加载插件通过plugins.CommandProvider提供的命令，
+// 返回注册失败的原因，失败的命令会被跳过
+func (r *Registry) RegisterPluginCommands() []error {
+	var errs []error
+
+	for id, p := range plugins.GetAllPlugins() {
+		provider, ok := p.(plugins.CommandProvider)
+		if !ok {
+			continue
+		}
+		for _, pc := range provider.Commands() {
+			run := pc.Run
+			err := r.Register(Command{
+				Name:        pc.Name,
+				Usage:       pc.Usage,
+				Description: pc.Description,
+				Run: func(ctx *Context, args []string) (string, error) {
+					return run(args)
+				},
+			})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("plugin %s: %v", id, err))
+			}
+		}
+	}
+
+	return errs
+}
+
+// Commands函数返回按名称排序的所有命令
+func (r *Registry) Commands() []Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cmds := make([]Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool {
+		return cmds[i].Name < cmds[j].Name
+	})
+	return cmds
+}
+
+// IsCommand函数判断输入是否为斜杠命令
+func IsCommand(input string) bool {
+	return strings.HasPrefix(strings.TrimSpace(input), "/")
+}
+
+// Dispatch函数解析并执行斜杠命令，返回要显示给用户的文本
+func (r *Registry) Dispatch(ctx *Context, input string) (string, error) {
+	fields := strings.Fields(strings.TrimSpace(input))
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return "", fmt.Errorf("not a command: %q", input)
+	}
+
+	name := strings.TrimPrefix(fields[0], "/")
+
+	r.mu.RLock()
+	cmd, ok := r.commands[name]
+	r.mu.RUnlock()
+
+	if !ok {
+		return "", fmt.Errorf("未知命令 /%s，输入 /help 查看所有命令", name)
+	}
+	return cmd.Run(ctx, fields[1:])
+}
+
+// help函数生成命令列表
+func (r *Registry) help() string {
+	var b strings.Builder
+	b.WriteString("可用命令：\n")
+	for _, cmd := range r.Commands() {
+		usage := cmd.Usage
+		if usage == "" {
+			usage = "/" + cmd.Name
+		}
+		fmt.Fprintf(&b, "  %-32s %s\n", usage, cmd.Description)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
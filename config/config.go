@@ -1,86 +1,428 @@
-// 定义配置包
-package config
-
-// 导入必要的包
-
-// 用于格式化输出
-// 用于操作系统相关的操作，如文件操作
-
-// 定义Milvus数据库配置的结构体
-type MalvusCfg struct {
-	apiEndpoint    string // Milvus服务器的API终端地址
-	collectionName string // Milvus中用于存储数据的集合名称
-}
-
-// 定义主配置结构体
-type Cfg struct {
-	openAiAPIKey string // OpenAI API的密钥
-
-	openWeatherMapAPIKey string // OpenWeatherMap API的密钥
-
-	pluginsPath string // 插件存放的路径
-	logName     string // 日志文件的名称
-
-	malvusCfg MalvusCfg // Milvus数据库的配置
-}
-
-// New函数用于创建并初始化Cfg配置实例
-func New() Cfg {
-	// 初始化Milvus配置
-	malvusCfg := MalvusCfg{
-		apiEndpoint:    "http://llxspace.store:19530", // Milvus API终端地址
-		collectionName: "CGPTMemory",                  // Milvus集合名称
-	}
-
-	// 初始化主配置
-	cfg := Cfg{
-		openAiAPIKey:         "sk-2uEpTNt8ESEvkQUG30De33002fEc411e841a29FaD4Db5cE5", // OpenAI API的密钥
-		openWeatherMapAPIKey: "787947f021c60a672678b3a9a20b2d4b",                    // OpenWeatherMap API的密钥
-		pluginsPath:          "./plugins",                                           // 插件路径
-		malvusCfg:            malvusCfg,                                             // 设置Milvus配置
-	}
-
-	return cfg // 返回配置实例
-}
-
-// OpenAiAPIKey方法返回OpenAI API的密钥
-func (c Cfg) OpenAiAPIKey() string {
-	return c.openAiAPIKey
-}
-
-// PluginsPath方法返回插件存放的路径
-func (c Cfg) PluginsPath() string {
-	return c.pluginsPath
-}
-
-// SetOpenAiAPIKey方法设置OpenAI API的密钥
-func (c Cfg) SetOpenAiAPIKey(openAiAPIKey string) Cfg {
-	c.openAiAPIKey = openAiAPIKey
-	return c
-}
-
-func (c Cfg) OpenWeatherMapAPIKey() string {
-	return c.openWeatherMapAPIKey
-}
-
-// MalvusApiEndpoint方法返回Milvus API终端的地址
-func (c Cfg) MalvusApiEndpoint() string {
-	return c.malvusCfg.apiEndpoint
-}
-
-// SetMalvusApiEndpoint方法设置Milvus API终端的地址
-func (c Cfg) SetMalvusApiEndpoint(apiEndpoint string) Cfg {
-	c.malvusCfg.apiEndpoint = apiEndpoint
-	return c
-}
-
-// MalvusCollectionName方法返回Milvus中用于存储数据的集合名称
-func (c Cfg) MalvusCollectionName() string {
-	return c.malvusCfg.collectionName
-}
-
-// SetMalvusCollectionName方法设置Milvus中用于存储数据的集合名称
-func (c Cfg) SetMalvusCollectionName(collectionName string) Cfg {
-	c.malvusCfg.collectionName = collectionName
-	return c
-}
+// 定义配置包
+package config
+
+// 导入必要的包
+import (
+	"sort"    // 用于按名称排列模型服务
+	"strings" // 用于模型名称的前缀匹配
+	"time"    // 用于超时设置
+)
+
+// 用于格式化输出
+// 用于操作系统相关的操作，如文件操作
+
+// 定义Milvus数据库配置的结构体
+type MalvusCfg struct {
+	apiEndpoint    string // Milvus服务器的API终端地址
+	collectionName string // Milvus中用于存储数据的集合名称
+}
+
+// 定义上下文窗口管理的配置结构体
+type ContextCfg struct {
+	maxTokens          int            // 所有模型统一使用的上下文预算，0表示按模型查表
+	budgets            map[string]int // 每个模型的上下文预算（token数）
+	keepRecentTurns    int            // 始终保留的最近对话轮数
+	maxToolResultChars int            // 单个函数结果保留的最大字符数
+}
+
+// 定义HTTP服务器的配置结构体
+type ServerCfg struct {
+	addr           string        // 监听地址
+	apiKey         string        // 访问API需要的密钥，为空时不校验
+	requestTimeout time.Duration // 每个请求的最长处理时间
+	sessionIdle    time.Duration // 会话空闲多久后从内存中移除，0表示不移除
+}
+
+// 定义WebAssembly插件的配置结构体
+type WasmCfg struct {
+	memoryLimitMB int           // 每次调用可以使用的最大内存（MB）
+	timeout       time.Duration // 每次调用的最长执行时间
+}
+
+// 定义一轮对话中插件调用循环的配置结构体
+type AgentCfg struct {
+	maxSteps         int           // 一轮对话中模型最多调用插件的步数
+	maxRepeatedCalls int           // 同一个插件以相同参数最多调用的次数
+	timeBudget       time.Duration // 一轮对话的总时间，0表示不限制
+}
+
+// 定义主配置结构体
+type Cfg struct {
+	openAiAPIKey  string // OpenAI API的密钥
+	openAiBaseURL string // OpenAI API的基础地址，需要包含"/v1"
+	model         string // 对话使用的模型名称
+	embedModel    string // 计算向量使用的嵌入模型
+
+	provider  string            // 默认使用的模型服务，openai或providers下定义的名称
+	providers map[string]string // 其他模型服务的配置，键为"<名称>.<键>"
+
+	openWeatherMapAPIKey string // OpenWeatherMap API的密钥
+
+	pluginsPath  string // 插件存放的路径
+	sessionsPath string // 会话记录存放的路径
+	logName      string // 日志文件的名称
+
+	pluginsWatchInterval time.Duration // 检查插件目录变化的间隔，0表示不检查
+	pluginTimeout        time.Duration // 单次插件调用的默认超时时间，0表示不限制
+	pluginArgRetries     int           // 一轮对话中模型给出无效参数后最多重试的次数
+
+	malvusCfg MalvusCfg // Milvus数据库的配置
+
+	contextCfg ContextCfg // 上下文窗口管理的配置
+
+	serverCfg ServerCfg // HTTP服务器的配置
+
+	wasmCfg WasmCfg // WebAssembly插件的配置
+
+	agentCfg AgentCfg // 插件调用循环的配置
+
+	pluginCfg map[string]string // 插件自己的配置，键为"<插件ID>.<键>"
+
+	policy map[string]string // 插件调用的权限策略，键为能力名称或"default"，值为allow、ask或deny
+}
+
+// 模型服务的类型
+const (
+	ProviderTypeOpenAI = "openai" // OpenAI或兼容OpenAI接口的服务，例如llama.cpp的server
+	ProviderTypeOllama = "ollama" // Ollama的原生接口
+)
+
+// BuiltinProvider是由openai.*配置的内置模型服务的名称
+const BuiltinProvider = "openai"
+
+// 模型服务没有配置时使用的默认值
+const (
+	defaultOllamaBaseURL        = "http://localhost:11434"
+	defaultEmbeddingModel       = "text-embedding-ada-002"
+	defaultOllamaEmbeddingModel = "nomic-embed-text"
+)
+
+// ProviderCfg是一个模型服务的配置
+type ProviderCfg struct {
+	Name           string // 配置中的名称
+	Type           string // 服务的类型，openai或ollama
+	BaseURL        string // 服务的地址，OpenAI兼容的服务需要包含"/v1"
+	APIKey         string // 访问服务的密钥，本地服务可以为空
+	Model          string // 默认的对话模型
+	EmbeddingModel string // 计算向量使用的嵌入模型
+}
+
+// defaultContextBudget是未知模型使用的上下文预算
+const defaultContextBudget = 3000
+
+// defaultContextBudgets是常见模型的上下文预算，预留了生成回复所需的token
+var defaultContextBudgets = map[string]int{
+	"gpt-3.5-turbo":     3000,
+	"gpt-3.5-turbo-16k": 14000,
+	"gpt-4":             7000,
+	"gpt-4-32k":         30000,
+	"gpt-4-turbo":       100000,
+	"gpt-4o":            100000,
+}
+
+// New函数用于创建并初始化Cfg配置实例，只包含默认值，密钥等需要通过Load加载
+func New() Cfg {
+	// 初始化Milvus配置
+	malvusCfg := MalvusCfg{
+		apiEndpoint:    "localhost:19530", // Milvus API终端地址
+		collectionName: "CGPTMemory",      // Milvus集合名称
+	}
+
+	// 初始化上下文窗口配置
+	contextCfg := ContextCfg{
+		budgets:            make(map[string]int),
+		keepRecentTurns:    4,
+		maxToolResultChars: 2000,
+	}
+	for model, budget := range defaultContextBudgets {
+		contextCfg.budgets[model] = budget
+	}
+
+	// 初始化HTTP服务器配置，默认只监听本机
+	serverCfg := ServerCfg{
+		addr:           "127.0.0.1:8080",
+		requestTimeout: 2 * time.Minute,
+		sessionIdle:    30 * time.Minute,
+	}
+
+	// 初始化WebAssembly插件配置
+	wasmCfg := WasmCfg{
+		memoryLimitMB: 64,
+		timeout:       10 * time.Second,
+	}
+
+	// 初始化插件调用循环配置
+	agentCfg := AgentCfg{
+		maxSteps:         10,
+		maxRepeatedCalls: 2,
+		timeBudget:       5 * time.Minute,
+	}
+
+	// 初始化主配置
+	cfg := Cfg{
+		openAiBaseURL: "https://api.openai.com/v1", // OpenAI API的基础地址
+		model:         "gpt-3.5-turbo-0613",        // 对话模型
+		embedModel:    defaultEmbeddingModel,       // 嵌入模型
+		provider:      BuiltinProvider,             // 默认使用openai.*配置的服务
+		pluginsPath:   "./plugins",                 // 插件路径
+		sessionsPath:  "./sessions",                // 会话记录路径
+		logName:       "clara.log",                 // 日志文件名称
+		malvusCfg:     malvusCfg,                   // 设置Milvus配置
+		contextCfg:    contextCfg,                  // 设置上下文窗口配置
+		serverCfg:     serverCfg,                   // 设置HTTP服务器配置
+		wasmCfg:       wasmCfg,                     // 设置WebAssembly插件配置
+		agentCfg:      agentCfg,                    // 设置插件调用循环配置
+	}
+
+	cfg.pluginsWatchInterval = 2 * time.Second // 默认每2秒检查一次插件目录
+	cfg.pluginTimeout = 2 * time.Minute        // 默认每次插件调用最多2分钟
+	cfg.pluginArgRetries = 3                   // 默认参数无效时最多重试3次
+
+	return cfg // 返回配置实例
+}
+
+// OpenAiAPIKey方法返回OpenAI API的密钥
+func (c Cfg) OpenAiAPIKey() string {
+	return c.openAiAPIKey
+}
+
+// SetOpenAiAPIKey方法设置OpenAI API的密钥
+func (c Cfg) SetOpenAiAPIKey(openAiAPIKey string) Cfg {
+	c.openAiAPIKey = openAiAPIKey
+	return c
+}
+
+// OpenAiBaseURL方法返回OpenAI API的基础地址
+func (c Cfg) OpenAiBaseURL() string {
+	return c.openAiBaseURL
+}
+
+// SetOpenAiBaseURL方法设置OpenAI API的基础地址
+func (c Cfg) SetOpenAiBaseURL(baseURL string) Cfg {
+	c.openAiBaseURL = baseURL
+	return c
+}
+
+// Model方法返回默认模型服务的对话模型，新的会话和HTTP API默认使用这个模型
+func (c Cfg) Model() string {
+	if pc, ok := c.Provider(c.provider); ok {
+		return pc.Model
+	}
+	return c.model
+}
+
+// SetModel方法设置openai.model，即内置模型服务的对话模型
+func (c Cfg) SetModel(model string) Cfg {
+	c.model = model
+	return c
+}
+
+// DefaultProvider方法返回默认使用的模型服务名称
+func (c Cfg) DefaultProvider() string {
+	return c.provider
+}
+
+// ProviderNames方法返回所有模型服务的名称，包括内置的openai
+func (c Cfg) ProviderNames() []string {
+	names := []string{BuiltinProvider}
+	seen := map[string]bool{BuiltinProvider: true}
+	for key := range c.providers {
+		name := key[:strings.LastIndex(key, ".")]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// Provider方法返回名为name的模型服务的配置。内置的openai由openai.*配置，
+// 其他服务由providers.<名称>.*配置，没有设置的地址和嵌入模型使用该类型的默认值
+func (c Cfg) Provider(name string) (ProviderCfg, bool) {
+	if name == BuiltinProvider {
+		return ProviderCfg{
+			Name:           name,
+			Type:           ProviderTypeOpenAI,
+			BaseURL:        c.openAiBaseURL,
+			APIKey:         c.openAiAPIKey,
+			Model:          c.model,
+			EmbeddingModel: c.embedModel,
+		}, true
+	}
+
+	get := func(key string) string { return strings.TrimSpace(c.providers[name+"."+key]) }
+	pc := ProviderCfg{
+		Name:           name,
+		Type:           get("type"),
+		BaseURL:        get("base_url"),
+		APIKey:         get("api_key"),
+		Model:          get("model"),
+		EmbeddingModel: get("embedding_model"),
+	}
+	if pc.Type == "" {
+		return ProviderCfg{}, false
+	}
+	if pc.Type == ProviderTypeOllama {
+		if pc.BaseURL == "" {
+			pc.BaseURL = defaultOllamaBaseURL
+		}
+		if pc.EmbeddingModel == "" {
+			pc.EmbeddingModel = defaultOllamaEmbeddingModel
+		}
+	}
+	if pc.EmbeddingModel == "" {
+		pc.EmbeddingModel = defaultEmbeddingModel
+	}
+	return pc, true
+}
+
+// PluginsPath方法返回插件存放的路径
+func (c Cfg) PluginsPath() string {
+	return c.pluginsPath
+}
+
+// PluginsWatchInterval方法返回检查插件目录变化的间隔，0表示不检查
+func (c Cfg) PluginsWatchInterval() time.Duration {
+	return c.pluginsWatchInterval
+}
+
+// PluginTimeout方法返回插件单次调用的超时时间，plugins.<插件ID>.timeout优先于plugin_timeout，0表示不限制
+func (c Cfg) PluginTimeout(id string) time.Duration {
+	if v, ok := c.pluginCfg[id+".timeout"]; ok {
+		if timeout, err := time.ParseDuration(strings.TrimSpace(v)); err == nil {
+			return timeout
+		}
+	}
+	return c.pluginTimeout
+}
+
+// PluginArgumentRetries方法返回一轮对话中模型给出无效参数后最多重试的次数，超过后这一轮不再调用插件
+func (c Cfg) PluginArgumentRetries() int {
+	return c.pluginArgRetries
+}
+
+// PolicyRules方法返回配置文件中的权限策略规则：policy.<能力>和policy.default的键为能力名称或"default"，
+// plugins.<插件ID>.policy的键为"plugins.<插件ID>"。没有配置的规则使用插件包中的默认策略
+func (c Cfg) PolicyRules() map[string]string {
+	rules := make(map[string]string, len(c.policy))
+	for k, v := range c.policy {
+		rules[k] = strings.TrimSpace(v)
+	}
+	for name, v := range c.pluginCfg {
+		if id := strings.TrimSuffix(name, ".policy"); id != name {
+			rules["plugins."+id] = strings.TrimSpace(v)
+		}
+	}
+	return rules
+}
+
+// SessionsPath方法返回会话记录存放的路径
+func (c Cfg) SessionsPath() string {
+	return c.sessionsPath
+}
+
+// LogName方法返回日志文件的名称
+func (c Cfg) LogName() string {
+	return c.logName
+}
+
+func (c Cfg) OpenWeatherMapAPIKey() string {
+	return c.openWeatherMapAPIKey
+}
+
+// MalvusApiEndpoint方法返回Milvus API终端的地址
+func (c Cfg) MalvusApiEndpoint() string {
+	return c.malvusCfg.apiEndpoint
+}
+
+// SetMalvusApiEndpoint方法设置Milvus API终端的地址
+func (c Cfg) SetMalvusApiEndpoint(apiEndpoint string) Cfg {
+	c.malvusCfg.apiEndpoint = apiEndpoint
+	return c
+}
+
+// MalvusCollectionName方法返回Milvus中用于存储数据的集合名称
+func (c Cfg) MalvusCollectionName() string {
+	return c.malvusCfg.collectionName
+}
+
+// SetMalvusCollectionName方法设置Milvus中用于存储数据的集合名称
+func (c Cfg) SetMalvusCollectionName(collectionName string) Cfg {
+	c.malvusCfg.collectionName = collectionName
+	return c
+}
+
+// ContextBudget方法返回模型的上下文预算（token数）。优先使用context.max_tokens，
+// 否则按模型名称查表，名称不完全匹配时使用最长的前缀匹配，例如gpt-4-0613使用gpt-4的预算
+func (c Cfg) ContextBudget(model string) int {
+	if c.contextCfg.maxTokens > 0 {
+		return c.contextCfg.maxTokens
+	}
+	if budget, ok := c.contextCfg.budgets[model]; ok {
+		return budget
+	}
+
+	budget, matched := defaultContextBudget, ""
+	for name, b := range c.contextCfg.budgets {
+		if strings.HasPrefix(model, name) && len(name) > len(matched) {
+			budget, matched = b, name
+		}
+	}
+	return budget
+}
+
+// KeepRecentTurns方法返回上下文压缩时始终保留的最近对话轮数
+func (c Cfg) KeepRecentTurns() int {
+	return c.contextCfg.keepRecentTurns
+}
+
+// MaxToolResultChars方法返回单个函数结果在上下文中保留的最大字符数
+func (c Cfg) MaxToolResultChars() int {
+	return c.contextCfg.maxToolResultChars
+}
+
+// ServerAddr方法返回HTTP服务器的监听地址
+func (c Cfg) ServerAddr() string {
+	return c.serverCfg.addr
+}
+
+// ServerAPIKey方法返回访问HTTP API需要的密钥，为空表示不校验
+func (c Cfg) ServerAPIKey() string {
+	return c.serverCfg.apiKey
+}
+
+// ServerRequestTimeout方法返回HTTP服务器处理每个请求的最长时间
+func (c Cfg) ServerRequestTimeout() time.Duration {
+	return c.serverCfg.requestTimeout
+}
+
+// ServerSessionIdleTimeout方法返回serve模式下会话空闲多久后从内存中移除，0表示不移除
+func (c Cfg) ServerSessionIdleTimeout() time.Duration {
+	return c.serverCfg.sessionIdle
+}
+
+// WasmMemoryLimitMB方法返回WebAssembly插件每次调用可以使用的最大内存（MB）
+func (c Cfg) WasmMemoryLimitMB() int {
+	return c.wasmCfg.memoryLimitMB
+}
+
+// WasmTimeout方法返回WebAssembly插件每次调用的最长执行时间
+func (c Cfg) WasmTimeout() time.Duration {
+	return c.wasmCfg.timeout
+}
+
+// AgentMaxSteps方法返回一轮对话中模型最多调用插件的步数，模型的一次回复中的所有调用算一步
+func (c Cfg) AgentMaxSteps() int {
+	return c.agentCfg.maxSteps
+}
+
+// AgentMaxRepeatedCalls方法返回一轮对话中同一个插件以相同参数最多调用的次数
+func (c Cfg) AgentMaxRepeatedCalls() int {
+	return c.agentCfg.maxRepeatedCalls
+}
+
+// AgentTimeBudget方法返回一轮对话的总时间，0表示不限制
+func (c Cfg) AgentTimeBudget() time.Duration {
+	return c.agentCfg.timeBudget
+}
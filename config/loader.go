@@ -0,0 +1,548 @@
+package config
+
+import (
+	"flag"          // 用于解析命令行参数
+	"fmt"           // 用于格式化输出
+	"os"            // 用于读取文件和环境变量
+	"path/filepath" // 用于判断配置文件的扩展名
+	"sort"          // 用于对配置键排序
+	"strconv"       // 用于解析数字
+	"strings"       // 用于字符串处理
+	"time"          // 用于解析时长
+
+	"github.com/BurntSushi/toml" // TOML配置文件解析
+	"gopkg.in/yaml.v3"           // YAML配置文件解析
+)
+
+// envPrefix是所有环境变量的前缀
+const envPrefix = "CLARA_"
+
+// defaultConfigFiles是未指定配置文件时按顺序查找的文件
+var defaultConfigFiles = []string{"clara.yaml", "clara.yml", "clara.toml"}
+
+// option描述一个配置项在配置文件、环境变量和命令行参数中的名称
+type option struct {
+	key   string                           // 配置文件中的键，使用"."分隔层级
+	flag  string                           // 命令行参数名
+	usage string                           // 命令行参数说明
+	get   func(c Cfg) string               // 读取配置项的字符串值
+	set   func(c *Cfg, value string) error // 将字符串值写入配置
+}
+
+// env方法返回配置项对应的环境变量名，例如openai.api_key对应CLARA_OPENAI_API_KEY
+func (o option) env() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(o.key, ".", "_"))
+}
+
+// options列出了所有可加载的配置项
+var options = []option{
+	{
+		key:   "openai.api_key",
+		flag:  "openai-api-key",
+		usage: "OpenAI API的密钥",
+		get:   func(c Cfg) string { return c.openAiAPIKey },
+		set:   func(c *Cfg, v string) error { c.openAiAPIKey = v; return nil },
+	},
+	{
+		key:   "openai.base_url",
+		flag:  "openai-base-url",
+		usage: "OpenAI API的基础地址，需要包含/v1",
+		get:   func(c Cfg) string { return c.openAiBaseURL },
+		set:   func(c *Cfg, v string) error { c.openAiBaseURL = v; return nil },
+	},
+	{
+		key:   "openai.model",
+		flag:  "model",
+		usage: "对话使用的模型名称",
+		get:   func(c Cfg) string { return c.model },
+		set:   func(c *Cfg, v string) error { c.model = v; return nil },
+	},
+	{
+		key:   "openai.embedding_model",
+		flag:  "openai-embedding-model",
+		usage: "计算记忆向量使用的嵌入模型",
+		get:   func(c Cfg) string { return c.embedModel },
+		set:   func(c *Cfg, v string) error { c.embedModel = v; return nil },
+	},
+	{
+		key:   "provider",
+		flag:  "provider",
+		usage: "默认使用的模型服务，openai或providers下定义的名称",
+		get:   func(c Cfg) string { return c.provider },
+		set:   func(c *Cfg, v string) error { c.provider = strings.TrimSpace(v); return nil },
+	},
+	{
+		key:   "openweathermap.api_key",
+		flag:  "openweathermap-api-key",
+		usage: "OpenWeatherMap API的密钥",
+		get:   func(c Cfg) string { return c.openWeatherMapAPIKey },
+		set:   func(c *Cfg, v string) error { c.openWeatherMapAPIKey = v; return nil },
+	},
+	{
+		key:   "plugins_path",
+		flag:  "plugins-path",
+		usage: "插件存放的路径",
+		get:   func(c Cfg) string { return c.pluginsPath },
+		set:   func(c *Cfg, v string) error { c.pluginsPath = v; return nil },
+	},
+	{
+		key:   "plugins_watch_interval",
+		flag:  "plugins-watch-interval",
+		usage: "检查插件目录变化的间隔，例如2s，0表示不自动重新加载插件",
+		get:   func(c Cfg) string { return c.pluginsWatchInterval.String() },
+		set: func(c *Cfg, v string) (err error) {
+			c.pluginsWatchInterval, err = time.ParseDuration(strings.TrimSpace(v))
+			return err
+		},
+	},
+	{
+		key:   "plugin_timeout",
+		flag:  "plugin-timeout",
+		usage: "单次插件调用的默认超时时间，例如2m，0表示不限制",
+		get:   func(c Cfg) string { return c.pluginTimeout.String() },
+		set: func(c *Cfg, v string) (err error) {
+			c.pluginTimeout, err = time.ParseDuration(strings.TrimSpace(v))
+			return err
+		},
+	},
+	{
+		key:   "plugin_argument_retries",
+		flag:  "plugin-argument-retries",
+		usage: "一轮对话中模型给出无效参数后最多重试的次数",
+		get:   func(c Cfg) string { return strconv.Itoa(c.pluginArgRetries) },
+		set: func(c *Cfg, v string) (err error) {
+			c.pluginArgRetries, err = parseInt(v)
+			return err
+		},
+	},
+	{
+		key:   "sessions_path",
+		flag:  "sessions-path",
+		usage: "会话记录存放的路径",
+		get:   func(c Cfg) string { return c.sessionsPath },
+		set:   func(c *Cfg, v string) error { c.sessionsPath = v; return nil },
+	},
+	{
+		key:   "log_name",
+		flag:  "log-name",
+		usage: "日志文件的名称",
+		get:   func(c Cfg) string { return c.logName },
+		set:   func(c *Cfg, v string) error { c.logName = v; return nil },
+	},
+	{
+		key:   "context.max_tokens",
+		flag:  "context-max-tokens",
+		usage: "对话上下文的token预算，0表示按模型使用默认预算",
+		get:   func(c Cfg) string { return strconv.Itoa(c.contextCfg.maxTokens) },
+		set: func(c *Cfg, v string) (err error) {
+			c.contextCfg.maxTokens, err = parseInt(v)
+			return err
+		},
+	},
+	{
+		key:   "context.keep_recent_turns",
+		flag:  "context-keep-recent-turns",
+		usage: "压缩上下文时始终保留的最近对话轮数",
+		get:   func(c Cfg) string { return strconv.Itoa(c.contextCfg.keepRecentTurns) },
+		set: func(c *Cfg, v string) (err error) {
+			c.contextCfg.keepRecentTurns, err = parseInt(v)
+			return err
+		},
+	},
+	{
+		key:   "context.max_tool_result_chars",
+		flag:  "context-max-tool-result-chars",
+		usage: "单个函数结果在上下文中保留的最大字符数",
+		get:   func(c Cfg) string { return strconv.Itoa(c.contextCfg.maxToolResultChars) },
+		set: func(c *Cfg, v string) (err error) {
+			c.contextCfg.maxToolResultChars, err = parseInt(v)
+			return err
+		},
+	},
+	{
+		key:   "milvus.endpoint",
+		flag:  "milvus-endpoint",
+		usage: "Milvus服务器的API终端地址",
+		get:   func(c Cfg) string { return c.malvusCfg.apiEndpoint },
+		set:   func(c *Cfg, v string) error { c.malvusCfg.apiEndpoint = v; return nil },
+	},
+	{
+		key:   "milvus.collection",
+		flag:  "milvus-collection",
+		usage: "Milvus中用于存储记忆的集合名称",
+		get:   func(c Cfg) string { return c.malvusCfg.collectionName },
+		set:   func(c *Cfg, v string) error { c.malvusCfg.collectionName = v; return nil },
+	},
+	{
+		key:   "server.addr",
+		flag:  "server-addr",
+		usage: "serve模式下HTTP服务器的监听地址",
+		get:   func(c Cfg) string { return c.serverCfg.addr },
+		set:   func(c *Cfg, v string) error { c.serverCfg.addr = v; return nil },
+	},
+	{
+		key:   "server.api_key",
+		flag:  "server-api-key",
+		usage: "serve模式下访问API需要的Bearer密钥，为空时不校验",
+		get:   func(c Cfg) string { return c.serverCfg.apiKey },
+		set:   func(c *Cfg, v string) error { c.serverCfg.apiKey = v; return nil },
+	},
+	{
+		key:   "server.request_timeout",
+		flag:  "server-request-timeout",
+		usage: "serve模式下每个请求的最长处理时间，例如90s或2m",
+		get:   func(c Cfg) string { return c.serverCfg.requestTimeout.String() },
+		set: func(c *Cfg, v string) (err error) {
+			c.serverCfg.requestTimeout, err = time.ParseDuration(strings.TrimSpace(v))
+			return err
+		},
+	},
+	{
+		key:   "server.session_idle_timeout",
+		flag:  "server-session-idle-timeout",
+		usage: "serve模式下会话空闲多久后从内存中移除（之后的请求会从存储中重新加载），0表示不移除",
+		get:   func(c Cfg) string { return c.serverCfg.sessionIdle.String() },
+		set: func(c *Cfg, v string) (err error) {
+			c.serverCfg.sessionIdle, err = time.ParseDuration(strings.TrimSpace(v))
+			return err
+		},
+	},
+	{
+		key:   "wasm.memory_limit_mb",
+		flag:  "wasm-memory-limit-mb",
+		usage: "WebAssembly插件每次调用可以使用的最大内存（MB）",
+		get:   func(c Cfg) string { return strconv.Itoa(c.wasmCfg.memoryLimitMB) },
+		set: func(c *Cfg, v string) (err error) {
+			c.wasmCfg.memoryLimitMB, err = parseInt(v)
+			return err
+		},
+	},
+	{
+		key:   "wasm.timeout",
+		flag:  "wasm-timeout",
+		usage: "WebAssembly插件每次调用的最长执行时间，例如10s",
+		get:   func(c Cfg) string { return c.wasmCfg.timeout.String() },
+		set: func(c *Cfg, v string) (err error) {
+			c.wasmCfg.timeout, err = time.ParseDuration(strings.TrimSpace(v))
+			return err
+		},
+	},
+	{
+		key:   "agent.max_steps",
+		flag:  "agent-max-steps",
+		usage: "一轮对话中模型最多调用插件的步数",
+		get:   func(c Cfg) string { return strconv.Itoa(c.agentCfg.maxSteps) },
+		set: func(c *Cfg, v string) (err error) {
+			c.agentCfg.maxSteps, err = parseInt(v)
+			return err
+		},
+	},
+	{
+		key:   "agent.max_repeated_calls",
+		flag:  "agent-max-repeated-calls",
+		usage: "一轮对话中同一个插件以相同参数最多调用的次数",
+		get:   func(c Cfg) string { return strconv.Itoa(c.agentCfg.maxRepeatedCalls) },
+		set: func(c *Cfg, v string) (err error) {
+			c.agentCfg.maxRepeatedCalls, err = parseInt(v)
+			return err
+		},
+	},
+	{
+		key:   "agent.time_budget",
+		flag:  "agent-time-budget",
+		usage: "一轮对话的总时间，例如5m，0表示不限制",
+		get:   func(c Cfg) string { return c.agentCfg.timeBudget.String() },
+		set: func(c *Cfg, v string) (err error) {
+			c.agentCfg.timeBudget, err = time.ParseDuration(strings.TrimSpace(v))
+			return err
+		},
+	},
+}
+
+// prefixOption描述一组以相同前缀开头、键名由用户决定的配置项，只能在配置文件中设置，
+// 例如context.budgets.gpt-4
+type prefixOption struct {
+	prefix string
+	set    func(c *Cfg, name string, value string) error // name是去掉前缀后的键名
+}
+
+// prefixOptions列出了所有按前缀匹配的配置项
+var prefixOptions = []prefixOption{
+	{
+		prefix: "context.budgets.",
+		set: func(c *Cfg, model string, v string) error {
+			return setInt(&c.contextCfg.budgets, model, v)
+		},
+	},
+	{
+		prefix: "plugins.",
+		set: func(c *Cfg, name string, v string) error {
+			if !strings.Contains(name, ".") {
+				return fmt.Errorf("应为 plugins.<插件ID>.<键>")
+			}
+			if c.pluginCfg == nil {
+				c.pluginCfg = make(map[string]string)
+			}
+			c.pluginCfg[name] = v
+			return nil
+		},
+	},
+	{
+		prefix: "providers.",
+		set: func(c *Cfg, name string, v string) error {
+			if !strings.Contains(name, ".") {
+				return fmt.Errorf("应为 providers.<名称>.<键>")
+			}
+			if c.providers == nil {
+				c.providers = make(map[string]string)
+			}
+			c.providers[name] = v
+			return nil
+		},
+	},
+	{
+		prefix: "policy.",
+		set: func(c *Cfg, name string, v string) error {
+			if c.policy == nil {
+				c.policy = make(map[string]string)
+			}
+			c.policy[name] = v
+			return nil
+		},
+	},
+}
+
+// parseInt函数把字符串解析为非负整数
+func parseInt(v string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		return 0, fmt.Errorf("%q 不是整数", v)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("%d 不能为负数", n)
+	}
+	return n, nil
+}
+
+// setInt函数解析整数并写入map
+func setInt(m *map[string]int, key string, v string) error {
+	n, err := parseInt(v)
+	if err != nil {
+		return err
+	}
+	if *m == nil {
+		*m = make(map[string]int)
+	}
+	(*m)[key] = n
+	return nil
+}
+
+// Flags保存绑定到FlagSet上的配置参数，解析后交给Load使用
+type Flags struct {
+	configPath *string            // --config参数，指定配置文件
+	values     map[string]*string // 配置项的键到参数值的映射
+	fs         *flag.FlagSet
+}
+
+// BindFlags函数把所有配置项注册到给定的FlagSet上，调用方可以继续注册自己的参数
+func BindFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{
+		configPath: fs.String("config", "", "配置文件路径（YAML或TOML），也可以通过"+envPrefix+"CONFIG指定"),
+		values:     make(map[string]*string),
+		fs:         fs,
+	}
+
+	for _, o := range options {
+		f.values[o.key] = fs.String(o.flag, "", o.usage+"（环境变量"+o.env()+"）")
+	}
+
+	return f
+}
+
+// explicit方法返回命令行中显式设置过的配置项
+func (f *Flags) explicit() map[string]string {
+	set := make(map[string]string)
+	if f == nil || !f.fs.Parsed() {
+		return set
+	}
+
+	byFlag := make(map[string]string)
+	for _, o := range options {
+		byFlag[o.flag] = o.key
+	}
+
+	f.fs.Visit(func(fl *flag.Flag) {
+		if key, ok := byFlag[fl.Name]; ok {
+			set[key] = *f.values[key]
+		}
+	})
+
+	return set
+}
+
+// Load函数按 默认值 → 配置文件 → CLARA_*环境变量 → 命令行参数 的顺序加载配置，
+// 后面的来源会覆盖前面的来源。flags可以为nil，表示不读取命令行参数。
+func Load(flags *Flags) (Cfg, error) {
+	cfg := New()
+
+	path := ""
+	if flags != nil {
+		path = *flags.configPath
+	}
+	if path == "" {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	if path == "" {
+		path = findDefaultConfigFile()
+	}
+
+	if path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := apply(&cfg, values, "配置文件 "+path); err != nil {
+			return cfg, err
+		}
+	}
+
+	env := make(map[string]string)
+	for _, o := range options {
+		if v, ok := os.LookupEnv(o.env()); ok {
+			env[o.key] = v
+		}
+	}
+	if err := apply(&cfg, env, "环境变量"); err != nil {
+		return cfg, err
+	}
+
+	if err := apply(&cfg, flags.explicit(), "命令行参数"); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// findDefaultConfigFile函数在当前目录查找默认的配置文件
+func findDefaultConfigFile() string {
+	for _, name := range defaultConfigFiles {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// apply函数把键值对写入配置，source用于错误提示
+func apply(cfg *Cfg, values map[string]string, source string) error {
+	byKey := make(map[string]option)
+	for _, o := range options {
+		byKey[o.key] = o
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		var err error
+		if o, ok := byKey[k]; ok {
+			err = o.set(cfg, values[k])
+		} else if p, ok := findPrefixOption(k); ok {
+			err = p.set(cfg, strings.TrimPrefix(k, p.prefix), values[k])
+		} else {
+			return fmt.Errorf("%s: 未知的配置项 %q", source, k)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: 配置项 %q 无效: %v", source, k, err)
+		}
+	}
+
+	return nil
+}
+
+// findPrefixOption函数查找与键匹配的前缀配置项
+func findPrefixOption(key string) (prefixOption, bool) {
+	for _, p := range prefixOptions {
+		if strings.HasPrefix(key, p.prefix) && len(key) > len(p.prefix) {
+			return p, true
+		}
+	}
+	return prefixOption{}, false
+}
+
+// readConfigFile函数读取YAML或TOML配置文件，并展开为以"."连接的键
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		err = yaml.Unmarshal(data, &raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+
+	values := make(map[string]string)
+	flatten("", raw, values)
+	return values, nil
+}
+
+// flatten函数把嵌套的map展开为以"."连接的键，列表会以","连接
+func flatten(prefix string, in map[string]interface{}, out map[string]string) {
+	for k, v := range in {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+
+		switch val := v.(type) {
+		case map[string]interface{}:
+			flatten(key, val, out)
+		case []interface{}:
+			items := make([]string, 0, len(val))
+			for _, item := range val {
+				items = append(items, fmt.Sprint(item))
+			}
+			out[key] = strings.Join(items, ",")
+		case nil:
+			out[key] = ""
+		default:
+			out[key] = fmt.Sprint(val)
+		}
+	}
+}
+
+// Lookup方法按配置文件中的键读取配置项的字符串值，例如openweathermap.api_key或plugins.<插件ID>.<键>
+func (c Cfg) Lookup(key string) (string, bool) {
+	for _, o := range options {
+		if o.key == key {
+			return o.get(c), true
+		}
+	}
+	if name := strings.TrimPrefix(key, "plugins."); name != key {
+		v, ok := c.pluginCfg[name]
+		return v, ok
+	}
+	return "", false
+}
+
+// Values方法返回所有配置项的键和字符串值，包括plugins.*下的插件配置
+func (c Cfg) Values() map[string]string {
+	values := make(map[string]string, len(options)+len(c.pluginCfg))
+	for _, o := range options {
+		values[o.key] = o.get(c)
+	}
+	for name, v := range c.pluginCfg {
+		values["plugins."+name] = v
+	}
+	return values
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv函数在测试期间去掉所有CLARA_*环境变量，测试结束后恢复
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range append([]string{envPrefix + "CONFIG"}, envKeys()...) {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+}
+
+// envKeys函数返回所有配置项对应的环境变量名
+func envKeys() []string {
+	keys := make([]string, len(options))
+	for i, o := range options {
+		keys[i] = o.env()
+	}
+	return keys
+}
+
+// writeFile函数在临时目录中写入配置文件并返回路径
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// parseFlags函数把args解析为配置参数
+func parseFlags(t *testing.T, args ...string) *Flags {
+	t.Helper()
+	fs := flag.NewFlagSet("clara", flag.ContinueOnError)
+	flags := BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return flags
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	yamlFile := `
+openai:
+  model: file-model
+  base_url: http://file.example/v1
+plugins:
+  memory:
+    store: local
+`
+	tests := []struct {
+		name    string
+		file    string // 配置文件的内容，为空表示没有配置文件
+		env     map[string]string
+		args    []string
+		model   string
+		baseURL string
+	}{
+		{
+			name:    "defaults",
+			model:   "gpt-3.5-turbo-0613",
+			baseURL: "https://api.openai.com/v1",
+		},
+		{
+			name:    "file overrides defaults",
+			file:    yamlFile,
+			model:   "file-model",
+			baseURL: "http://file.example/v1",
+		},
+		{
+			name:    "env overrides file",
+			file:    yamlFile,
+			env:     map[string]string{"CLARA_OPENAI_MODEL": "env-model"},
+			model:   "env-model",
+			baseURL: "http://file.example/v1",
+		},
+		{
+			name:    "flags override env",
+			file:    yamlFile,
+			env:     map[string]string{"CLARA_OPENAI_MODEL": "env-model", "CLARA_OPENAI_BASE_URL": "http://env.example/v1"},
+			args:    []string{"--model", "flag-model"},
+			model:   "flag-model",
+			baseURL: "http://env.example/v1",
+		},
+		{
+			name:    "flag set to the empty string still wins",
+			env:     map[string]string{"CLARA_OPENAI_MODEL": "env-model"},
+			args:    []string{"--model="},
+			model:   "",
+			baseURL: "https://api.openai.com/v1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"--config", writeFile(t, "clara.yaml", tt.file)}, args...)
+			}
+
+			cfg, err := Load(parseFlags(t, args...))
+			if err != nil {
+				t.Fatalf("Load() = %v", err)
+			}
+			if cfg.Model() != tt.model {
+				t.Errorf("Model() = %q, want %q", cfg.Model(), tt.model)
+			}
+			if cfg.OpenAiBaseURL() != tt.baseURL {
+				t.Errorf("OpenAiBaseURL() = %q, want %q", cfg.OpenAiBaseURL(), tt.baseURL)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CLARA_CONFIG", writeFile(t, "clara.toml", "plugin_timeout = \"30s\"\n\n[plugins.memory]\nstore = \"local\"\n"))
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	if cfg.PluginTimeout("weather") != 30*time.Second {
+		t.Errorf("PluginTimeout() = %v, want 30s", cfg.PluginTimeout("weather"))
+	}
+	if v, ok := cfg.Lookup("plugins.memory.store"); !ok || v != "local" {
+		t.Errorf("Lookup(plugins.memory.store) = %q, %v; want local", v, ok)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		want string
+	}{
+		{
+			name: "unknown key in the file",
+			file: "openai:\n  modle: gpt-4\n",
+			want: `未知的配置项 "openai.modle"`,
+		},
+		{
+			name: "plugin setting without a key",
+			file: "plugins:\n  memory: local\n",
+			want: `配置项 "plugins.memory" 无效`,
+		},
+		{
+			name: "invalid number in env",
+			env:  map[string]string{"CLARA_AGENT_MAX_STEPS": "many"},
+			want: `环境变量: 配置项 "agent.max_steps" 无效`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			var args []string
+			if tt.file != "" {
+				args = []string{"--config", writeFile(t, "clara.yaml", tt.file)}
+			}
+
+			_, err := Load(parseFlags(t, args...))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Load() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
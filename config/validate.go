@@ -0,0 +1,239 @@
+package config
+
+import (
+	"fmt"     // 用于格式化输出
+	"net"     // 用于校验主机和端口
+	"net/url" // 用于校验URL
+	"os"      // 用于检查插件目录
+	"regexp"  // 用于校验集合名称
+	"sort"    // 用于按顺序报告插件配置的问题
+	"strings" // 用于拼接错误信息
+	"time"    // 用于校验插件的超时时间
+)
+
+// collectionNamePattern是Milvus允许的集合名称格式
+var collectionNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// policyKeys是policy下可以设置的键，与plugins包中的能力名称对应
+var policyKeys = []string{"default", "read-only", "network", "filesystem-write", "destructive"}
+
+// providerKeys是providers.<名称>下可以设置的键
+var providerKeys = []string{"type", "base_url", "api_key", "model", "embedding_model"}
+
+// providerTypes是模型服务可以使用的类型
+var providerTypes = []string{ProviderTypeOpenAI, ProviderTypeOllama}
+
+// policyDecisions是权限策略规则可以使用的值
+var policyDecisions = []string{"allow", "ask", "deny"}
+
+// ValidationError包含配置校验时发现的所有问题
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate方法检查所有配置项，一次性报告每一个缺失或格式错误的字段
+func (c Cfg) Validate() error {
+	var problems []string
+	addf := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// 只有默认使用内置的openai服务时才必须设置密钥，使用本地模型时可以不设置
+	if c.openAiAPIKey == "" && c.provider == BuiltinProvider {
+		addf("openai.api_key 未设置（环境变量 %sOPENAI_API_KEY 或参数 --openai-api-key）", envPrefix)
+	}
+
+	if c.openAiBaseURL == "" {
+		addf("openai.base_url 未设置")
+	} else if u, err := url.Parse(c.openAiBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		addf("openai.base_url %q 不是有效的http(s)地址", c.openAiBaseURL)
+	}
+
+	if c.model == "" {
+		addf("openai.model 未设置")
+	}
+	if c.embedModel == "" {
+		addf("openai.embedding_model 未设置")
+	}
+
+	providerNames := c.ProviderNames()
+	if !contains(providerNames, c.provider) {
+		addf("provider %q 不是已定义的模型服务，可以使用 %s", c.provider, strings.Join(providerNames, "、"))
+	}
+	for key := range c.providers {
+		if strings.HasPrefix(key, BuiltinProvider+".") {
+			addf("providers.%s 与内置的openai服务同名，请使用 openai.* 配置", BuiltinProvider)
+			break
+		}
+	}
+	for _, name := range providerNames[1:] {
+		addf := func(format string, args ...interface{}) {
+			addf("providers.%s: "+format, append([]interface{}{name}, args...)...)
+		}
+		var keys []string
+		for key := range c.providers {
+			if strings.HasPrefix(key, name+".") {
+				keys = append(keys, strings.TrimPrefix(key, name+"."))
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !contains(providerKeys, key) {
+				addf("未知的键 %q，可以设置 %s", key, strings.Join(providerKeys, "、"))
+			}
+		}
+
+		pc, ok := c.Provider(name)
+		if !ok {
+			addf("type 未设置，应为 %s 之一", strings.Join(providerTypes, "、"))
+			continue
+		}
+		if !contains(providerTypes, pc.Type) {
+			addf("type %q 应为 %s 之一", pc.Type, strings.Join(providerTypes, "、"))
+		}
+		if pc.BaseURL == "" {
+			addf("base_url 未设置")
+		} else if u, err := url.Parse(pc.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			addf("base_url %q 不是有效的http(s)地址", pc.BaseURL)
+		}
+		if pc.Model == "" {
+			addf("model 未设置")
+		}
+	}
+
+	if c.pluginsPath == "" {
+		addf("plugins_path 未设置")
+	} else if info, err := os.Stat(c.pluginsPath); err != nil || !info.IsDir() {
+		addf("plugins_path %q 不是一个存在的目录", c.pluginsPath)
+	}
+
+	if c.pluginsWatchInterval < 0 {
+		addf("plugins_watch_interval 不能为负数")
+	}
+
+	if c.pluginTimeout < 0 {
+		addf("plugin_timeout 不能为负数")
+	}
+	if c.pluginArgRetries < 0 {
+		addf("plugin_argument_retries 不能为负数")
+	}
+	names := make([]string, 0, len(c.pluginCfg))
+	for name := range c.pluginCfg {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch {
+		case strings.HasSuffix(name, ".timeout"):
+			if timeout, err := time.ParseDuration(strings.TrimSpace(c.pluginCfg[name])); err != nil || timeout < 0 {
+				addf("plugins.%s %q 应为不小于0的时长，例如30s", name, c.pluginCfg[name])
+			}
+		case strings.HasSuffix(name, ".policy"):
+			if !contains(policyDecisions, strings.TrimSpace(c.pluginCfg[name])) {
+				addf("plugins.%s %q 应为 %s 之一", name, c.pluginCfg[name], strings.Join(policyDecisions, "、"))
+			}
+		}
+	}
+
+	rules := make([]string, 0, len(c.policy))
+	for name := range c.policy {
+		rules = append(rules, name)
+	}
+	sort.Strings(rules)
+	for _, name := range rules {
+		if !contains(policyKeys, name) {
+			addf("policy.%s 不是已知的能力，可以设置 %s", name, strings.Join(policyKeys, "、"))
+		} else if !contains(policyDecisions, strings.TrimSpace(c.policy[name])) {
+			addf("policy.%s %q 应为 %s 之一", name, c.policy[name], strings.Join(policyDecisions, "、"))
+		}
+	}
+
+	if c.sessionsPath == "" {
+		addf("sessions_path 未设置")
+	}
+
+	if c.logName == "" {
+		addf("log_name 未设置")
+	}
+
+	if c.malvusCfg.apiEndpoint == "" {
+		addf("milvus.endpoint 未设置")
+	} else if !validEndpoint(c.malvusCfg.apiEndpoint) {
+		addf("milvus.endpoint %q 应为 host:port 或 http(s)://host:port", c.malvusCfg.apiEndpoint)
+	}
+
+	if c.malvusCfg.collectionName == "" {
+		addf("milvus.collection 未设置")
+	} else if !collectionNamePattern.MatchString(c.malvusCfg.collectionName) {
+		addf("milvus.collection %q 只能包含字母、数字和下划线，且不能以数字开头", c.malvusCfg.collectionName)
+	}
+
+	if c.contextCfg.keepRecentTurns < 1 {
+		addf("context.keep_recent_turns 至少为1")
+	}
+	if c.contextCfg.maxToolResultChars < 1 {
+		addf("context.max_tool_result_chars 至少为1")
+	}
+
+	if _, _, err := net.SplitHostPort(c.serverCfg.addr); err != nil {
+		addf("server.addr %q 应为 host:port 或 :port", c.serverCfg.addr)
+	}
+	if c.serverCfg.requestTimeout <= 0 {
+		addf("server.request_timeout 必须大于0")
+	}
+	if c.serverCfg.sessionIdle < 0 {
+		addf("server.session_idle_timeout 不能为负数")
+	}
+
+	// WebAssembly的32位地址空间最多4GB
+	if c.wasmCfg.memoryLimitMB < 1 || c.wasmCfg.memoryLimitMB > 4096 {
+		addf("wasm.memory_limit_mb 应在1到4096之间")
+	}
+	if c.wasmCfg.timeout <= 0 {
+		addf("wasm.timeout 必须大于0")
+	}
+
+	if c.agentCfg.maxSteps < 1 {
+		addf("agent.max_steps 至少为1")
+	}
+	if c.agentCfg.maxRepeatedCalls < 1 {
+		addf("agent.max_repeated_calls 至少为1")
+	}
+	if c.agentCfg.timeBudget < 0 {
+		addf("agent.time_budget 不能为负数")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validEndpoint函数检查地址是否为host:port或带端口的URL
+func validEndpoint(endpoint string) bool {
+	hostPort := endpoint
+	if strings.Contains(endpoint, "://") {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		hostPort = u.Host
+	}
+
+	host, port, err := net.SplitHostPort(hostPort)
+	return err == nil && host != "" && port != ""
+}
+
+// contains函数判断列表中是否包含value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	pluginsDir := t.TempDir()
+	valid := func() Cfg {
+		cfg := New().SetOpenAiAPIKey("sk-test")
+		cfg.pluginsPath = pluginsDir
+		return cfg
+	}
+
+	tests := []struct {
+		name     string
+		cfg      func() Cfg
+		problems []string
+	}{
+		{
+			name: "valid",
+			cfg:  valid,
+		},
+		{
+			name: "every problem is reported",
+			cfg: func() Cfg {
+				cfg := valid().SetOpenAiAPIKey("").SetModel("")
+				cfg.openAiBaseURL = "api.openai.com"
+				cfg.agentCfg.maxSteps = 0
+				return cfg
+			},
+			problems: []string{
+				"openai.api_key 未设置（环境变量 CLARA_OPENAI_API_KEY 或参数 --openai-api-key）",
+				`openai.base_url "api.openai.com" 不是有效的http(s)地址`,
+				"openai.model 未设置",
+				"agent.max_steps 至少为1",
+			},
+		},
+		{
+			name: "api key is optional for another provider",
+			cfg: func() Cfg {
+				cfg := valid().SetOpenAiAPIKey("")
+				cfg.provider = "local"
+				cfg.providers = map[string]string{"local.type": "ollama", "local.base_url": "http://localhost:11434", "local.model": "llama3"}
+				return cfg
+			},
+		},
+		{
+			name: "plugin and policy settings",
+			cfg: func() Cfg {
+				cfg := valid()
+				cfg.pluginCfg = map[string]string{"weather.timeout": "soon", "memory.policy": "maybe", "memory.store": "local"}
+				cfg.policy = map[string]string{"network": "deny", "delete": "ask"}
+				return cfg
+			},
+			problems: []string{
+				`plugins.memory.policy "maybe" 应为 allow、ask、deny 之一`,
+				`plugins.weather.timeout "soon" 应为不小于0的时长，例如30s`,
+				"policy.delete 不是已知的能力，可以设置 default、read-only、network、filesystem-write、destructive",
+			},
+		},
+		{
+			name: "missing plugins directory",
+			cfg: func() Cfg {
+				cfg := valid()
+				cfg.pluginsPath = pluginsDir + "/missing"
+				return cfg
+			},
+			problems: []string{`plugins_path "` + pluginsDir + `/missing" 不是一个存在的目录`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg().Validate()
+			if tt.problems == nil {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Validate() = %v, want *ValidationError", err)
+			}
+			if !reflect.DeepEqual(validationErr.Problems, tt.problems) {
+				t.Errorf("Problems = %q, want %q", validationErr.Problems, tt.problems)
+			}
+		})
+	}
+}
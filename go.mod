@@ -3,15 +3,20 @@ module github.com/wangergou2023/clara
 go 1.19
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/araddon/dateparse v0.0.0-20210429162001-6b43995a97de
 	github.com/charmbracelet/bubbles v0.16.1
 	github.com/charmbracelet/bubbletea v0.24.2
 	github.com/charmbracelet/lipgloss v0.7.1
 	github.com/inancgumus/screen v0.0.0-20190314163918-06e984b86ed3
 	github.com/manifoldco/promptui v0.9.0
+	github.com/mattn/go-isatty v0.0.18
 	github.com/milvus-io/milvus-sdk-go/v2 v2.2.7
 	github.com/mitchellh/go-wordwrap v1.0.1
+	github.com/sashabaranov/go-openai v1.20.5
 	github.com/sirupsen/logrus v1.4.2
+	github.com/tetratelabs/wazero v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -30,7 +35,6 @@ require (
 	github.com/kr/pretty v0.3.0 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
-	github.com/mattn/go-isatty v0.0.18 // indirect
 	github.com/mattn/go-localereader v0.0.1 // indirect
 	github.com/mattn/go-runewidth v0.0.14 // indirect
 	github.com/milvus-io/milvus-proto/go-api/v2 v2.2.10 // indirect
@@ -41,7 +45,6 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/rogpeppe/go-internal v1.8.1 // indirect
-	github.com/sashabaranov/go-openai v1.20.5 // indirect
 	github.com/tidwall/gjson v1.14.4 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
@@ -0,0 +1,84 @@
+// llm包定义了Clara使用的模型服务接口，以及OpenAI兼容服务和Ollama的实现
+package llm
+
+import (
+	"context" // 用于控制请求、超时和取消
+	"fmt"     // 用于格式化输出
+	"sort"    // 用于按名称排列模型服务
+
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/config"    // 配置
+)
+
+// 模型服务的类型
+const (
+	TypeOpenAI = config.ProviderTypeOpenAI // OpenAI或兼容OpenAI接口的服务，例如llama.cpp的server
+	TypeOllama = config.ProviderTypeOllama // Ollama的原生接口
+)
+
+// StreamHandler在收到模型回复的每一段文本时被调用，用于把回复实时交给调用方
+type StreamHandler func(chunk string)
+
+// Provider是一个模型服务。请求和回复统一使用OpenAI的格式，其他服务的实现负责转换：
+// 请求中的Tools和ToolChoice描述可以调用的工具，回复中的ToolCalls是模型要求的工具调用，
+// 工具的结果以tool角色的消息放回对话。请求中的Model为空时使用服务配置的模型
+type Provider interface {
+	Name() string  // 配置中的名称
+	Model() string // 默认的对话模型
+
+	// Chat函数发送一次对话请求，等待完整的回复
+	Chat(ctx context.Context, req openai.ChatCompletionRequest) (*openai.ChatCompletionResponse, error)
+	// ChatStream函数以流式方式发送对话请求，把每段文本交给onChunk，最后返回与Chat相同结构的完整回复
+	ChatStream(ctx context.Context, req openai.ChatCompletionRequest, onChunk StreamHandler) (*openai.ChatCompletionResponse, error)
+	// Embeddings函数使用服务配置的嵌入模型计算每段文本的向量，结果与input一一对应
+	Embeddings(ctx context.Context, input []string) ([][]float32, error)
+}
+
+// New函数按配置创建名为name的模型服务
+func New(cfg config.Cfg, name string) (Provider, error) {
+	pc, ok := cfg.Provider(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+
+	switch pc.Type {
+	case TypeOpenAI:
+		return NewOpenAI(pc), nil
+	case TypeOllama:
+		return NewOllama(pc), nil
+	default:
+		return nil, fmt.Errorf("provider %s has unknown type %q", name, pc.Type)
+	}
+}
+
+// NewAll函数创建配置中的所有模型服务，无法创建的服务会输出错误信息并被跳过
+func NewAll(cfg config.Cfg) map[string]Provider {
+	providers := make(map[string]Provider)
+	for _, name := range cfg.ProviderNames() {
+		p, err := New(cfg, name)
+		if err != nil {
+			fmt.Printf("Error creating provider %s: %v\n", name, err)
+			continue
+		}
+		providers[name] = p
+	}
+	return providers
+}
+
+// Names函数返回按名称排序的模型服务名称
+func Names(providers map[string]Provider) []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// withModel函数在请求没有指定模型时使用服务配置的模型
+func withModel(req openai.ChatCompletionRequest, model string) openai.ChatCompletionRequest {
+	if req.Model == "" {
+		req.Model = model
+	}
+	return req
+}
@@ -0,0 +1,347 @@
+package llm
+
+import (
+	"bufio"         // 用于逐行读取流式回复
+	"bytes"         // 用于构造请求体
+	"context"       // 用于控制请求、超时和取消
+	"crypto/rand"   // 用于生成回复和工具调用的ID
+	"encoding/hex"  // 用于编码ID
+	"encoding/json" // 用于编码请求和解析回复
+	"fmt"           // 用于格式化输出
+	"io"            // 用于读取错误回复
+	"net/http"      // 用于访问Ollama的HTTP接口
+	"strings"       // 用于拼接回复内容
+	"time"          // 用于解析回复的创建时间
+
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/config"    // 配置
+)
+
+// ollamaProvider通过Ollama的原生接口（/api/chat和/api/embed）访问本地模型。
+// Ollama不返回工具调用的ID，也不支持tool_choice，这些由这里转换
+type ollamaProvider struct {
+	name           string
+	baseURL        string
+	model          string
+	embeddingModel string
+	client         *http.Client
+}
+
+// NewOllama函数创建访问Ollama的模型服务，BaseURL为Ollama的地址，例如http://localhost:11434
+func NewOllama(pc config.ProviderCfg) Provider {
+	return &ollamaProvider{
+		name:           pc.Name,
+		baseURL:        strings.TrimSuffix(pc.BaseURL, "/"),
+		model:          pc.Model,
+		embeddingModel: pc.EmbeddingModel,
+		client:         &http.Client{},
+	}
+}
+
+// ollamaMessage是Ollama对话中的一条消息
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"` // tool消息对应的函数名
+}
+
+// ollamaToolCall是Ollama回复中的工具调用，参数是JSON对象而不是字符串
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// ollamaChatRequest是/api/chat的请求体，工具定义与OpenAI的格式相同
+type ollamaChatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []ollamaMessage        `json:"messages"`
+	Tools    []openai.Tool          `json:"tools,omitempty"`
+	Format   string                 `json:"format,omitempty"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+	Stream   bool                   `json:"stream"`
+}
+
+// ollamaChatResponse是/api/chat的回复，流式回复的每一行也是这个结构，最后一行的Done为true
+type ollamaChatResponse struct {
+	Model           string        `json:"model"`
+	CreatedAt       time.Time     `json:"created_at"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+func (p *ollamaProvider) Name() string {
+	return p.name
+}
+
+func (p *ollamaProvider) Model() string {
+	return p.model
+}
+
+func (p *ollamaProvider) Chat(ctx context.Context, req openai.ChatCompletionRequest) (*openai.ChatCompletionResponse, error) {
+	return p.chat(ctx, req, false, nil)
+}
+
+func (p *ollamaProvider) ChatStream(ctx context.Context, req openai.ChatCompletionRequest, onChunk StreamHandler) (*openai.ChatCompletionResponse, error) {
+	return p.chat(ctx, req, true, onChunk)
+}
+
+// chat函数把OpenAI格式的请求转换后发送给/api/chat，并把回复组装成OpenAI格式。
+// 流式回复是每行一个JSON对象，工具调用总是完整地出现在某一行中
+func (p *ollamaProvider) chat(ctx context.Context, req openai.ChatCompletionRequest, stream bool, onChunk StreamHandler) (*openai.ChatCompletionResponse, error) {
+	req = withModel(req, p.model)
+	body := ollamaChatRequest{
+		Model:    req.Model,
+		Messages: toOllamaMessages(req.Messages),
+		Tools:    ollamaTools(req.Tools, req.ToolChoice),
+		Options:  ollamaOptions(req),
+		Stream:   stream,
+	}
+	if req.ResponseFormat != nil && req.ResponseFormat.Type == openai.ChatCompletionResponseFormatTypeJSONObject {
+		body.Format = "json"
+	}
+
+	resp, err := p.post(ctx, "/api/chat", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	var calls []openai.ToolCall
+	var last ollamaChatResponse
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // 非流式回复只有一行，可能很长
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, fmt.Errorf("error parsing response from %s: %v", p.name, err)
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("%s: %s", p.name, chunk.Error)
+		}
+
+		if chunk.Message.Content != "" {
+			content.WriteString(chunk.Message.Content)
+			if onChunk != nil {
+				onChunk(chunk.Message.Content)
+			}
+		}
+		for _, tc := range chunk.Message.ToolCalls {
+			calls = append(calls, openai.ToolCall{
+				ID:   newID("call_"),
+				Type: openai.ToolTypeFunction,
+				Function: openai.FunctionCall{
+					Name:      tc.Function.Name,
+					Arguments: ollamaArguments(tc.Function.Arguments),
+				},
+			})
+		}
+		last = chunk
+		if chunk.Done {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !last.Done {
+		return nil, fmt.Errorf("response from %s ended unexpectedly", p.name)
+	}
+
+	finishReason := openai.FinishReasonStop
+	switch {
+	case len(calls) > 0:
+		finishReason = openai.FinishReasonToolCalls
+	case last.DoneReason == "length":
+		finishReason = openai.FinishReasonLength
+	}
+
+	return &openai.ChatCompletionResponse{
+		ID:      newID("chatcmpl-"),
+		Object:  "chat.completion",
+		Created: last.CreatedAt.Unix(),
+		Model:   last.Model,
+		Choices: []openai.ChatCompletionChoice{
+			{
+				Message: openai.ChatCompletionMessage{
+					Role:      openai.ChatMessageRoleAssistant,
+					Content:   content.String(),
+					ToolCalls: calls,
+				},
+				FinishReason: finishReason,
+			},
+		},
+		Usage: openai.Usage{
+			PromptTokens:     last.PromptEvalCount,
+			CompletionTokens: last.EvalCount,
+			TotalTokens:      last.PromptEvalCount + last.EvalCount,
+		},
+	}, nil
+}
+
+func (p *ollamaProvider) Embeddings(ctx context.Context, input []string) ([][]float32, error) {
+	resp, err := p.post(ctx, "/api/embed", map[string]interface{}{"model": p.embeddingModel, "input": input})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing embeddings from %s: %v", p.name, err)
+	}
+	if len(result.Embeddings) != len(input) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(input), len(result.Embeddings))
+	}
+	return result.Embeddings, nil
+}
+
+// post函数以JSON发送请求，状态码不是200时返回包含状态码和错误信息的错误
+func (p *ollamaProvider) post(ctx context.Context, path string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(raw, &apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = strings.TrimSpace(string(raw))
+		}
+		// 与go-openai的错误格式一致，便于按状态码处理
+		return nil, fmt.Errorf("%s error, status code: %d, message: %s", p.name, resp.StatusCode, apiErr.Error)
+	}
+	return resp, nil
+}
+
+// toOllamaMessages函数把OpenAI格式的消息转换为Ollama的消息。工具调用的参数从字符串转换为JSON对象，
+// tool消息按ToolCallID找到对应的函数名
+func toOllamaMessages(messages []openai.ChatCompletionMessage) []ollamaMessage {
+	names := make(map[string]string) // 工具调用ID到函数名的映射
+	out := make([]ollamaMessage, 0, len(messages))
+	for _, m := range messages {
+		content := m.Content
+		if content == "" {
+			for _, part := range m.MultiContent {
+				content += part.Text
+			}
+		}
+		msg := ollamaMessage{Role: m.Role, Content: content}
+
+		for _, call := range m.ToolCalls {
+			names[call.ID] = call.Function.Name
+			var tc ollamaToolCall
+			tc.Function.Name = call.Function.Name
+			tc.Function.Arguments = json.RawMessage("{}")
+			arguments := strings.TrimSpace(call.Function.Arguments)
+			if arguments != "" && json.Valid([]byte(arguments)) {
+				tc.Function.Arguments = json.RawMessage(arguments)
+			}
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+		if m.Role == openai.ChatMessageRoleTool {
+			msg.ToolName = names[m.ToolCallID]
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+// ollamaTools函数按tool_choice选择发送给Ollama的工具：none表示不提供工具，指定了函数时只提供该函数
+func ollamaTools(tools []openai.Tool, choice interface{}) []openai.Tool {
+	var name string
+	switch c := choice.(type) {
+	case string:
+		if c == "none" {
+			return nil
+		}
+	case openai.ToolChoice:
+		name = c.Function.Name
+	case *openai.ToolChoice:
+		if c != nil {
+			name = c.Function.Name
+		}
+	}
+	if name == "" {
+		return tools
+	}
+
+	for _, tool := range tools {
+		if tool.Function != nil && tool.Function.Name == name {
+			return []openai.Tool{tool}
+		}
+	}
+	return tools
+}
+
+// ollamaOptions函数把请求中的采样参数转换为Ollama的options
+func ollamaOptions(req openai.ChatCompletionRequest) map[string]interface{} {
+	options := make(map[string]interface{})
+	if req.Temperature != 0 {
+		options["temperature"] = req.Temperature
+	}
+	if req.TopP != 0 {
+		options["top_p"] = req.TopP
+	}
+	if req.MaxTokens > 0 {
+		options["num_predict"] = req.MaxTokens
+	}
+	if len(req.Stop) > 0 {
+		options["stop"] = req.Stop
+	}
+	if req.Seed != nil {
+		options["seed"] = *req.Seed
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	return options
+}
+
+// ollamaArguments函数把Ollama返回的参数转换为OpenAI格式的JSON字符串，参数是字符串时原样使用
+func ollamaArguments(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return "{}"
+	}
+	return string(raw)
+}
+
+// newID函数生成回复和工具调用的ID，插件的结果通过工具调用的ID与调用对应
+func newID(prefix string) string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("error generating id: %v", err))
+	}
+	return prefix + hex.EncodeToString(b)
+}
@@ -0,0 +1,180 @@
+package llm
+
+import (
+	"context" // 用于控制请求、超时和取消
+	"errors"  // 用于判断流是否结束
+	"fmt"     // 用于格式化输出
+	"io"      // 提供io.EOF
+	"sort"    // 用于按序号排列工具调用
+	"strings" // 用于拼接回复内容
+
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/config"    // 配置
+)
+
+// openAIProvider通过OpenAI的接口访问模型，也可以用于llama.cpp、vLLM等兼容OpenAI接口的服务
+type openAIProvider struct {
+	name           string
+	model          string
+	embeddingModel string
+	client         *openai.Client
+}
+
+// NewOpenAI函数创建OpenAI兼容的模型服务，BaseURL需要包含"/v1"，APIKey可以为空
+func NewOpenAI(pc config.ProviderCfg) Provider {
+	clientCfg := openai.DefaultConfig(pc.APIKey)
+	clientCfg.BaseURL = pc.BaseURL
+
+	return &openAIProvider{
+		name:           pc.Name,
+		model:          pc.Model,
+		embeddingModel: pc.EmbeddingModel,
+		client:         openai.NewClientWithConfig(clientCfg),
+	}
+}
+
+func (p *openAIProvider) Name() string {
+	return p.name
+}
+
+func (p *openAIProvider) Model() string {
+	return p.model
+}
+
+func (p *openAIProvider) Chat(ctx context.Context, req openai.ChatCompletionRequest) (*openai.ChatCompletionResponse, error) {
+	req = withModel(req, p.model)
+	req.Stream = false
+
+	resp, err := p.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return nil, fmt.Errorf("empty response from model")
+	}
+	return &resp, nil
+}
+
+func (p *openAIProvider) ChatStream(ctx context.Context, req openai.ChatCompletionRequest, onChunk StreamHandler) (*openai.ChatCompletionResponse, error) {
+	req = withModel(req, p.model)
+	req.Stream = true
+
+	stream, err := p.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	return collectStream(stream, onChunk)
+}
+
+func (p *openAIProvider) Embeddings(ctx context.Context, input []string) ([][]float32, error) {
+	resp, err := p.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input: input,
+		Model: openai.EmbeddingModel(p.embeddingModel),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Data) != len(input) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(input), len(resp.Data))
+	}
+
+	vectors := make([][]float32, len(input))
+	for i, data := range resp.Data {
+		index := data.Index
+		if index < 0 || index >= len(vectors) {
+			index = i
+		}
+		vectors[index] = data.Embedding
+	}
+	return vectors, nil
+}
+
+// collectStream函数读取流式回复直到结束，把每段文本交给onChunk，
+// 同时累积工具调用的增量，最后组装成与非流式接口相同的回复结构
+func collectStream(stream *openai.ChatCompletionStream, onChunk StreamHandler) (*openai.ChatCompletionResponse, error) {
+	var content strings.Builder
+	var finishReason openai.FinishReason
+	var id, model string
+	var created int64
+	toolCalls := make(map[int]*openai.ToolCall) // 按序号累积的工具调用
+
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		id, model, created = chunk.ID, chunk.Model, chunk.Created
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		choice := chunk.Choices[0]
+		delta := choice.Delta
+
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			if onChunk != nil {
+				onChunk(delta.Content)
+			}
+		}
+
+		// 一次回复可能包含多个工具调用，函数名和参数会被拆成多段返回，需要按序号依次拼接
+		for i, tc := range delta.ToolCalls {
+			index := i
+			if tc.Index != nil {
+				index = *tc.Index
+			}
+
+			call, ok := toolCalls[index]
+			if !ok {
+				call = &openai.ToolCall{Type: openai.ToolTypeFunction}
+				toolCalls[index] = call
+			}
+			if tc.ID != "" {
+				call.ID = tc.ID
+			}
+			if tc.Type != "" {
+				call.Type = tc.Type
+			}
+			call.Function.Name += tc.Function.Name
+			call.Function.Arguments += tc.Function.Arguments
+		}
+
+		if choice.FinishReason != "" {
+			finishReason = choice.FinishReason
+		}
+	}
+
+	indexes := make([]int, 0, len(toolCalls))
+	for index := range toolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	var calls []openai.ToolCall
+	for _, index := range indexes {
+		calls = append(calls, *toolCalls[index])
+	}
+
+	return &openai.ChatCompletionResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   model,
+		Choices: []openai.ChatCompletionChoice{
+			{
+				Message: openai.ChatCompletionMessage{
+					Role:      openai.ChatMessageRoleAssistant,
+					Content:   content.String(),
+					ToolCalls: calls,
+				},
+				FinishReason: finishReason,
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,16 @@
+package llm
+
+// EstimateTokens函数估算文本的token数量。没有引入分词器，
+// 按照ASCII字符大约每4个一个token、中文等其他字符大约每个一个token来估算。
+// 对话上下文的预算和插件自己的提示长度都用它估算，保证两者一致
+func EstimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < 128 {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return other + (ascii+3)/4
+}
@@ -2,36 +2,271 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"text/tabwriter"
 
+	"github.com/mattn/go-isatty"
 	openai "github.com/sashabaranov/go-openai"
 	"github.com/wangergou2023/clara/assistant"
+	"github.com/wangergou2023/clara/commands"
 	"github.com/wangergou2023/clara/config"
+	"github.com/wangergou2023/clara/plugins"
+	_ "github.com/wangergou2023/clara/plugins/source/builtin"
+	"github.com/wangergou2023/clara/store"
+	"github.com/wangergou2023/clara/tui"
 )
 
-var cfg = config.New()
-
 func main() {
-	fmt.Println("Clara is starting up... Please wait a moment.")
+	// clara serve [参数] 以HTTP服务器的方式运行
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
+	}
 
-	config := openai.DefaultConfig(cfg.OpenAiAPIKey())
-	//need"/v1"
-	config.BaseURL = "https://llxspace.website/v1"
-	openaiClient := openai.NewClientWithConfig(config)
+	flags := config.BindFlags(flag.CommandLine)
+	resume := flag.String("resume", "", "恢复指定ID的会话")
+	listSessions := flag.Bool("list-sessions", false, "列出已保存的会话后退出")
+	plain := flag.Bool("plain", false, "使用逐行输入的简单界面，而不是全屏界面")
+	flag.Parse()
 
-	clara := assistant.Start(cfg, openaiClient)
+	// 列出会话只需要读取会话目录，不用加载插件和连接模型服务
+	if *listSessions {
+		printSessions(loadConfig(flags))
+		return
+	}
+
+	_, clara := start(flags)
+
+	var session *assistant.Session
+	var err error
+	if *resume != "" {
+		session, err = clara.LoadSession(*resume)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resuming session %s: %v\n", *resume, err)
+			os.Exit(1)
+		}
+	} else {
+		session, err = clara.NewSession()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting session: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	registry := commands.NewRegistry()
+	for _, err := range registry.RegisterPluginCommands() {
+		fmt.Println("Error registering plugin commands:", err)
+	}
+
+	// 标准输入输出不是终端时（例如管道）使用简单界面
+	if !*plain && isatty.IsTerminal(os.Stdin.Fd()) && isatty.IsTerminal(os.Stdout.Fd()) {
+		session, err = tui.Run(clara, session, registry)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Session %s saved.\n", session.ID())
+		return
+	}
+
+	if *resume != "" {
+		printHistory(session)
+	}
+	fmt.Printf("Session: %s\n", session.ID())
 
-	reader := bufio.NewReader(os.Stdin)
+	// Ctrl-C取消正在进行的一轮对话，而不是退出Clara
+	turns := &interrupter{}
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		for range interrupts {
+			if !turns.interrupt() {
+				fmt.Print("\n(Ctrl-D or /exit to quit)\n-> ")
+			}
+		}
+	}()
+
+	lines := readLines(os.Stdin)
 	fmt.Println("Conversation")
 	fmt.Println("---------------------")
 
 	for {
 		fmt.Print("-> ")
-		text, _ := reader.ReadString('\n')
-		// convert CRLF to LF
-		text = strings.Replace(text, "\n", "", -1)
-		clara.Message(text)
+		text, ok := <-lines
+		if !ok { // 输入结束（例如Ctrl-D），会话已经保存，可以通过--resume恢复
+			fmt.Printf("\nSession %s saved.\n", session.ID())
+			return
+		}
+		if commands.IsCommand(text) {
+			ctx := &commands.Context{Assistant: clara, Session: session}
+			output, cmdErr := registry.Dispatch(ctx, text)
+			session = ctx.Session
+			if errors.Is(cmdErr, commands.ErrExit) {
+				fmt.Printf("Session %s saved.\n", session.ID())
+				return
+			}
+			if cmdErr != nil {
+				fmt.Println("Error:", cmdErr)
+			} else if output != "" {
+				fmt.Println(output)
+			}
+		} else if strings.TrimSpace(text) != "" {
+			chat(turns, session, text, lines)
+		}
+	}
+}
+
+// readLines函数在后台逐行读取输入，去掉行尾的换行符，输入结束时关闭返回的通道。
+// 对话和插件调用的确认都从这个通道读取，等待确认时可以被Ctrl-C中止
+func readLines(input *os.File) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		reader := bufio.NewReader(input)
+		for {
+			text, err := reader.ReadString('\n')
+			// convert CRLF to LF
+			text = strings.Replace(text, "\n", "", -1)
+			if err == nil || text != "" {
+				lines <- text
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return lines
+}
+
+// chat函数发送一条消息并把回复逐段输出到终端，按下Ctrl-C时中止这一轮对话。
+// 权限策略要求确认的插件调用会显示完整的参数，并从lines读取用户的回答
+func chat(turns *interrupter, session *assistant.Session, text string, lines <-chan string) {
+	ctx, done := turns.start()
+	defer done()
+
+	fmt.Print("Clara:")
+	_, err := session.MessageContext(ctx, text, assistant.Handler{
+		OnChunk: func(chunk string) {
+			fmt.Print(chunk)
+		},
+		OnApproval: func(ctx context.Context, approval assistant.Approval) (bool, error) {
+			fmt.Printf("\nClara wants to call %s (%s; rule %s) with arguments:\n%s\nAllow? [y/N] ",
+				approval.Call.Function.Name, plugins.FormatCapabilities(approval.Capabilities), approval.Rule, approval.Arguments())
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case answer, ok := <-lines:
+				if !ok {
+					return false, nil // 输入已经结束，无法确认
+				}
+				answer = strings.ToLower(strings.TrimSpace(answer))
+				return answer == "y" || answer == "yes", nil
+			}
+		},
+	})
+	fmt.Print("\r\n")
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("(cancelled)")
+	}
+}
+
+// interrupter记录正在进行的一轮对话，用于在收到Ctrl-C时取消它
+type interrupter struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// start函数开始一轮对话，返回这一轮使用的ctx和结束时需要调用的done
+func (i *interrupter) start() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	i.mu.Lock()
+	i.cancel = cancel
+	i.mu.Unlock()
+
+	return ctx, func() {
+		i.mu.Lock()
+		i.cancel = nil
+		i.mu.Unlock()
+		cancel()
+	}
+}
+
+// interrupt函数取消正在进行的一轮对话，没有对话进行时返回false
+func (i *interrupter) interrupt() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.cancel == nil {
+		return false
+	}
+	i.cancel()
+	i.cancel = nil
+	return true
+}
+
+// loadConfig函数按默认值、配置文件、环境变量和命令行参数的顺序加载配置，加载失败时退出
+func loadConfig(flags *config.Flags) config.Cfg {
+	cfg, err := config.Load(flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	return cfg
+}
+
+// start函数加载并校验配置，然后启动助手，配置有误时退出
+func start(flags *config.Flags) (config.Cfg, *assistant.Assistant) {
+	cfg := loadConfig(flags)
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	fmt.Println("Clara is starting up... Please wait a moment.")
+
+	return cfg, assistant.Start(cfg)
+}
+
+// printSessions函数打印会话目录中保存的会话列表
+func printSessions(cfg config.Cfg) {
+	sessionStore, err := store.NewJSONLStore(cfg.SessionsPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening session store: %v\n", err)
+		os.Exit(1)
+	}
+	sessions, err := sessionStore.List()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listing sessions: %v\n", err)
+		os.Exit(1)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUPDATED\tMESSAGES\tTITLE")
+	for _, info := range sessions {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", info.ID, info.UpdatedAt.Format("2006-01-02 15:04"), info.Messages, info.Title)
+	}
+	w.Flush()
+}
+
+// printHistory函数打印恢复的会话中用户和助手的消息
+func printHistory(session *assistant.Session) {
+	for _, message := range session.History() {
+		if message.Content == "" {
+			continue
+		}
+		switch message.Role {
+		case openai.ChatMessageRoleUser:
+			fmt.Printf("-> %s\n", message.Content)
+		case openai.ChatMessageRoleAssistant:
+			fmt.Printf("Clara:%s\n", message.Content)
+		}
 	}
 }
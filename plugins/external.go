@@ -0,0 +1,384 @@
+package plugins
+
+import (
+	"bufio"         // 用于按行读取插件输出
+	"context"       // 用于取消请求和设置超时
+	"encoding/json" // 用于编码和解码JSON-RPC消息
+	"errors"        // 用于判断超时错误
+	"fmt"           // 用于格式化输出
+	"io"            // 用于读写管道
+	"os"            // 用于读取插件目录
+	"os/exec"       // 用于启动插件进程
+	"path/filepath" // 用于文件路径操作
+	"runtime"       // 用于判断操作系统
+	"strings"       // 用于判断可执行文件的扩展名
+	"sync"          // 用于串行访问插件进程
+	"time"          // 用于请求超时
+
+	"github.com/sashabaranov/go-openai"     // OpenAI GPT库
+	"github.com/wangergou2023/clara/config" // 配置包
+	"github.com/wangergou2023/clara/llm"    // 模型服务
+)
+
+// 外部插件放在插件目录的external子目录中，每个可执行文件是一个插件。
+// Clara启动插件进程后通过标准输入输出交换JSON-RPC 2.0消息，每条消息占一行：
+//
+//	describe  无参数，返回 {"id": "...", "description": "...", "function": <OpenAI函数定义>, "capabilities": ["network", ...]}
+//	init      参数 {"config": {"openai.model": "...", "plugins.<id>.<键>": "...", ...}}，只包含sharedConfigKeys和插件自己的配置
+//	execute   参数 {"arguments": "<模型给出的JSON参数>"}，返回插件结果字符串
+//
+// 插件写到标准错误的内容会作为日志输出。插件进程退出或崩溃不会影响Clara，下一次调用时会重新启动。
+const externalDir = "external"
+
+// sharedConfigKeys是除插件自己的plugins.<id>.*配置外发送给外部插件的配置项，
+// API密钥等其他配置不会发送给插件
+var sharedConfigKeys = []string{"openai.model", "openai.embedding_model", "provider", "plugin_timeout"}
+
+// describeTimeout是describe和init请求的超时时间，execute请求的超时时间由plugin_timeout决定
+const describeTimeout = 10 * time.Second
+
+// rpcRequest是JSON-RPC请求
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// rpcResponse是JSON-RPC响应
+type rpcResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// rpcError是JSON-RPC错误
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// describeResult是describe方法的返回值
+type describeResult struct {
+	ID           string                    `json:"id"`
+	Description  string                    `json:"description"`
+	Function     openai.FunctionDefinition `json:"function"`
+	Capabilities []string                  `json:"capabilities,omitempty"` // 插件具有的能力，例如["network"]，用于权限策略
+}
+
+// process是一个正在运行的插件进程
+type process struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	responses chan rpcResponse
+	done      chan struct{} // 进程退出后关闭
+}
+
+// externalPlugin通过JSON-RPC调用外部进程实现Plugin接口
+type externalPlugin struct {
+	path     string
+	describe describeResult
+	config   map[string]string
+
+	mu     sync.Mutex // 同一时间只向插件进程发送一个请求
+	proc   *process
+	nextID int
+}
+
+// loadExternalPlugins函数启动插件目录external子目录中的所有可执行文件，
+// 单个插件失败只会输出错误，不影响其他插件
+func loadExternalPlugins(cfg config.Cfg, provider llm.Provider) {
+	dir := filepath.Join(cfg.PluginsPath(), externalDir)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println("Error reading external plugins: ", err)
+		}
+		return
+	}
+
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil || !isExecutable(info) {
+			continue
+		}
+
+		fmt.Println("Loading external plugin: ", file.Name())
+		p, err := loadExternalPlugin(filepath.Join(dir, file.Name()), cfg, provider)
+		if err != nil {
+			fmt.Printf("Error loading external plugin %s: %v\n", file.Name(), err)
+			continue
+		}
+		if err := addPlugin(p, p.path); err != nil {
+			fmt.Printf("Error loading external plugin %s: %v\n", file.Name(), err)
+			p.close()
+		}
+	}
+}
+
+// loadExternalPlugin函数启动并初始化单个外部插件，不会把插件加入映射
+func loadExternalPlugin(path string, cfg config.Cfg, provider llm.Provider) (*externalPlugin, error) {
+	p := &externalPlugin{path: path}
+	if err := p.Init(cfg, provider); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// isExecutable函数判断文件是否为可执行文件
+func isExecutable(info os.FileInfo) bool {
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		switch strings.ToLower(filepath.Ext(info.Name())) {
+		case ".exe", ".bat", ".cmd":
+			return true
+		}
+		return false
+	}
+	return info.Mode().Perm()&0o111 != 0
+}
+
+// Init函数启动插件进程，读取插件的描述并把配置发送给插件
+func (p *externalPlugin) Init(cfg config.Cfg, provider llm.Provider) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.start(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), describeTimeout)
+	defer cancel()
+
+	raw, err := p.call(ctx, "describe", nil)
+	if err != nil {
+		return fmt.Errorf("describe: %v", err)
+	}
+	if err := json.Unmarshal(raw, &p.describe); err != nil {
+		return fmt.Errorf("describe: invalid result: %v", err)
+	}
+	if p.describe.ID == "" {
+		return fmt.Errorf("describe: plugin has no id")
+	}
+	if p.describe.Function.Name == "" {
+		p.describe.Function.Name = p.describe.ID
+	}
+	if err := checkDescribedID(p.describe.ID, p.describe.Function.Name); err != nil {
+		return fmt.Errorf("describe: %v", err)
+	}
+	if err := checkCapabilities(p.describe.Capabilities); err != nil {
+		return fmt.Errorf("describe: %v", err)
+	}
+
+	p.config = externalConfig(cfg, p.describe.ID)
+	if _, err := p.call(ctx, "init", map[string]interface{}{"config": p.config}); err != nil {
+		return fmt.Errorf("init: %v", err)
+	}
+	return nil
+}
+
+// externalConfig函数返回发送给外部插件的配置：sharedConfigKeys中的配置项和插件自己的plugins.<id>.*配置
+func externalConfig(cfg config.Cfg, id string) map[string]string {
+	values := cfg.Values()
+	result := make(map[string]string)
+	for _, key := range sharedConfigKeys {
+		if v, ok := values[key]; ok {
+			result[key] = v
+		}
+	}
+	prefix := "plugins." + id + "."
+	for key, v := range values {
+		if strings.HasPrefix(key, prefix) {
+			result[key] = v
+		}
+	}
+	return result
+}
+
+func (p *externalPlugin) ID() string {
+	return p.describe.ID
+}
+
+func (p *externalPlugin) Description() string {
+	return p.describe.Description
+}
+
+func (p *externalPlugin) FunctionDefinition() openai.FunctionDefinition {
+	return p.describe.Function
+}
+
+// Capabilities函数返回插件在describe中声明的能力
+func (p *externalPlugin) Capabilities() []string {
+	return p.describe.Capabilities
+}
+
+func (p *externalPlugin) Execute(jsonInput string) (string, error) {
+	return p.ExecuteContext(context.Background(), jsonInput)
+}
+
+// ExecuteContext函数把模型给出的参数发送给插件进程并返回结果，进程已经退出时会先重新启动。
+// ctx结束时会结束插件进程，下一次调用时重新启动
+func (p *externalPlugin) ExecuteContext(ctx context.Context, jsonInput string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.running() {
+		fmt.Printf("Restarting external plugin %s\n", p.ID())
+		if err := p.start(); err != nil {
+			return "", err
+		}
+		initCtx, cancel := context.WithTimeout(ctx, describeTimeout)
+		_, err := p.call(initCtx, "init", map[string]interface{}{"config": p.config})
+		cancel()
+		if err != nil {
+			return "", fmt.Errorf("init: %v", err)
+		}
+	}
+
+	raw, err := p.call(ctx, "execute", map[string]string{"arguments": jsonInput})
+	if err != nil {
+		return "", err
+	}
+
+	// 结果通常是字符串，其他JSON值原样返回
+	var result string
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return string(raw), nil
+	}
+	return result, nil
+}
+
+// start函数启动插件进程，调用方需要持有p.mu
+func (p *externalPlugin) start() error {
+	cmd := exec.Command(p.path)
+	cmd.Dir = filepath.Dir(p.path)
+	detach(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	proc := &process{
+		cmd:       cmd,
+		stdin:     stdin,
+		responses: make(chan rpcResponse, 16),
+		done:      make(chan struct{}),
+	}
+	name := filepath.Base(p.path)
+
+	// 插件的日志
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			fmt.Printf("[%s] %s\n", name, scanner.Text())
+		}
+	}()
+
+	// 读取响应，进程退出后关闭done
+	go func() {
+		reader := bufio.NewReader(stdout)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(strings.TrimSpace(string(line))) > 0 {
+				var resp rpcResponse
+				if jsonErr := json.Unmarshal(line, &resp); jsonErr != nil {
+					fmt.Printf("[%s] invalid response: %s\n", name, strings.TrimSpace(string(line)))
+				} else {
+					select {
+					case proc.responses <- resp:
+					default: // 没有等待中的请求，丢弃
+					}
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err := cmd.Wait(); err != nil {
+			fmt.Printf("[%s] exited: %v\n", name, err)
+		}
+		close(proc.done)
+	}()
+
+	p.proc = proc
+	return nil
+}
+
+// running函数判断插件进程是否仍在运行，调用方需要持有p.mu
+func (p *externalPlugin) running() bool {
+	if p.proc == nil {
+		return false
+	}
+	select {
+	case <-p.proc.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// close函数结束插件进程
+func (p *externalPlugin) close() {
+	if p.proc == nil {
+		return
+	}
+	p.proc.stdin.Close()
+	if p.proc.cmd.Process != nil {
+		p.proc.cmd.Process.Kill()
+	}
+}
+
+// call函数发送一个请求并等待对应的响应。ctx超时或被取消后会结束插件进程，
+// 避免迟到的响应被当作下一个请求的结果，调用方需要持有p.mu
+func (p *externalPlugin) call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	p.nextID++
+	request, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: p.nextID, Method: method, Params: params})
+	if err != nil {
+		return nil, err
+	}
+
+	proc := p.proc
+	if _, err := proc.stdin.Write(append(request, '\n')); err != nil {
+		return nil, fmt.Errorf("error writing to plugin: %v", err)
+	}
+
+	for {
+		select {
+		case resp := <-proc.responses:
+			if resp.ID != p.nextID {
+				continue // 之前请求的响应
+			}
+			if resp.Error != nil {
+				return nil, resp.Error
+			}
+			return resp.Result, nil
+		case <-proc.done:
+			return nil, fmt.Errorf("plugin process exited")
+		case <-ctx.Done():
+			p.close()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("plugin did not respond to %s in time", method)
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package plugins
+
+import "os/exec" // 用于设置插件进程的属性
+
+// detach函数在不支持进程组的系统上什么也不做
+func detach(cmd *exec.Cmd) {}
@@ -0,0 +1,72 @@
+package plugins
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/wangergou2023/clara/config"
+)
+
+func TestExternalPluginFunctionNameMustMatchID(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test plugin is a shell script")
+	}
+	dir := t.TempDir()
+	script := `#!/bin/sh
+read request
+echo '{"jsonrpc":"2.0","id":1,"result":{"id":"dice","description":"roll","function":{"name":"roll_dice"}}}'
+`
+	path := filepath.Join(dir, "dice")
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := loadExternalPlugin(path, config.New(), nil)
+	if err == nil || !strings.Contains(err.Error(), `function name "roll_dice" must be the same as the plugin id "dice"`) {
+		t.Fatalf("loadExternalPlugin() = %v, want a function name error", err)
+	}
+}
+
+func TestExternalConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clara.yaml")
+	content := `
+openai:
+  api_key: sk-secret
+  model: gpt-4
+  embedding_model: embed-1
+openweathermap:
+  api_key: owm-secret
+server:
+  api_key: server-secret
+plugins:
+  dice:
+    sides: 20
+  dice2:
+    token: other-secret
+  memory:
+    store: local
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLARA_CONFIG", path)
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"openai.model":           "gpt-4",
+		"openai.embedding_model": "embed-1",
+		"provider":               "openai",
+		"plugin_timeout":         "2m0s",
+		"plugins.dice.sides":     "20",
+	}
+	if got := externalConfig(cfg, "dice"); !reflect.DeepEqual(got, want) {
+		t.Errorf("externalConfig() = %v, want %v", got, want)
+	}
+}
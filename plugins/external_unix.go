@@ -0,0 +1,13 @@
+//go:build unix
+
+package plugins
+
+import (
+	"os/exec" // 用于设置插件进程的属性
+	"syscall" // 用于设置进程组
+)
+
+// detach函数让插件进程使用自己的进程组，在终端中按下Ctrl-C时插件进程不会一起收到SIGINT
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
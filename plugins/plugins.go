@@ -1,138 +1,334 @@
-package plugins
-
-// 导入必要的包
-import (
-	"encoding/json" // 用于JSON处理
-	"fmt"           // 用于格式化输出
-	"os"            // 提供操作系统函数，用于文件路径操作等
-	"path/filepath" // 用于文件路径操作
-	"plugin"        // 支持从共享库动态加载代码
-
-	"github.com/sashabaranov/go-openai"     // OpenAI GPT库
-	"github.com/wangergou2023/clara/config" // 配置包
-)
-
-// 已加载插件的映射，键为插件ID，值为插件实例
-var loadedPlugins = make(map[string]Plugin)
-
-// Plugin接口定义了所有插件必须实现的方法
-type Plugin interface {
-	Init(cfg config.Cfg, openaiClient *openai.Client) error // 初始化插件
-	ID() string                                             // 获取插件ID
-	Description() string                                    // 获取插件描述
-	FunctionDefinition() openai.FunctionDefinition          // 获取函数定义，用于OpenAI
-	Execute(string) (string, error)                         // 执行插件逻辑
-}
-
-// PluginResponse结构体用于封装插件执行的响应
-type PluginResponse struct {
-	Error  string `json:"error,omitempty"`  // 错误信息，如果有的话
-	Result string `json:"result,omitempty"` // 成功执行的结果
-}
-
-// LoadPlugins函数加载指定目录下的所有插件
-func LoadPlugins(cfg config.Cfg, openaiClient *openai.Client) error {
-	loadedPlugins = make(map[string]Plugin) // 重新初始化插件映射
-
-	// 从"compiled"目录读取插件文件
-	files, err := os.ReadDir(cfg.PluginsPath() + "/compiled")
-	if err != nil {
-		return err
-	}
-
-	// 遍历文件，加载.so文件作为插件
-	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".so" {
-			fmt.Println("Loading plugin: ", file.Name())
-			err := loadSinglePlugin(cfg.PluginsPath()+"/compiled/"+file.Name(), cfg, openaiClient)
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	return nil
-}
-
-// loadSinglePlugin函数加载单个插件
-func loadSinglePlugin(path string, cfg config.Cfg, openaiClient *openai.Client) error {
-	plugin, err := plugin.Open(path) // 打开插件文件
-	if err != nil {
-		return err
-	}
-
-	symbol, err := plugin.Lookup("Plugin") // 查找插件中的"Plugin"符号
-	if err != nil {
-		return err
-	}
-
-	// 类型断言确认找到的符号类型正确
-	p, ok := symbol.(*Plugin)
-	if !ok {
-		return fmt.Errorf("unexpected type from module symbol: %s", path)
-	}
-	err = (*p).Init(cfg, openaiClient) // 初始化插件
-	if err != nil {
-		return err
-	}
-	loadedPlugins[(*p).ID()] = *p // 将插件加入映射
-	return nil
-}
-
-// CallPlugin函数通过ID查找插件并执行
-func CallPlugin(id string, jsonInput string) (string, error) {
-	response := PluginResponse{}
-
-	plugin, exists := GetPluginByID(id) // 查找插件
-	if !exists {
-		response.Error = fmt.Sprintf("plugin with ID %s not found", id)
-		jsonResponse, err := json.Marshal(response)
-		return string(jsonResponse), err
-	}
-
-	// 执行插件
-	result, err := plugin.Execute(jsonInput)
-	if err != nil {
-		response.Error = err.Error()
-	} else {
-		response.Result = result
-	}
-
-	// 将执行结果转换为JSON
-	jsonResponse, err := json.Marshal(response)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling response to JSON: %v", err)
-	}
-
-	return string(jsonResponse), nil
-}
-
-// IsPluginLoaded函数检查指定ID的插件是否已加载
-func IsPluginLoaded(id string) bool {
-	_, exists := loadedPlugins[id]
-	return exists
-}
-
-// GetPluginByID函数通过ID获取插件
-func GetPluginByID(id string) (Plugin, bool) {
-	p, exists := loadedPlugins[id]
-	return p, exists
-}
-
-// GetAllPlugins函数返回所有已加载的插件
-func GetAllPlugins() map[string]Plugin {
-	return loadedPlugins
-}
-
-// GenerateOpenAIFunctionsDefinition函数生成所有插件的OpenAI函数定义
-func GenerateOpenAIFunctionsDefinition() []openai.FunctionDefinition {
-	var definitions []openai.FunctionDefinition
-
-	// 遍历已加载的插件，收集它们的函数定义
-	for _, plugin := range loadedPlugins {
-		def := plugin.FunctionDefinition()
-		definitions = append(definitions, def)
-	}
-
-	return definitions
-}
+package plugins
+
+// 导入必要的包
+import (
+	"context"       // 用于取消插件调用和设置超时
+	"encoding/json" // 用于JSON处理
+	"errors"        // 用于判断超时错误
+	"fmt"           // 用于格式化输出
+	"os"            // 提供操作系统函数，用于文件路径操作等
+	"path/filepath" // 用于文件路径操作
+	"plugin"        // 支持从共享库动态加载代码
+	"regexp"        // 用于检查插件ID
+	"sort"          // 用于对插件排序
+
+	"github.com/sashabaranov/go-openai"     // OpenAI GPT库
+	"github.com/wangergou2023/clara/config" // 配置包
+	"github.com/wangergou2023/clara/llm"    // 模型服务
+)
+
+// 已加载插件的映射，键为插件ID，值为插件实例
+var loadedPlugins = make(map[string]Plugin)
+
+// pluginIDPattern是外部插件和WebAssembly插件在describe中报告的ID和函数名称必须匹配的格式。
+// ID会用在文件路径、配置键和OpenAI函数名称中，所以只允许字母、数字、下划线和连字符
+var pluginIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// checkDescribedID函数检查插件报告的ID和函数名称，不匹配pluginIDPattern的插件不会被加载。
+// 会话按函数名称查找插件，所以函数名称必须与ID相同，否则插件加载后既不会提供给模型也无法被调用
+func checkDescribedID(id, functionName string) error {
+	if !pluginIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid plugin id %q, expected %s", id, pluginIDPattern)
+	}
+	if functionName != id {
+		return fmt.Errorf("function name %q must be the same as the plugin id %q", functionName, id)
+	}
+	return nil
+}
+
+// Plugin接口定义了所有插件必须实现的方法
+type Plugin interface {
+	Init(cfg config.Cfg, provider llm.Provider) error // 初始化插件
+	ID() string                                       // 获取插件ID
+	Description() string                              // 获取插件描述
+	FunctionDefinition() openai.FunctionDefinition    // 获取函数定义，用于OpenAI
+	Execute(string) (string, error)                   // 执行插件逻辑
+}
+
+// ContextPlugin是插件可以选择实现的接口。调用被取消或超时时ctx会结束，
+// 插件应该把ctx传给HTTP请求、数据库查询等可能阻塞的操作，并尽快返回
+type ContextPlugin interface {
+	Plugin
+	ExecuteContext(ctx context.Context, jsonInput string) (string, error)
+}
+
+// sessionIDKey是ctx中保存会话ID的键
+type sessionIDKey struct{}
+
+// WithSessionID函数返回携带会话ID的ctx，插件可以通过SessionID知道调用来自哪个会话
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey{}, sessionID)
+}
+
+// SessionID函数返回发起插件调用的会话ID，调用不属于任何会话（例如HTTP API的补全接口）时返回空字符串
+func SessionID(ctx context.Context) string {
+	sessionID, _ := ctx.Value(sessionIDKey{}).(string)
+	return sessionID
+}
+
+// WithContext函数把插件转换为ContextPlugin。没有实现ExecuteContext的旧插件在后台执行，
+// ctx结束时立即返回ctx的错误，插件本身会继续执行到结束，它的结果会被丢弃
+func WithContext(p Plugin) ContextPlugin {
+	if cp, ok := p.(ContextPlugin); ok {
+		return cp
+	}
+	return contextAdapter{p}
+}
+
+// contextAdapter让只实现了Execute的插件可以被取消
+type contextAdapter struct {
+	Plugin
+}
+
+func (a contextAdapter) ExecuteContext(ctx context.Context, jsonInput string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	type result struct {
+		output string
+		err    error
+	}
+	done := make(chan result, 1) // 有缓冲，调用被取消后插件仍然可以写入结果并退出
+	go func() {
+		output, err := a.Execute(jsonInput)
+		done <- result{output, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.output, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// PluginResponse结构体用于封装插件执行的响应
+type PluginResponse struct {
+	Error            string            `json:"error,omitempty"`             // 错误信息，如果有的话
+	Result           string            `json:"result,omitempty"`            // 成功执行的结果
+	InvalidArguments []ArgumentProblem `json:"invalid_arguments,omitempty"` // 参数不符合参数模式时的具体问题，插件没有执行
+}
+
+// Command是插件提供给REPL的斜杠命令，例如/memory search <q>
+type Command struct {
+	Name        string                              // 命令名称，不包含"/"
+	Usage       string                              // 用法说明，例如"/memory search <q>"
+	Description string                              // 命令描述
+	Run         func(args []string) (string, error) // 执行命令，返回要显示给用户的文本
+}
+
+// CommandProvider是插件可以选择实现的接口，用于向REPL注册自己的斜杠命令
+type CommandProvider interface {
+	Commands() []Command
+}
+
+// LoadPlugins函数加载所有插件：通过Register编译进Clara的内置插件、compiled目录中的Go插件、
+// external目录中的外部插件和wasm目录中的WebAssembly插件。不同来源的插件ID不能重复，冲突的插件不会被加载。
+// provider是默认的模型服务，插件可以用它请求模型或计算向量
+func LoadPlugins(cfg config.Cfg, provider llm.Provider) error {
+	pluginsMu.Lock()
+	loadedPlugins = make(map[string]Plugin) // 重新初始化插件映射
+	pluginSources = make(map[string]string)
+	pluginsCfg = cfg
+	pluginsMu.Unlock()
+
+	loadRegisteredPlugins(cfg, provider)
+	err := loadCompiledPlugins(cfg, provider)
+	loadExternalPlugins(cfg, provider) // 外部插件的错误不影响其他插件，只输出错误信息
+	loadWasmPlugins(cfg, provider)     // WebAssembly插件同样只输出错误信息
+	return err
+}
+
+// loadCompiledPlugins函数加载compiled目录中的.so插件
+func loadCompiledPlugins(cfg config.Cfg, provider llm.Provider) error {
+	// 从"compiled"目录读取插件文件
+	files, err := os.ReadDir(cfg.PluginsPath() + "/compiled")
+	if os.IsNotExist(err) {
+		return nil // 只使用外部插件时可以没有compiled目录
+	}
+	if err != nil {
+		return err
+	}
+
+	// 遍历文件，加载.so文件作为插件
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == ".so" {
+			fmt.Println("Loading plugin: ", file.Name())
+			path := filepath.Join(cfg.PluginsPath(), "compiled", file.Name())
+			p, err := loadSinglePlugin(path, cfg, provider)
+			if err != nil {
+				return err
+			}
+			if err := addPlugin(p, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadSinglePlugin函数打开并初始化单个Go插件，不会把插件加入映射
+func loadSinglePlugin(path string, cfg config.Cfg, provider llm.Provider) (Plugin, error) {
+	plugin, err := plugin.Open(path) // 打开插件文件
+	if err != nil {
+		return nil, err
+	}
+
+	symbol, err := plugin.Lookup("Plugin") // 查找插件中的"Plugin"符号
+	if err != nil {
+		return nil, err
+	}
+
+	// 类型断言确认找到的符号类型正确
+	p, ok := symbol.(*Plugin)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type from module symbol: %s", path)
+	}
+	if err := checkConflict((*p).ID(), path); err != nil {
+		return nil, err
+	}
+	err = (*p).Init(cfg, provider) // 初始化插件
+	if err != nil {
+		return nil, err
+	}
+	return *p, nil
+}
+
+// CallPlugin函数通过ID查找插件并执行
+func CallPlugin(id string, jsonInput string) (string, error) {
+	return CallPluginContext(context.Background(), id, jsonInput)
+}
+
+// CallPluginContext函数通过ID查找插件，按插件的参数模式检查参数后执行，执行时间受plugins.<插件ID>.timeout
+// 或plugin_timeout限制。参数无效、插件出错或超时会作为结果中的错误交给模型，让模型自行纠正；
+// 只有ctx本身被取消或超时时才返回错误，表示整轮对话被中止
+func CallPluginContext(ctx context.Context, id string, jsonInput string) (string, error) {
+	response := PluginResponse{}
+
+	plugin, exists := GetPluginByID(id) // 查找插件
+	if !exists {
+		return ErrorResponse(fmt.Sprintf("plugin with ID %s not found", id))
+	}
+
+	// 参数不符合参数模式时不执行插件，把具体问题交给模型
+	if err := ValidateArguments(plugin.FunctionDefinition(), jsonInput); err != nil {
+		var argsErr *ArgumentsError
+		if errors.As(err, &argsErr) {
+			response.Error = fmt.Sprintf("invalid arguments for %s, fix the problems listed in invalid_arguments and call it again", argsErr.Function)
+			response.InvalidArguments = argsErr.Problems
+		} else {
+			response.Error = err.Error()
+		}
+		jsonResponse, err := json.Marshal(response)
+		return string(jsonResponse), err
+	}
+
+	callCtx := ctx
+	timeout := pluginTimeout(id)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// 执行插件
+	result, err := WithContext(plugin).ExecuteContext(callCtx, jsonInput)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if errors.Is(callCtx.Err(), context.DeadlineExceeded) {
+		response.Error = fmt.Sprintf("plugin %s did not finish within %v", id, timeout)
+	} else if err != nil {
+		response.Error = err.Error()
+	} else {
+		response.Result = result
+	}
+
+	// 将执行结果转换为JSON
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling response to JSON: %v", err)
+	}
+
+	return string(jsonResponse), nil
+}
+
+// HasInvalidArguments函数判断插件调用的结果是否因为参数无效而没有执行插件
+func HasInvalidArguments(result string) bool {
+	var response PluginResponse
+	return json.Unmarshal([]byte(result), &response) == nil && len(response.InvalidArguments) > 0
+}
+
+// ErrorResponse函数把错误信息封装成与插件执行结果相同格式的JSON，交给模型作为函数结果
+func ErrorResponse(message string) (string, error) {
+	jsonResponse, err := json.Marshal(PluginResponse{Error: message})
+	return string(jsonResponse), err
+}
+
+// IsPluginLoaded函数检查指定ID的插件是否已加载
+func IsPluginLoaded(id string) bool {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+
+	_, exists := loadedPlugins[id]
+	return exists
+}
+
+// GetPluginByID函数通过ID获取插件
+func GetPluginByID(id string) (Plugin, bool) {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+
+	p, exists := loadedPlugins[id]
+	return p, exists
+}
+
+// GetAllPlugins函数返回所有已加载插件的副本，插件可能在后台被重新加载
+func GetAllPlugins() map[string]Plugin {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+
+	all := make(map[string]Plugin, len(loadedPlugins))
+	for id, p := range loadedPlugins {
+		all[id] = p
+	}
+	return all
+}
+
+// GenerateOpenAIFunctionsDefinition函数生成所有插件的OpenAI函数定义，按插件ID排序，
+// 插件不变时每次生成的定义都相同
+func GenerateOpenAIFunctionsDefinition() []openai.FunctionDefinition {
+	var definitions []openai.FunctionDefinition
+
+	all := GetAllPlugins()
+	ids := make([]string, 0, len(all))
+	for id := range all {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	// 遍历已加载的插件，收集它们的函数定义
+	for _, id := range ids {
+		def := all[id].FunctionDefinition()
+		definitions = append(definitions, def)
+	}
+
+	return definitions
+}
+
+// GenerateOpenAIToolsDefinition函数把所有插件的函数定义包装成OpenAI工具定义
+func GenerateOpenAIToolsDefinition() []openai.Tool {
+	var tools []openai.Tool
+
+	for _, def := range GenerateOpenAIFunctionsDefinition() {
+		def := def
+		tools = append(tools, openai.Tool{
+			Type:     openai.ToolTypeFunction,
+			Function: &def,
+		})
+	}
+
+	return tools
+}
@@ -0,0 +1,172 @@
+package plugins
+
+import (
+	"fmt"     // 用于格式化输出
+	"sort"    // 用于按顺序列出规则
+	"strings" // 用于拼接能力名称
+)
+
+// 插件的能力，描述插件调用可能产生的影响，权限策略按能力决定调用是否需要用户确认
+const (
+	CapabilityReadOnly        = "read-only"        // 只读取信息，不修改任何东西
+	CapabilityNetwork         = "network"          // 访问网络
+	CapabilityFilesystemWrite = "filesystem-write" // 写入本机的文件
+	CapabilityDestructive     = "destructive"      // 删除或覆盖数据，无法撤销
+)
+
+// Capabilities列出了所有已知的能力，从宽松到严格排列
+var Capabilities = []string{CapabilityReadOnly, CapabilityNetwork, CapabilityFilesystemWrite, CapabilityDestructive}
+
+// 权限策略对插件调用的决定
+const (
+	PolicyAllow = "allow" // 直接执行
+	PolicyAsk   = "ask"   // 先询问用户
+	PolicyDeny  = "deny"  // 拒绝执行
+)
+
+// PolicyDefault是没有声明能力的插件以及没有单独规则的能力使用的规则
+const PolicyDefault = "default"
+
+// CapabilityProvider是插件可以选择实现的接口，用于声明插件具有的能力。
+// 没有实现的插件按PolicyDefault规则处理
+type CapabilityProvider interface {
+	Capabilities() []string
+}
+
+// CapabilitiesOf函数返回插件声明的能力
+func CapabilitiesOf(p Plugin) []string {
+	if cp, ok := p.(CapabilityProvider); ok {
+		return cp.Capabilities()
+	}
+	return nil
+}
+
+// CallCapabilityProvider是插件可以选择实现的接口，用于按参数声明单次调用的能力，
+// 例如同一个插件的查询只访问网络，删除却是破坏性的。Capabilities仍然要列出插件可能具有的所有能力
+type CallCapabilityProvider interface {
+	CallCapabilities(jsonInput string) []string
+}
+
+// CallCapabilitiesOf函数返回一次调用的能力。插件没有实现CallCapabilityProvider，
+// 或者返回了空列表、未知的能力时，使用插件声明的能力
+func CallCapabilitiesOf(p Plugin, jsonInput string) []string {
+	if cp, ok := p.(CallCapabilityProvider); ok {
+		capabilities := cp.CallCapabilities(jsonInput)
+		if len(capabilities) > 0 && checkCapabilities(capabilities) == nil {
+			return capabilities
+		}
+	}
+	return CapabilitiesOf(p)
+}
+
+// IsCapability函数判断名称是否为已知的能力
+func IsCapability(name string) bool {
+	for _, c := range Capabilities {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCapabilities函数检查插件声明的能力是否都是已知的能力。写错的能力名称会让插件绕过对应的规则，
+// 所以声明了未知能力的插件不会被加载
+func checkCapabilities(capabilities []string) error {
+	for _, c := range capabilities {
+		if !IsCapability(c) {
+			return fmt.Errorf("unknown capability %q, expected one of %s", c, strings.Join(Capabilities, ", "))
+		}
+	}
+	return nil
+}
+
+// IsPolicyDecision函数判断值是否为有效的决定
+func IsPolicyDecision(decision string) bool {
+	return decision == PolicyAllow || decision == PolicyAsk || decision == PolicyDeny
+}
+
+// strictness是决定的严格程度，多条规则同时适用时以最严格的为准
+var strictness = map[string]int{PolicyAllow: 0, PolicyAsk: 1, PolicyDeny: 2}
+
+// Policy是插件调用的权限策略，键为能力名称、PolicyDefault或"plugins.<插件ID>"，值为决定
+type Policy map[string]string
+
+// DefaultPolicy函数返回默认的权限策略：只读和访问网络的插件直接执行，
+// 写入文件和删除数据的插件先询问用户，没有声明能力的插件直接执行以兼容已有的插件
+func DefaultPolicy() Policy {
+	return Policy{
+		PolicyDefault:             PolicyAllow,
+		CapabilityReadOnly:        PolicyAllow,
+		CapabilityNetwork:         PolicyAllow,
+		CapabilityFilesystemWrite: PolicyAsk,
+		CapabilityDestructive:     PolicyAsk,
+	}
+}
+
+// PluginRule函数返回插件单独规则的键
+func PluginRule(id string) string {
+	return "plugins." + id
+}
+
+// With函数返回合并了overrides的新策略，overrides中的规则优先，原策略不会被修改
+func (p Policy) With(overrides Policy) Policy {
+	merged := make(Policy, len(p)+len(overrides))
+	for k, v := range p {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
+
+// Decide函数决定插件调用是否可以执行，返回决定和依据的规则。插件的单独规则优先；
+// 否则按插件声明的每个能力查找规则并取最严格的决定，没有规则的能力和没有声明能力的插件使用PolicyDefault
+func (p Policy) Decide(id string, capabilities []string) (decision string, rule string) {
+	if d, ok := p[PluginRule(id)]; ok {
+		return d, PluginRule(id)
+	}
+
+	fallback := p[PolicyDefault]
+	if fallback == "" {
+		fallback = PolicyAllow
+	}
+	if len(capabilities) == 0 {
+		return fallback, PolicyDefault
+	}
+
+	decision, rule = PolicyAllow, ""
+	for _, c := range capabilities {
+		d, r := p[c], c
+		if d == "" {
+			d, r = fallback, PolicyDefault
+		}
+		if rule == "" || strictness[d] > strictness[decision] {
+			decision, rule = d, r
+		}
+	}
+	return decision, rule
+}
+
+// String函数按键的顺序列出所有规则，每行一条
+func (p Policy) String() string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, len(keys))
+	for i, k := range keys {
+		lines[i] = fmt.Sprintf("%s: %s", k, p[k])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// FormatCapabilities函数把能力列表格式化为显示给用户的文本
+func FormatCapabilities(capabilities []string) string {
+	if len(capabilities) == 0 {
+		return "no declared capabilities"
+	}
+	return strings.Join(capabilities, ", ")
+}
@@ -0,0 +1,98 @@
+package plugins
+
+import "testing"
+
+func TestPolicyDecide(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       Policy
+		id           string
+		capabilities []string
+		decision     string
+		rule         string
+	}{
+		{
+			name:     "default policy allows plugins without capabilities",
+			policy:   DefaultPolicy(),
+			id:       "legacy",
+			decision: PolicyAllow,
+			rule:     PolicyDefault,
+		},
+		{
+			name:         "default policy allows read-only",
+			policy:       DefaultPolicy(),
+			id:           "time",
+			capabilities: []string{CapabilityReadOnly},
+			decision:     PolicyAllow,
+			rule:         CapabilityReadOnly,
+		},
+		{
+			name:         "strictest capability wins",
+			policy:       DefaultPolicy(),
+			id:           "memory",
+			capabilities: []string{CapabilityNetwork, CapabilityDestructive},
+			decision:     PolicyAsk,
+			rule:         CapabilityDestructive,
+		},
+		{
+			name:         "deny beats ask",
+			policy:       DefaultPolicy().With(Policy{CapabilityNetwork: PolicyDeny}),
+			id:           "fetch",
+			capabilities: []string{CapabilityFilesystemWrite, CapabilityNetwork},
+			decision:     PolicyDeny,
+			rule:         CapabilityNetwork,
+		},
+		{
+			name:         "plugin rule overrides capabilities",
+			policy:       DefaultPolicy().With(Policy{PluginRule("memory"): PolicyAllow}),
+			id:           "memory",
+			capabilities: []string{CapabilityDestructive},
+			decision:     PolicyAllow,
+			rule:         "plugins.memory",
+		},
+		{
+			name:         "rule for another plugin does not apply",
+			policy:       DefaultPolicy().With(Policy{PluginRule("weather"): PolicyDeny}),
+			id:           "memory",
+			capabilities: []string{CapabilityNetwork},
+			decision:     PolicyAllow,
+			rule:         CapabilityNetwork,
+		},
+		{
+			name:         "capability without a rule uses the default rule",
+			policy:       Policy{PolicyDefault: PolicyAsk, CapabilityReadOnly: PolicyAllow},
+			id:           "fetch",
+			capabilities: []string{CapabilityReadOnly, CapabilityNetwork},
+			decision:     PolicyAsk,
+			rule:         PolicyDefault,
+		},
+		{
+			name:     "empty policy allows",
+			policy:   Policy{},
+			id:       "legacy",
+			decision: PolicyAllow,
+			rule:     PolicyDefault,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision, rule := tt.policy.Decide(tt.id, tt.capabilities)
+			if decision != tt.decision || rule != tt.rule {
+				t.Errorf("Decide(%q, %v) = %s, %s; want %s, %s", tt.id, tt.capabilities, decision, rule, tt.decision, tt.rule)
+			}
+		})
+	}
+}
+
+func TestPolicyWithDoesNotModify(t *testing.T) {
+	base := DefaultPolicy()
+	merged := base.With(Policy{CapabilityNetwork: PolicyDeny})
+
+	if base[CapabilityNetwork] != PolicyAllow {
+		t.Errorf("base policy was modified: network = %s", base[CapabilityNetwork])
+	}
+	if merged[CapabilityNetwork] != PolicyDeny {
+		t.Errorf("merged network = %s, want %s", merged[CapabilityNetwork], PolicyDeny)
+	}
+}
@@ -0,0 +1,211 @@
+package plugins
+
+import (
+	"fmt"           // 用于格式化输出
+	"path/filepath" // 用于判断插件文件的类型
+	"sort"          // 用于对插件排序
+	"sync"          // 用于保护注册表
+	"time"          // 用于插件的超时时间
+
+	"github.com/wangergou2023/clara/config" // 配置包
+	"github.com/wangergou2023/clara/llm"    // 模型服务
+)
+
+// 编译进Clara的插件的注册表，插件在自己包的init函数中调用Register
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]Plugin)
+)
+
+// pluginSources记录每个已加载插件的来源（"builtin"或插件文件的路径），用于报告ID冲突和重新加载
+var pluginSources = make(map[string]string)
+
+// pluginsCfg是LoadPlugins使用的配置，用于读取每个插件的超时时间
+var pluginsCfg = config.New()
+
+// pluginsMu保护loadedPlugins、pluginSources和pluginsCfg，重新加载插件时会在后台修改它们
+var pluginsMu sync.RWMutex
+
+// pluginTimeout函数返回插件单次调用的超时时间，0表示不限制
+func pluginTimeout(id string) time.Duration {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+
+	return pluginsCfg.PluginTimeout(id)
+}
+
+// Register函数注册一个编译进Clara的插件，通常在插件包的init函数中调用。
+// 插件会在LoadPlugins时初始化。插件为nil或ID重复时会panic
+func Register(p Plugin) {
+	if p == nil {
+		panic("plugins: Register plugin is nil")
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	id := p.ID()
+	if _, dup := registry[id]; dup {
+		panic("plugins: Register called twice for plugin " + id)
+	}
+	registry[id] = p
+}
+
+// Registered函数返回所有通过Register注册的插件，按ID排序
+func Registered() []Plugin {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	list := make([]Plugin, 0, len(registry))
+	for _, p := range registry {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID() < list[j].ID()
+	})
+	return list
+}
+
+// loadRegisteredPlugins函数初始化所有注册的插件，初始化失败的插件（例如连接不到Milvus）会被跳过
+func loadRegisteredPlugins(cfg config.Cfg, provider llm.Provider) {
+	for _, p := range Registered() {
+		if err := p.Init(cfg, provider); err != nil {
+			fmt.Printf("Error initializing builtin plugin %s: %v\n", p.ID(), err)
+			continue
+		}
+		if err := addPlugin(p, "builtin"); err != nil {
+			fmt.Println("Error loading plugin: ", err)
+		}
+	}
+}
+
+// changeHandler在插件运行时被Install加载后调用
+var (
+	changeMu      sync.Mutex
+	changeHandler func([]Change)
+)
+
+// OnChange函数设置插件在运行时被Install加载后调用的函数，助手用它刷新工具定义并通知会话
+func OnChange(handler func([]Change)) {
+	changeMu.Lock()
+	defer changeMu.Unlock()
+
+	changeHandler = handler
+}
+
+// Install函数加载插件目录中的一个插件文件并立即注册，例如插件生成器刚编译好的插件。
+// 路径应与插件目录中的文件路径一致，这样监视插件目录时不会重复加载
+func Install(path string, cfg config.Cfg, provider llm.Provider) (Plugin, error) {
+	p, err := openPluginFile(path, cfg, provider)
+	if err != nil {
+		return nil, err
+	}
+	if err := addPlugin(p, path); err != nil {
+		closePlugin(p)
+		return nil, err
+	}
+
+	changeMu.Lock()
+	handler := changeHandler
+	changeMu.Unlock()
+	if handler != nil {
+		handler([]Change{{Action: ChangeAdded, ID: p.ID(), Path: path}})
+	}
+	return p, nil
+}
+
+// openPluginFile函数按插件文件所在的目录选择加载方式，不会把插件加入映射
+func openPluginFile(path string, cfg config.Cfg, provider llm.Provider) (Plugin, error) {
+	switch filepath.Base(filepath.Dir(path)) {
+	case externalDir:
+		return loadExternalPlugin(path, cfg, provider)
+	case wasmDir:
+		return loadWasmPlugin(path, cfg, provider)
+	default:
+		return loadSinglePlugin(path, cfg, provider)
+	}
+}
+
+// addPlugin函数把插件加入已加载插件的映射，ID已被其他插件使用时返回错误
+func addPlugin(p Plugin, source string) error {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+
+	if err := conflict(p.ID(), source); err != nil {
+		return err
+	}
+	loadedPlugins[p.ID()] = p
+	pluginSources[p.ID()] = source
+	return nil
+}
+
+// replacePlugin函数用p替换来自source的插件，返回被替换的插件。新插件的ID被其他来源占用时返回错误，原插件保持不变
+func replacePlugin(source string, p Plugin) (Plugin, error) {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+
+	if existing, exists := pluginSources[p.ID()]; exists && existing != source {
+		return nil, conflict(p.ID(), source)
+	}
+	old := removeSource(source)
+	loadedPlugins[p.ID()] = p
+	pluginSources[p.ID()] = source
+	return old, nil
+}
+
+// removePlugin函数移除来自source的插件，返回被移除的插件，没有时返回nil
+func removePlugin(source string) Plugin {
+	pluginsMu.Lock()
+	defer pluginsMu.Unlock()
+
+	return removeSource(source)
+}
+
+// removeSource函数移除来自source的插件，调用方需要持有pluginsMu
+func removeSource(source string) Plugin {
+	for id, s := range pluginSources {
+		if s == source {
+			p := loadedPlugins[id]
+			delete(loadedPlugins, id)
+			delete(pluginSources, id)
+			return p
+		}
+	}
+	return nil
+}
+
+// loadedFrom函数判断是否有插件来自source
+func loadedFrom(source string) bool {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+
+	for _, s := range pluginSources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// closePlugin函数释放插件占用的进程或运行时，Go插件无法卸载
+func closePlugin(p Plugin) {
+	if c, ok := p.(interface{ close() }); ok {
+		c.close()
+	}
+}
+
+// checkConflict函数检查插件ID是否已被其他插件使用
+func checkConflict(id string, source string) error {
+	pluginsMu.RLock()
+	defer pluginsMu.RUnlock()
+
+	return conflict(id, source)
+}
+
+// conflict函数返回ID冲突的错误，调用方需要持有pluginsMu
+func conflict(id string, source string) error {
+	if existing, exists := pluginSources[id]; exists {
+		return fmt.Errorf("plugin ID %s from %s conflicts with the plugin from %s", id, source, existing)
+	}
+	return nil
+}
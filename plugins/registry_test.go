@@ -0,0 +1,151 @@
+package plugins
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/wangergou2023/clara/config"
+	"github.com/wangergou2023/clara/llm"
+)
+
+// fakePlugin是只有ID的测试插件
+type fakePlugin struct {
+	id string
+}
+
+func (p *fakePlugin) Init(cfg config.Cfg, provider llm.Provider) error { return nil }
+func (p *fakePlugin) ID() string                                       { return p.id }
+func (p *fakePlugin) Description() string                              { return "" }
+func (p *fakePlugin) FunctionDefinition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{Name: p.id}
+}
+func (p *fakePlugin) Execute(string) (string, error) { return "", nil }
+
+// resetPlugins函数清空已加载的插件，测试结束时恢复
+func resetPlugins(t *testing.T) {
+	t.Helper()
+
+	pluginsMu.Lock()
+	savedPlugins, savedSources := loadedPlugins, pluginSources
+	loadedPlugins, pluginSources = make(map[string]Plugin), make(map[string]string)
+	pluginsMu.Unlock()
+
+	t.Cleanup(func() {
+		pluginsMu.Lock()
+		loadedPlugins, pluginSources = savedPlugins, savedSources
+		pluginsMu.Unlock()
+	})
+}
+
+func TestRegisterDuplicatePanics(t *testing.T) {
+	p := &fakePlugin{id: "register_test"}
+	Register(p)
+	t.Cleanup(func() {
+		registryMu.Lock()
+		delete(registry, p.id)
+		registryMu.Unlock()
+	})
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("Register did not panic for a duplicate id")
+		}
+		if !strings.Contains(r.(string), "register_test") {
+			t.Errorf("panic = %v, want it to name the plugin", r)
+		}
+	}()
+	Register(&fakePlugin{id: "register_test"})
+}
+
+func TestAddPluginConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		loaded  map[string]string // 已加载插件的ID和来源
+		id      string
+		source  string
+		wantErr string
+	}{
+		{
+			name:   "new id",
+			loaded: map[string]string{"time": "builtin"},
+			id:     "dice",
+			source: "plugins/external/dice.py",
+		},
+		{
+			name:    "external plugin takes a builtin id",
+			loaded:  map[string]string{"memory": "builtin"},
+			id:      "memory",
+			source:  "plugins/external/memory",
+			wantErr: "plugin ID memory from plugins/external/memory conflicts with the plugin from builtin",
+		},
+		{
+			name:    "two files with the same id",
+			loaded:  map[string]string{"fetch": "plugins/wasm/fetch.wasm"},
+			id:      "fetch",
+			source:  "plugins/wasm/fetch2.wasm",
+			wantErr: "plugin ID fetch from plugins/wasm/fetch2.wasm conflicts with the plugin from plugins/wasm/fetch.wasm",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetPlugins(t)
+			for id, source := range tt.loaded {
+				if err := addPlugin(&fakePlugin{id: id}, source); err != nil {
+					t.Fatalf("addPlugin(%s) = %v", id, err)
+				}
+			}
+
+			p := &fakePlugin{id: tt.id}
+			err := addPlugin(p, tt.source)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("addPlugin() = %v, want nil", err)
+				}
+				if got, _ := GetPluginByID(tt.id); got != p {
+					t.Errorf("plugin %s was not loaded", tt.id)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("addPlugin() = %v, want %q", err, tt.wantErr)
+			}
+			if pluginSources[tt.id] != tt.loaded[tt.id] {
+				t.Errorf("source of %s = %q, want the original %q", tt.id, pluginSources[tt.id], tt.loaded[tt.id])
+			}
+		})
+	}
+}
+
+func TestReplacePluginConflict(t *testing.T) {
+	resetPlugins(t)
+	if err := addPlugin(&fakePlugin{id: "memory"}, "builtin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := addPlugin(&fakePlugin{id: "dice"}, "plugins/external/dice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 修改后的插件文件改用了已被占用的ID，原插件保持不变
+	if _, err := replacePlugin("plugins/external/dice", &fakePlugin{id: "memory"}); err == nil {
+		t.Fatal("replacePlugin() took the id of a builtin plugin")
+	}
+	if !loadedFrom("plugins/external/dice") || pluginSources["memory"] != "builtin" {
+		t.Errorf("sources after a failed replace = %v", pluginSources)
+	}
+
+	// 同一个文件改用新的ID时替换原插件
+	old, err := replacePlugin("plugins/external/dice", &fakePlugin{id: "dice2"})
+	if err != nil {
+		t.Fatalf("replacePlugin() = %v", err)
+	}
+	if old == nil || old.ID() != "dice" {
+		t.Errorf("replaced plugin = %v, want dice", old)
+	}
+	if IsPluginLoaded("dice") || !IsPluginLoaded("dice2") {
+		t.Errorf("loaded plugins after replace = %v", pluginSources)
+	}
+}
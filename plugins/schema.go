@@ -0,0 +1,272 @@
+package plugins
+
+import (
+	"bytes"         // 用于比较枚举值
+	"encoding/json" // 用于解析参数和参数模式
+	"fmt"           // 用于格式化输出
+	"sort"          // 用于按顺序报告问题
+	"strings"       // 用于拼接错误信息
+
+	"github.com/sashabaranov/go-openai" // OpenAI GPT库
+)
+
+// maxArgumentProblems是一次校验最多报告的问题数量，避免结果过长
+const maxArgumentProblems = 20
+
+// ArgumentProblem是函数参数中的一个问题
+type ArgumentProblem struct {
+	Path    string `json:"path"`    // 出问题的参数，例如memories[0].type，空字符串表示整个参数对象
+	Problem string `json:"problem"` // 问题的描述
+}
+
+// ArgumentsError表示模型给出的参数不符合插件的参数模式
+type ArgumentsError struct {
+	Function string
+	Problems []ArgumentProblem
+}
+
+func (e *ArgumentsError) Error() string {
+	problems := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		if p.Path == "" {
+			problems[i] = p.Problem
+		} else {
+			problems[i] = p.Path + ": " + p.Problem
+		}
+	}
+	return fmt.Sprintf("invalid arguments for %s: %s", e.Function, strings.Join(problems, "; "))
+}
+
+// schema是参数模式中用于校验的部分，其他关键字会被忽略
+type schema struct {
+	Type                 schemaTypes        `json:"type"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	Enum                 []json.RawMessage  `json:"enum"`
+	Items                *schema            `json:"items"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+}
+
+// schemaTypes是type关键字的值，可以是单个类型，也可以是类型的列表
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("type should be a string or a list of strings")
+	}
+	*t = list
+	return nil
+}
+
+// ValidateArguments函数按函数定义中的参数模式检查模型给出的JSON参数，支持type、properties、required、
+// enum、items和additionalProperties。参数不符合时返回*ArgumentsError，函数定义没有参数模式时只检查JSON格式
+func ValidateArguments(def openai.FunctionDefinition, jsonInput string) error {
+	if strings.TrimSpace(jsonInput) == "" {
+		jsonInput = "{}" // 没有参数的函数，模型有时会给出空字符串
+	}
+
+	var args interface{}
+	decoder := json.NewDecoder(strings.NewReader(jsonInput))
+	decoder.UseNumber() // 保留数字的原样，用于区分整数和小数
+	err := decoder.Decode(&args)
+	if err == nil && decoder.More() {
+		err = fmt.Errorf("unexpected data after the arguments object")
+	}
+	if err != nil {
+		return &ArgumentsError{Function: def.Name, Problems: []ArgumentProblem{{Problem: fmt.Sprintf("arguments are not valid JSON: %v", err)}}}
+	}
+
+	s, err := parseSchema(def.Parameters)
+	if err != nil {
+		fmt.Printf("Error reading the parameters of %s, skipping validation: %v\n", def.Name, err)
+		return nil
+	}
+	if s == nil {
+		s = &schema{Type: schemaTypes{"object"}}
+	}
+
+	v := &validator{}
+	v.check("", args, s)
+	if len(v.problems) == 0 {
+		return nil
+	}
+	if len(v.problems) > maxArgumentProblems {
+		v.problems = append(v.problems[:maxArgumentProblems], ArgumentProblem{Problem: fmt.Sprintf("and %d more problems", len(v.problems)-maxArgumentProblems)})
+	}
+	return &ArgumentsError{Function: def.Name, Problems: v.problems}
+}
+
+// parseSchema函数把任意形式的参数模式（jsonschema.Definition、map或JSON）转换为schema，没有参数模式时返回nil
+func parseSchema(parameters interface{}) (*schema, error) {
+	if parameters == nil {
+		return nil, nil
+	}
+
+	var data []byte
+	switch p := parameters.(type) {
+	case json.RawMessage:
+		data = p
+	case []byte:
+		data = p
+	case string:
+		data = []byte(p)
+	default:
+		var err error
+		if data, err = json.Marshal(p); err != nil {
+			return nil, err
+		}
+	}
+	if len(bytes.TrimSpace(data)) == 0 || string(bytes.TrimSpace(data)) == "null" {
+		return nil, nil
+	}
+
+	var s schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// validator收集校验过程中发现的问题
+type validator struct {
+	problems []ArgumentProblem
+}
+
+func (v *validator) addf(path string, format string, args ...interface{}) {
+	v.problems = append(v.problems, ArgumentProblem{Path: path, Problem: fmt.Sprintf(format, args...)})
+}
+
+// check函数检查value是否符合s，path是value在参数中的位置
+func (v *validator) check(path string, value interface{}, s *schema) {
+	if s == nil {
+		return
+	}
+
+	if len(s.Type) > 0 && !matchesType(value, s.Type) {
+		v.addf(path, "expected %s, got %s", strings.Join(s.Type, " or "), jsonType(value))
+		return // 类型不对时不再检查内部的字段
+	}
+
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		allowed := make([]string, len(s.Enum))
+		for i, e := range s.Enum {
+			allowed[i] = string(e)
+		}
+		v.addf(path, "must be one of %s", strings.Join(allowed, ", "))
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				v.addf(join(path, name), "required property is missing")
+			}
+		}
+
+		names := make([]string, 0, len(val))
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := s.Properties[name]; ok {
+				v.check(join(path, name), val[name], prop)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				v.addf(join(path, name), "unknown property")
+			}
+		}
+
+	case []interface{}:
+		for i, item := range val {
+			v.check(fmt.Sprintf("%s[%d]", path, i), item, s.Items)
+		}
+	}
+}
+
+// join函数拼接对象字段的路径
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// jsonType函数返回值的JSON类型名称
+func jsonType(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if isInteger(val) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// matchesType函数判断值是否属于types中的某个类型，integer也是number
+func matchesType(value interface{}, types []string) bool {
+	actual := jsonType(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// isInteger函数判断数字是否为整数，1.0也算整数
+func isInteger(n json.Number) bool {
+	if _, err := n.Int64(); err == nil {
+		return true
+	}
+	f, err := n.Float64()
+	return err == nil && f == float64(int64(f))
+}
+
+// inEnum函数判断值是否等于枚举中的某一项
+func inEnum(value interface{}, enum []json.RawMessage) bool {
+	for _, e := range enum {
+		var allowed interface{}
+		decoder := json.NewDecoder(bytes.NewReader(e))
+		decoder.UseNumber()
+		if err := decoder.Decode(&allowed); err != nil {
+			continue
+		}
+		if equalJSON(value, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalJSON函数比较两个解码后的JSON值，数字按数值比较
+func equalJSON(a, b interface{}) bool {
+	if na, ok := a.(json.Number); ok {
+		nb, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fa, errA := na.Float64()
+		fb, errB := nb.Float64()
+		return errA == nil && errB == nil && fa == fb
+	}
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
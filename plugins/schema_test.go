@@ -0,0 +1,148 @@
+package plugins
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// memoryParameters是与记忆插件类似的参数模式
+const memoryParameters = `{
+	"type": "object",
+	"properties": {
+		"requestType": {"type": "string", "enum": ["set", "get"]},
+		"count": {"type": "integer"},
+		"ratio": {"type": ["number", "null"]},
+		"memories": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {
+					"memory": {"type": "string"},
+					"type": {"type": "string"}
+				},
+				"required": ["memory", "type"],
+				"additionalProperties": false
+			}
+		}
+	},
+	"required": ["requestType"]
+}`
+
+func TestValidateArguments(t *testing.T) {
+	def := openai.FunctionDefinition{Name: "memory", Parameters: json.RawMessage(memoryParameters)}
+
+	tests := []struct {
+		name     string
+		def      openai.FunctionDefinition
+		input    string
+		problems []ArgumentProblem
+	}{
+		{
+			name:  "valid",
+			def:   def,
+			input: `{"requestType": "set", "count": 2, "ratio": 0.5, "memories": [{"memory": "likes tea", "type": "food"}]}`,
+		},
+		{
+			name:  "null allowed by type list",
+			def:   def,
+			input: `{"requestType": "get", "ratio": null}`,
+		},
+		{
+			name:     "missing required property",
+			def:      def,
+			input:    `{}`,
+			problems: []ArgumentProblem{{Path: "requestType", Problem: "required property is missing"}},
+		},
+		{
+			name:     "value not in enum",
+			def:      def,
+			input:    `{"requestType": "hydrate"}`,
+			problems: []ArgumentProblem{{Path: "requestType", Problem: `must be one of "set", "get"`}},
+		},
+		{
+			name:     "fraction is not an integer",
+			def:      def,
+			input:    `{"requestType": "get", "count": 1.5}`,
+			problems: []ArgumentProblem{{Path: "count", Problem: "expected integer, got number"}},
+		},
+		{
+			name:  "problems inside array items",
+			def:   def,
+			input: `{"requestType": "set", "memories": [{"memory": "a", "type": "b"}, {"memory": 3, "extra": true}]}`,
+			problems: []ArgumentProblem{
+				{Path: "memories[1].type", Problem: "required property is missing"},
+				{Path: "memories[1].extra", Problem: "unknown property"},
+				{Path: "memories[1].memory", Problem: "expected string, got integer"},
+			},
+		},
+		{
+			name:     "wrong type stops at the top",
+			def:      def,
+			input:    `["set"]`,
+			problems: []ArgumentProblem{{Path: "", Problem: "expected object, got array"}},
+		},
+		{
+			name:     "invalid JSON",
+			def:      def,
+			input:    `{"requestType": `,
+			problems: []ArgumentProblem{{Problem: "arguments are not valid JSON: unexpected EOF"}},
+		},
+		{
+			name:     "data after the object",
+			def:      def,
+			input:    `{"requestType": "get"} {}`,
+			problems: []ArgumentProblem{{Problem: "arguments are not valid JSON: unexpected data after the arguments object"}},
+		},
+		{
+			name:  "empty arguments for a function without parameters",
+			def:   openai.FunctionDefinition{Name: "time"},
+			input: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateArguments(tt.def, tt.input)
+			if tt.problems == nil {
+				if err != nil {
+					t.Fatalf("ValidateArguments() = %v, want nil", err)
+				}
+				return
+			}
+
+			var argsErr *ArgumentsError
+			if !errors.As(err, &argsErr) {
+				t.Fatalf("ValidateArguments() = %v, want *ArgumentsError", err)
+			}
+			if argsErr.Function != tt.def.Name {
+				t.Errorf("Function = %q, want %q", argsErr.Function, tt.def.Name)
+			}
+			if !reflect.DeepEqual(argsErr.Problems, tt.problems) {
+				t.Errorf("Problems = %+v, want %+v", argsErr.Problems, tt.problems)
+			}
+		})
+	}
+}
+
+func TestValidateArgumentsLimitsProblems(t *testing.T) {
+	def := openai.FunctionDefinition{
+		Name:       "list",
+		Parameters: json.RawMessage(`{"type": "object", "properties": {"items": {"type": "array", "items": {"type": "string"}}}}`),
+	}
+	input := `{"items": [1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25]}`
+
+	var argsErr *ArgumentsError
+	if err := ValidateArguments(def, input); !errors.As(err, &argsErr) {
+		t.Fatalf("ValidateArguments() = %v, want *ArgumentsError", err)
+	}
+	if len(argsErr.Problems) != maxArgumentProblems+1 {
+		t.Fatalf("got %d problems, want %d", len(argsErr.Problems), maxArgumentProblems+1)
+	}
+	if last := argsErr.Problems[maxArgumentProblems].Problem; last != "and 5 more problems" {
+		t.Errorf("last problem = %q, want %q", last, "and 5 more problems")
+	}
+}
@@ -0,0 +1,411 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/wangergou2023/clara/config"
+	"github.com/wangergou2023/clara/llm"
+	"github.com/wangergou2023/clara/plugins"
+)
+
+// init函数把插件生成器注册为内置插件
+func init() {
+	plugins.Register(&Builder{})
+}
+
+// 生成的插件源码放在Clara源码树中的这个目录下，每个插件一个子目录
+const generatedDir = "plugins/source/generated"
+
+// 编译和测试的时间限制
+const (
+	buildTimeout = 5 * time.Minute
+	testTimeout  = 2 * time.Minute
+)
+
+// maxFeedbackChars是反馈给模型的编译错误的最大长度
+const maxFeedbackChars = 4000
+
+var (
+	idPattern        = regexp.MustCompile(`^[a-z][a-z0-9_]{1,39}$`)
+	goBlockPattern   = regexp.MustCompile("(?s)```go\\s*\\n(.*?)```")
+	jsonBlockPattern = regexp.MustCompile("(?s)```json\\s*\\n(.*?)```")
+	mainFuncPattern  = regexp.MustCompile(`(?m)^func main\(\)`)
+)
+
+// Builder根据自然语言描述让模型编写Go插件，编译并通过冒烟测试后立即加载
+type Builder struct {
+	cfg      config.Cfg
+	provider llm.Provider
+
+	sourceDir   string // Clara源码树的根目录
+	goBin       string // go命令
+	maxAttempts int    // 最多尝试生成的次数，包括第一次
+
+	mu sync.Mutex // 同一时间只生成一个插件
+}
+
+// buildArgs是模型调用plugin_builder时给出的参数
+type buildArgs struct {
+	ID   string `json:"id"`
+	Spec string `json:"spec"`
+}
+
+// Init方法检查Clara源码树和Go工具链，生成插件需要用同一份源码和工具链编译
+func (b *Builder) Init(cfg config.Cfg, provider llm.Provider) error {
+	b.cfg = cfg
+	b.provider = provider
+
+	b.sourceDir = "."
+	if dir, ok := cfg.Lookup("plugins.plugin_builder.source_dir"); ok && dir != "" {
+		b.sourceDir = dir
+	}
+	b.goBin = "go"
+	if bin, ok := cfg.Lookup("plugins.plugin_builder.go"); ok && bin != "" {
+		b.goBin = bin
+	}
+	b.maxAttempts = 3
+	if v, ok := cfg.Lookup("plugins.plugin_builder.max_attempts"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("plugins.plugin_builder.max_attempts %q should be a positive integer", v)
+		}
+		b.maxAttempts = n
+	}
+
+	mod, err := os.ReadFile(filepath.Join(b.sourceDir, "go.mod"))
+	if err != nil || !strings.Contains(string(mod), "module github.com/wangergou2023/clara") {
+		return fmt.Errorf("the Clara source tree was not found in %s (set plugins.plugin_builder.source_dir)", b.sourceDir)
+	}
+	if _, err := exec.LookPath(b.goBin); err != nil {
+		return fmt.Errorf("the Go toolchain is required to build plugins: %v", err)
+	}
+	return nil
+}
+
+// ID方法返回插件的唯一标识符
+func (b *Builder) ID() string {
+	return "plugin_builder"
+}
+
+// Description方法返回插件的描述
+func (b *Builder) Description() string {
+	return "根据描述编写、编译并加载新的插件。"
+}
+
+// Capabilities方法返回插件的能力，生成的源码和编译出的插件会写入插件目录
+func (b *Builder) Capabilities() []string {
+	return []string{plugins.CapabilityFilesystemWrite, plugins.CapabilityNetwork}
+}
+
+// FunctionDefinition方法返回OpenAI函数定义
+func (b *Builder) FunctionDefinition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{
+		Name:        "plugin_builder",
+		Description: "当现有插件都无法完成用户的请求时，编写一个新的插件。插件会被编译、测试并立即加载，之后可以像其他插件一样调用。",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"id": {
+					Type:        jsonschema.String,
+					Description: "新插件的ID，也是函数名，只能包含小写字母、数字和下划线，例如unit_converter",
+				},
+				"spec": {
+					Type:        jsonschema.String,
+					Description: "插件要做什么、需要哪些参数、返回什么，越具体越好",
+				},
+			},
+			Required: []string{"id", "spec"},
+		},
+	}
+}
+
+func (b *Builder) Execute(jsonInput string) (string, error) {
+	return b.ExecuteContext(context.Background(), jsonInput)
+}
+
+// ExecuteContext方法生成插件。编译或冒烟测试失败时把错误交给模型修复，最多尝试maxAttempts次。
+// ctx结束时会中止模型请求和正在执行的go命令
+func (b *Builder) ExecuteContext(ctx context.Context, jsonInput string) (string, error) {
+	var args buildArgs
+	if err := json.Unmarshal([]byte(jsonInput), &args); err != nil {
+		return "", fmt.Errorf("invalid arguments: %v", err)
+	}
+	if !idPattern.MatchString(args.ID) {
+		return "", fmt.Errorf("invalid plugin id %q: use 2-40 lowercase letters, digits and underscores, starting with a letter", args.ID)
+	}
+	if strings.TrimSpace(args.Spec) == "" {
+		return "", fmt.Errorf("spec is required")
+	}
+	if plugins.IsPluginLoaded(args.ID) {
+		return "", fmt.Errorf("a plugin with ID %s is already loaded, choose another id", args.ID)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: builderPrompt(args.ID)},
+		{Role: openai.ChatMessageRoleUser, Content: args.Spec},
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= b.maxAttempts; attempt++ {
+		reply, err := b.complete(ctx, messages)
+		if err != nil {
+			return "", err
+		}
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: reply})
+
+		output, err := b.build(ctx, args.ID, reply)
+		if err == nil {
+			return fmt.Sprintf("插件 %s 已编译、通过测试并加载，现在可以调用它。\n%s", args.ID, output), nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+
+		fmt.Printf("plugin_builder: attempt %d/%d for %s failed: %v\n", attempt, b.maxAttempts, args.ID, err)
+		lastErr = err
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleUser,
+			Content: fmt.Sprintf("失败了，请修复后重新输出完整的源文件和测试参数：\n%s", truncate(err.Error(), maxFeedbackChars)),
+		})
+	}
+
+	return "", fmt.Errorf("could not build plugin %s after %d attempts: %s", args.ID, b.maxAttempts, truncate(lastErr.Error(), maxFeedbackChars))
+}
+
+// complete函数请求模型编写或修复插件
+func (b *Builder) complete(ctx context.Context, messages []openai.ChatCompletionMessage) (string, error) {
+	resp, err := b.provider.Chat(ctx, openai.ChatCompletionRequest{Messages: messages})
+	if err != nil {
+		return "", fmt.Errorf("error asking the model to write the plugin: %v", err)
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// build函数写入模型给出的源码和冒烟测试，编译为Go插件，测试通过后加载。返回测试的输出
+func (b *Builder) build(ctx context.Context, id string, reply string) (string, error) {
+	source := goBlockPattern.FindStringSubmatch(reply)
+	if source == nil {
+		return "", fmt.Errorf("no ```go code block found in the reply")
+	}
+	testArgs := "{}"
+	if m := jsonBlockPattern.FindStringSubmatch(reply); m != nil {
+		testArgs = strings.TrimSpace(m[1])
+	}
+	if !json.Valid([]byte(testArgs)) {
+		return "", fmt.Errorf("the ```json test arguments are not valid JSON")
+	}
+
+	code := source[1]
+	// Go插件的main包不需要main函数，但go build ./...需要
+	if !mainFuncPattern.MatchString(code) {
+		code += "\nfunc main() {}\n"
+	}
+
+	pkgDir := filepath.Join(b.sourceDir, generatedDir, id)
+	if err := os.MkdirAll(pkgDir, 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(pkgDir, "plugin.go"), []byte(code), 0o644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(pkgDir, "smoke_test.go"), []byte(smokeTest(id, testArgs)), 0o644); err != nil {
+		return "", err
+	}
+
+	compiledDir := filepath.Join(b.cfg.PluginsPath(), "compiled")
+	if err := os.MkdirAll(compiledDir, 0o755); err != nil {
+		return "", err
+	}
+	out, err := filepath.Abs(filepath.Join(compiledDir, id+".so.tmp"))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(out)
+
+	pkg := "./" + filepath.ToSlash(filepath.Join(generatedDir, id))
+	if _, err := b.goCommand(ctx, buildTimeout, "build", "-buildmode=plugin", "-o", out, pkg); err != nil {
+		return "", fmt.Errorf("compile failed:\n%v", err)
+	}
+	testOutput, err := b.goCommand(ctx, testTimeout, "test", "-count=1", "-v", "-run", "TestSmoke", pkg)
+	if err != nil {
+		return "", fmt.Errorf("smoke test failed:\n%v", err)
+	}
+
+	// 测试通过后才放进插件目录，监视插件目录时不会加载到没有通过测试的插件
+	path := filepath.Join(compiledDir, id+".so")
+	if err := os.Rename(out, path); err != nil {
+		return "", err
+	}
+	if _, err := plugins.Install(path, b.cfg, b.provider); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("error loading the compiled plugin: %v", err)
+	}
+	return testOutput, nil
+}
+
+// goCommand函数在Clara源码树中执行go命令，失败时错误中包含命令的输出
+func (b *Builder) goCommand(parent context.Context, timeout time.Duration, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, b.goBin, args...)
+	cmd.Dir = b.sourceDir
+	// 生成的代码由模型编写，构建和测试都不能下载模块，只能使用本机已有的依赖
+	cmd.Env = append(os.Environ(), "GOPROXY=off", "GOFLAGS=-mod=readonly")
+	output, err := cmd.CombinedOutput()
+	if parent.Err() != nil {
+		return "", parent.Err()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("go %s did not finish within %v", args[0], timeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%v\n%s", err, output)
+	}
+	return string(output), nil
+}
+
+// truncate函数把过长的文本截断为n个字符，按字符截断以免切开UTF-8编码
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "\n..."
+}
+
+// builderPrompt函数返回指导模型编写插件的系统提示
+func builderPrompt(id string) string {
+	return fmt.Sprintf("你是Clara的插件生成器，根据用户的描述编写一个Go插件。\n\n"+
+		"要求：\n"+
+		"- 输出一个完整的Go源文件，放在```go代码块中；再输出一个```json代码块，内容是冒烟测试调用Execute时使用的参数。\n"+
+		"- 使用package main，并导出变量 var Plugin plugins.Plugin = &YourPlugin{}。\n"+
+		"- ID()和FunctionDefinition().Name都必须返回%q。\n"+
+		"- 只能使用标准库和以下包：github.com/sashabaranov/go-openai、github.com/sashabaranov/go-openai/jsonschema、"+
+		"github.com/wangergou2023/clara/config、github.com/wangergou2023/clara/llm、github.com/wangergou2023/clara/plugins。\n"+
+		"- Init的provider在测试中为nil，不要在Init中访问网络。\n"+
+		"- Execute的参数是模型给出的JSON字符串，返回给模型的结果；出错时返回error。冒烟测试中Execute必须在30秒内成功返回。\n\n"+
+		"插件需要实现的接口：\n\n"+
+		"type Plugin interface {\n"+
+		"\tInit(cfg config.Cfg, provider llm.Provider) error\n"+
+		"\tID() string\n"+
+		"\tDescription() string\n"+
+		"\tFunctionDefinition() openai.FunctionDefinition\n"+
+		"\tExecute(string) (string, error)\n"+
+		"}\n\n"+
+		"示例：\n\n%s", id, examplePlugin)
+}
+
+// examplePlugin是提示中给模型参考的插件
+const examplePlugin = "```go\n" + `package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/wangergou2023/clara/config"
+	"github.com/wangergou2023/clara/llm"
+	"github.com/wangergou2023/clara/plugins"
+)
+
+var Plugin plugins.Plugin = &Upper{}
+
+type Upper struct{}
+
+func (u *Upper) Init(cfg config.Cfg, provider llm.Provider) error { return nil }
+
+func (u *Upper) ID() string { return "upper" }
+
+func (u *Upper) Description() string { return "把文本转换为大写。" }
+
+func (u *Upper) FunctionDefinition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{
+		Name:        "upper",
+		Description: "把文本转换为大写。",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"text": {Type: jsonschema.String, Description: "要转换的文本"},
+			},
+			Required: []string{"text"},
+		},
+	}
+}
+
+func (u *Upper) Execute(jsonInput string) (string, error) {
+	var args struct {
+		Text string ` + "`json:\"text\"`" + `
+	}
+	if err := json.Unmarshal([]byte(jsonInput), &args); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(args.Text), nil
+}
+` + "```\n\n```json\n{\"text\": \"hello\"}\n```"
+
+// smokeTest函数生成插件的冒烟测试：检查ID和函数定义，初始化插件，并用模型给出的参数调用一次Execute
+func smokeTest(id string, testArgs string) string {
+	return fmt.Sprintf(`// 由plugin_builder生成的冒烟测试
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/wangergou2023/clara/config"
+)
+
+func TestSmoke(t *testing.T) {
+	if Plugin == nil {
+		t.Fatal("Plugin is nil")
+	}
+	if id := Plugin.ID(); id != %[1]q {
+		t.Fatalf("ID() = %%q, want %%q", id, %[1]q)
+	}
+	def := Plugin.FunctionDefinition()
+	if def.Name != %[1]q {
+		t.Fatalf("FunctionDefinition().Name = %%q, want %%q", def.Name, %[1]q)
+	}
+	if _, err := json.Marshal(def); err != nil {
+		t.Fatalf("FunctionDefinition() cannot be encoded: %%v", err)
+	}
+	if err := Plugin.Init(config.New(), nil); err != nil {
+		t.Fatalf("Init: %%v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		result, err := Plugin.Execute(%[2]q)
+		if err != nil {
+			t.Errorf("Execute: %%v", err)
+			return
+		}
+		t.Logf("Execute returned: %%s", result)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Execute did not return within 30s")
+	}
+}
+`, id, testArgs)
+}
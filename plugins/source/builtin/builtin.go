@@ -0,0 +1,9 @@
+// builtin包导入所有内置插件，导入后各插件会在init函数中通过plugins.Register注册自己
+package builtin
+
+import (
+	_ "github.com/wangergou2023/clara/plugins/source/builtin/builder" // 插件生成器
+	_ "github.com/wangergou2023/clara/plugins/source/builtin/memory"  // 记忆插件
+	_ "github.com/wangergou2023/clara/plugins/source/builtin/time"    // 时间插件
+	_ "github.com/wangergou2023/clara/plugins/source/builtin/weather" // 天气插件
+)
@@ -0,0 +1,264 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wangergou2023/clara/llm"
+)
+
+// defaultHydrateTokens is the default size of the hydrate prompt, see plugins.memory.hydrate_tokens.
+const defaultHydrateTokens = 800
+
+// hydrateResultsPerProbe is how many memories each probe retrieves.
+const hydrateResultsPerProbe = 5
+
+// hydrateConcurrency is how many probe searches run at once.
+const hydrateConcurrency = 8
+
+// How a memory is ranked for the hydrate prompt: mostly by how well it matches a probe, then by
+// how recently it was updated and how important it is. Recency halves every recencyHalfLife.
+const (
+	relevanceWeight  = 0.6
+	recencyWeight    = 0.2
+	importanceWeight = 0.2
+	recencyHalfLife  = 30 * 24 * time.Hour
+)
+
+// hydratePrompt introduces the memories in the hydrate prompt.
+const hydratePrompt = "你是一个名叫Clara的AI助手，你拥有长期记忆，以下是一些关于用户的记忆，你可以使用："
+
+// hydrateProbes are the kinds of memories hydrate looks for, one search each.
+var hydrateProbes = []memoryItem{
+	{Type: "Basic Personal Information", Detail: "name"},
+	{Type: "Basic Personal Information", Detail: "age"},
+	{Type: "Basic Personal Information", Detail: "gender"},
+	{Type: "Basic Personal Information", Detail: "location"},
+
+	{Type: "Preferences", Detail: "music_preference"},
+	{Type: "Preferences", Detail: "movie_preference"},
+	{Type: "Preferences", Detail: "book_preference"},
+	{Type: "Preferences", Detail: "food_preference"},
+
+	{Type: "Professional and Educational Background", Detail: "profession"},
+	{Type: "Professional and Educational Background", Detail: "education"},
+	{Type: "Professional and Educational Background", Detail: "skills"},
+
+	{Type: "Hobbies and Interests", Detail: "hobbies"},
+	{Type: "Hobbies and Interests", Detail: "sports"},
+	{Type: "Hobbies and Interests", Detail: "travel"},
+	{Type: "Hobbies and Interests", Detail: "games"},
+
+	{Type: "Lifestyle and Habits", Detail: "exercise_habit"},
+	{Type: "Lifestyle and Habits", Detail: "reading_habit"},
+	{Type: "Lifestyle and Habits", Detail: "diet"},
+	{Type: "Lifestyle and Habits", Detail: "pets"},
+
+	{Type: "Tech and Media Consumption", Detail: "favorite_apps"},
+	{Type: "Tech and Media Consumption", Detail: "device_preference"},
+	{Type: "Tech and Media Consumption", Detail: "news_source"},
+
+	{Type: "Social and Personal Relationships", Detail: "family"},
+	{Type: "Social and Personal Relationships", Detail: "friends"},
+	{Type: "Social and Personal Relationships", Detail: "relationship_status"},
+
+	{Type: "Past Interactions", Detail: "past_questions"},
+	{Type: "Past Interactions", Detail: "feedback"},
+	{Type: "Past Interactions", Detail: "topics_of_interest"},
+
+	{Type: "Moods and Feelings", Detail: "current_mood"},
+	{Type: "Moods and Feelings", Detail: "life_events"},
+	{Type: "Moods and Feelings", Detail: "challenges"},
+
+	{Type: "Custom User Data", Detail: "custom_data"},
+}
+
+// probeCache keeps the embeddings of hydrateProbes, which never change for a provider.
+type probeCache struct {
+	mu      sync.Mutex
+	vectors [][]float32
+}
+
+// get returns the probe embeddings, asking the provider for all of them in a single request
+// the first time. A failed request is not cached, so the next hydrate tries again.
+func (p *probeCache) get(ctx context.Context, c *Memory) ([][]float32, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.vectors != nil {
+		return p.vectors, nil
+	}
+
+	texts := make([]string, len(hydrateProbes))
+	for i, probe := range hydrateProbes {
+		texts[i] = embeddingText(probe.Type, probe.Detail, probe.Memory)
+	}
+	vectors, err := c.provider.Embeddings(ctx, texts)
+	if err != nil {
+		fmt.Println("Error getting embeddings: ", err)
+		return nil, err
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(vectors))
+	}
+	for _, vector := range vectors {
+		if len(vector) != c.dimensions {
+			return nil, fmt.Errorf("the embedding model returned %d dimensions but the collection expects %d, set plugins.memory.dimensions to match", len(vector), c.dimensions)
+		}
+	}
+
+	p.vectors = vectors
+	return vectors, nil
+}
+
+// rankedMemory is a memory found by hydrate with its best relevance to any probe.
+type rankedMemory struct {
+	Record
+	relevance float32
+	rank      float64
+}
+
+// HydrateUserMemories builds a prompt with the memories that tell the most about the user. It
+// searches the store once per probe, concurrently, ranks what it finds by relevance, recency and
+// importance, and lists the best memories grouped by type within plugins.memory.hydrate_tokens.
+func (c *Memory) HydrateUserMemories(ctx context.Context) (string, error) {
+	vectors, err := c.probes.get(ctx, c)
+	if err != nil {
+		return "", err
+	}
+
+	found, err := c.searchProbes(ctx, vectors)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	ranked := make([]rankedMemory, 0, len(found))
+	for _, memory := range found {
+		memory.rank = rankScore(memory.relevance, memory.Record, now)
+		ranked = append(ranked, memory)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].rank != ranked[j].rank {
+			return ranked[i].rank > ranked[j].rank
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+
+	return formatHydratePrompt(ranked, c.hydrateTokens), nil
+}
+
+// searchProbes runs one search per probe vector and returns every memory found, once.
+// The first failed search cancels the others.
+func (c *Memory) searchProbes(ctx context.Context, vectors [][]float32) (map[int64]rankedMemory, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		found    = make(map[int64]rankedMemory)
+	)
+	limit := make(chan struct{}, hydrateConcurrency)
+
+	for _, vector := range vectors {
+		wg.Add(1)
+		go func(vector []float32) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			results, err := c.store.Search(ctx, vector, hydrateResultsPerProbe, Filter{})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			for _, res := range results {
+				if existing, ok := found[res.ID]; ok && existing.relevance >= res.Score {
+					continue
+				}
+				found[res.ID] = rankedMemory{Record: res.Record, relevance: res.Score}
+			}
+		}(vector)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return found, nil
+}
+
+// rankScore combines relevance, recency and importance, each between 0 and 1. Memories without
+// a timestamp, which were migrated from an older version, count as old.
+func rankScore(relevance float32, record Record, now time.Time) float64 {
+	recency := 0.0
+	if !record.UpdatedAt.IsZero() {
+		age := now.Sub(record.UpdatedAt)
+		if age < 0 {
+			age = 0
+		}
+		recency = math.Pow(0.5, float64(age)/float64(recencyHalfLife))
+	}
+	importance := float64(clampImportance(record.Importance)-minImportance) / float64(maxImportance-minImportance)
+
+	return relevanceWeight*float64(relevance) + recencyWeight*recency + importanceWeight*importance
+}
+
+// formatHydratePrompt lists as many of the ranked memories as fit in budget tokens, best first,
+// under a heading per type. Types are ordered by their best memory.
+func formatHydratePrompt(ranked []rankedMemory, budget int) string {
+	used := llm.EstimateTokens(hydratePrompt)
+	var types []string
+	byType := make(map[string][]string)
+
+	for _, memory := range ranked {
+		if strings.TrimSpace(memory.Text) == "" {
+			continue
+		}
+		memoryType := memory.Type
+		if memoryType == "" {
+			memoryType = "Other"
+		}
+		line := "- " + memory.Text
+		if memory.Detail != "" {
+			line = "- " + memory.Detail + ": " + memory.Text
+		}
+
+		cost := llm.EstimateTokens(line) + 1
+		if _, ok := byType[memoryType]; !ok {
+			cost += llm.EstimateTokens(memoryType) + 2
+		}
+		if used+cost > budget {
+			continue // a shorter memory further down may still fit
+		}
+		used += cost
+
+		if _, ok := byType[memoryType]; !ok {
+			types = append(types, memoryType)
+		}
+		byType[memoryType] = append(byType[memoryType], line)
+	}
+
+	var b strings.Builder
+	b.WriteString(hydratePrompt)
+	for _, memoryType := range types {
+		b.WriteString("\n" + memoryType + ":")
+		for _, line := range byType[memoryType] {
+			b.WriteString("\n" + line)
+		}
+	}
+	return b.String()
+}
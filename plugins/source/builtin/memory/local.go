@@ -0,0 +1,329 @@
+package memory
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// localStore keeps memories in memory and persists them to a JSON Lines file. Search compares
+// the query with every vector, which is fast enough for the few thousand memories of one user.
+//
+// The file is an append-only log of put and delete entries. It is replayed and compacted when
+// the store is opened, so a crash can lose at most the last, partly written line. Compacting
+// starts the file with a schema entry; files without one hold "type|detail|memory" texts,
+// which are split into fields when they are loaded.
+type localStore struct {
+	path       string
+	dimensions int
+
+	mu      sync.Mutex
+	records map[int64]Record
+	nextID  int64
+}
+
+// localEntry is one line of the local store's file.
+type localEntry struct {
+	Op string `json:"op"` // localSchema, localPut or localDelete
+	*Record
+	IDs     []int64 `json:"ids,omitempty"`     // the records removed by a delete
+	Version int     `json:"version,omitempty"` // the file format declared by a schema entry
+}
+
+const (
+	localSchema = "schema"
+	localPut    = "put"
+	localDelete = "delete"
+)
+
+// localVersion is the file format with separate type, detail and timestamp fields.
+const localVersion = 2
+
+// openLocalStore loads the memories kept at path, creating the file if it does not exist.
+func openLocalStore(path string, dimensions int) (*localStore, error) {
+	s := &localStore{
+		path:       path,
+		dimensions: dimensions,
+		records:    make(map[int64]Record),
+		nextID:     1,
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, fmt.Errorf("error compacting %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// load replays the file. Only the last line may be corrupt, e.g. after a crash while writing.
+func (s *localStore) load() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	version := 1
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var entry localEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			if scanner.Scan() {
+				return fmt.Errorf("error parsing %s: %v", s.path, err)
+			}
+			break
+		}
+		if entry.Op == localSchema {
+			version = entry.Version
+			continue
+		}
+		if entry.Op == localPut && entry.Record != nil && version < localVersion {
+			legacy := legacyRecord(entry.ID, entry.Text, entry.Vector)
+			entry.Record = &legacy
+		}
+		s.apply(entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for _, record := range s.records {
+		if len(record.Vector) != s.dimensions {
+			return fmt.Errorf("%s holds %d dimensional vectors but plugins.memory.dimensions is %d", s.path, len(record.Vector), s.dimensions)
+		}
+	}
+	return nil
+}
+
+// apply updates the in-memory records with an entry read from or written to the file.
+func (s *localStore) apply(entry localEntry) {
+	switch entry.Op {
+	case localPut:
+		if entry.Record == nil {
+			return
+		}
+		s.records[entry.ID] = *entry.Record
+		if entry.ID >= s.nextID {
+			s.nextID = entry.ID + 1
+		}
+	case localDelete:
+		for _, id := range entry.IDs {
+			delete(s.records, id)
+		}
+	}
+}
+
+// compact rewrites the file with one put per record, dropping deleted and replaced records.
+func (s *localStore) compact() error {
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	ids := s.sortedIDs()
+	entries := make([]localEntry, 0, len(ids)+1)
+	entries = append(entries, localEntry{Op: localSchema, Version: localVersion})
+	for _, id := range ids {
+		record := s.records[id]
+		entries = append(entries, localEntry{Op: localPut, Record: &record})
+	}
+
+	tmp := s.path + ".tmp"
+	if err := writeEntries(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, entries); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// appendEntries writes entries to the end of the file and then applies them.
+func (s *localStore) appendEntries(entries ...localEntry) error {
+	if err := writeEntries(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, entries); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		s.apply(entry)
+	}
+	return nil
+}
+
+func writeEntries(path string, flag int, entries []localEntry) error {
+	var buf strings.Builder
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("error marshaling memory: %v", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	f, err := os.OpenFile(path, flag, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(buf.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *localStore) sortedIDs() []int64 {
+	ids := make([]int64, 0, len(s.records))
+	for id := range s.records {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (s *localStore) checkVector(vector []float32) error {
+	if len(vector) != s.dimensions {
+		return fmt.Errorf("expected a %d dimensional vector, got %d", s.dimensions, len(vector))
+	}
+	return nil
+}
+
+func (s *localStore) Insert(ctx context.Context, records []Record) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int64, 0, len(records))
+	entries := make([]localEntry, 0, len(records))
+	for i, record := range records {
+		if err := s.checkVector(record.Vector); err != nil {
+			return nil, err
+		}
+		r := record
+		r.ID = s.nextID + int64(i)
+		r.Vector = normalize(record.Vector)
+		ids = append(ids, r.ID)
+		entries = append(entries, localEntry{Op: localPut, Record: &r})
+	}
+
+	if err := s.appendEntries(entries...); err != nil {
+		fmt.Println("Error writing memories: ", err)
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (s *localStore) Search(ctx context.Context, vector []float32, topK int, filter Filter) ([]SearchResult, error) {
+	if err := s.checkVector(vector); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]SearchResult, 0, len(s.records))
+	for _, record := range s.records {
+		if !filter.Match(record) {
+			continue
+		}
+		result := SearchResult{Record: record, Score: cosineSimilarity(vector, record.Vector)}
+		result.Vector = nil
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+
+	if topK >= 0 && len(results) > topK {
+		results = results[:topK]
+	}
+	return results, nil
+}
+
+func (s *localStore) Delete(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.appendEntries(localEntry{Op: localDelete, IDs: ids}); err != nil {
+		fmt.Println("Error deleting memories: ", err)
+		return err
+	}
+	return nil
+}
+
+func (s *localStore) List(ctx context.Context, filter Filter, offset, limit int) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := s.sortedIDs()
+	records := make([]Record, 0, len(ids))
+	for _, id := range ids {
+		record := s.records[id]
+		if !filter.Match(record) {
+			continue
+		}
+		record.Vector = nil
+		records = append(records, record)
+	}
+	return page(records, offset, limit), nil
+}
+
+// Update rewrites the record under the same ID.
+func (s *localStore) Update(ctx context.Context, record Record) (int64, error) {
+	if err := s.checkVector(record.Vector); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[record.ID]; !ok {
+		return 0, fmt.Errorf("memory %d not found", record.ID)
+	}
+	record.Vector = normalize(record.Vector)
+	if err := s.appendEntries(localEntry{Op: localPut, Record: &record}); err != nil {
+		fmt.Println("Error updating memory: ", err)
+		return 0, err
+	}
+	return record.ID, nil
+}
+
+func (s *localStore) Close() error {
+	return nil
+}
+
+// cosineSimilarity returns the cosine of the angle between a and b, 0 if either is all zeros.
+func cosineSimilarity(a, b []float32) float32 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / (math.Sqrt(normA) * math.Sqrt(normB)))
+}
@@ -0,0 +1,226 @@
+package memory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeLog writes the lines to a memories file in a temporary directory and returns its path.
+func writeLog(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "memories.jsonl")
+	if len(lines) > 0 {
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+// texts returns "type|detail|text" of every record, keyed by ID.
+func texts(t *testing.T, s *localStore) map[int64]string {
+	t.Helper()
+	records, err := s.List(context.Background(), Filter{}, 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[int64]string, len(records))
+	for _, r := range records {
+		result[r.ID] = r.Type + "|" + r.Detail + "|" + r.Text
+	}
+	return result
+}
+
+func TestLocalStoreReplay(t *testing.T) {
+	schema := `{"op":"schema","version":2}`
+	tests := []struct {
+		name    string
+		lines   []string
+		want    map[int64]string
+		nextID  int64
+		wantErr string
+	}{
+		{
+			name:   "missing file",
+			want:   map[int64]string{},
+			nextID: 1,
+		},
+		{
+			name: "puts, an update and a delete",
+			lines: []string{
+				schema,
+				`{"op":"put","id":1,"type":"food","detail":"drink","text":"likes tea","vector":[1,0]}`,
+				`{"op":"put","id":2,"type":"pets","detail":"cat","text":"has a cat","vector":[0,1]}`,
+				`{"op":"put","id":1,"type":"food","detail":"drink","text":"likes green tea","vector":[1,0]}`,
+				`{"op":"put","id":3,"type":"work","detail":"job","text":"is a nurse","vector":[1,1]}`,
+				`{"op":"delete","ids":[2]}`,
+			},
+			want:   map[int64]string{1: "food|drink|likes green tea", 3: "work|job|is a nurse"},
+			nextID: 4,
+		},
+		{
+			name: "truncated last line is ignored",
+			lines: []string{
+				schema,
+				`{"op":"put","id":1,"type":"food","detail":"drink","text":"likes tea","vector":[1,0]}`,
+				`{"op":"delete","ids":[`,
+			},
+			want:   map[int64]string{1: "food|drink|likes tea"},
+			nextID: 2,
+		},
+		{
+			name: "file without a schema holds combined texts",
+			lines: []string{
+				`{"op":"put","id":5,"text":"food | drink | likes tea","vector":[1,0]}`,
+				`{"op":"put","id":6,"text":"no separators","vector":[0,1]}`,
+			},
+			want:   map[int64]string{5: "food|drink|likes tea", 6: "||no separators"},
+			nextID: 7,
+		},
+		{
+			name: "corrupt line in the middle",
+			lines: []string{
+				schema,
+				`{"op":"put","id":1,`,
+				`{"op":"put","id":2,"type":"pets","detail":"cat","text":"has a cat","vector":[0,1]}`,
+			},
+			wantErr: "error parsing",
+		},
+		{
+			name: "vectors of another dimension",
+			lines: []string{
+				schema,
+				`{"op":"put","id":1,"type":"food","detail":"drink","text":"likes tea","vector":[1,0,0]}`,
+			},
+			wantErr: "holds 3 dimensional vectors but plugins.memory.dimensions is 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := openLocalStore(writeLog(t, tt.lines...), 2)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("openLocalStore() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("openLocalStore() = %v", err)
+			}
+			if got := texts(t, s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("records = %v, want %v", got, tt.want)
+			}
+			if s.nextID != tt.nextID {
+				t.Errorf("nextID = %d, want %d", s.nextID, tt.nextID)
+			}
+		})
+	}
+}
+
+func TestLocalStoreCompaction(t *testing.T) {
+	path := writeLog(t,
+		`{"op":"put","id":2,"text":"pets|cat|has a cat","vector":[0,1]}`,
+		`{"op":"put","id":1,"text":"food|drink|likes tea","vector":[1,0]}`,
+		`{"op":"put","id":1,"text":"food|drink|likes green tea","vector":[1,0]}`,
+		`{"op":"put","id":3,"text":"work|job|is a nurse","vector":[1,1]}`,
+		`{"op":"delete","ids":[3]}`,
+		`{"op":"put","id":4,`,
+	)
+	if _, err := openLocalStore(path, 2); err != nil {
+		t.Fatalf("openLocalStore() = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	want := []string{
+		`{"op":"schema","version":2}`,
+		`{"op":"put","id":1,"type":"food","detail":"drink","text":"likes green tea","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z","importance":3,"vector":[1,0]}`,
+		`{"op":"put","id":2,"type":"pets","detail":"cat","text":"has a cat","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z","importance":3,"vector":[0,1]}`,
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("compacted file:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file was left behind: %v", err)
+	}
+}
+
+func TestLocalStoreChangesSurviveReopen(t *testing.T) {
+	ctx := context.Background()
+	path := writeLog(t)
+
+	s, err := openLocalStore(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := s.Insert(ctx, []Record{
+		{Type: "food", Detail: "drink", Text: "likes tea", Vector: []float32{1, 0}},
+		{Type: "pets", Detail: "cat", Text: "has a cat", Vector: []float32{0, 1}},
+		{Type: "work", Detail: "job", Text: "is a nurse", Vector: []float32{1, 1}},
+	})
+	if err != nil {
+		t.Fatalf("Insert() = %v", err)
+	}
+	if !reflect.DeepEqual(ids, []int64{1, 2, 3}) {
+		t.Fatalf("Insert() ids = %v, want [1 2 3]", ids)
+	}
+	if _, err := s.Update(ctx, Record{ID: 1, Type: "food", Detail: "drink", Text: "likes green tea", Vector: []float32{1, 0}}); err != nil {
+		t.Fatalf("Update() = %v", err)
+	}
+	if _, err := s.Update(ctx, Record{ID: 9, Vector: []float32{1, 0}}); err == nil {
+		t.Error("Update() of an unknown id succeeded")
+	}
+	if err := s.Delete(ctx, []int64{2}); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	if _, err := s.Insert(ctx, []Record{{Vector: []float32{1}}}); err == nil {
+		t.Error("Insert() accepted a vector of the wrong dimension")
+	}
+
+	reopened, err := openLocalStore(path, 2)
+	if err != nil {
+		t.Fatalf("openLocalStore() = %v", err)
+	}
+	want := map[int64]string{1: "food|drink|likes green tea", 3: "work|job|is a nurse"}
+	if got := texts(t, reopened); !reflect.DeepEqual(got, want) {
+		t.Errorf("records after reopening = %v, want %v", got, want)
+	}
+
+	results, err := reopened.Search(ctx, []float32{1, 0.1}, 1, Filter{})
+	if err != nil || len(results) != 1 || results[0].ID != 1 {
+		t.Errorf("Search() = %+v, %v; want memory 1", results, err)
+	}
+}
+
+func TestLocalStoreNormalizesVectors(t *testing.T) {
+	ctx := context.Background()
+	s, err := openLocalStore(writeLog(t), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := s.Insert(ctx, []Record{{Text: "a", Vector: []float32{3, 4}}, {Text: "zero", Vector: []float32{0, 0}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.records[ids[0]].Vector; !reflect.DeepEqual(got, []float32{0.6, 0.8}) {
+		t.Errorf("stored vector = %v, want [0.6 0.8]", got)
+	}
+	if got := s.records[ids[1]].Vector; !reflect.DeepEqual(got, []float32{0, 0}) {
+		t.Errorf("stored zero vector = %v, want [0 0]", got)
+	}
+
+	if _, err := s.Update(ctx, Record{ID: ids[0], Text: "b", Vector: []float32{0, 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.records[ids[0]].Vector; !reflect.DeepEqual(got, []float32{0, 1}) {
+		t.Errorf("updated vector = %v, want [0 1]", got)
+	}
+}
@@ -0,0 +1,571 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	milvus "github.com/milvus-io/milvus-sdk-go/v2/client"
+	"github.com/milvus-io/milvus-sdk-go/v2/entity"
+	"github.com/wangergou2023/clara/config"
+)
+
+// milvusConnectTimeout is how long Init waits for Milvus before the plugin is skipped.
+const milvusConnectTimeout = 10 * time.Second
+
+// milvusMaxQueryResults is the most rows a single Milvus query may return.
+const milvusMaxQueryResults = 16384
+
+// milvusInsertBatch is how many rows the migration inserts at a time.
+const milvusInsertBatch = 1000
+
+// milvusOutputFields are the scalar fields read back into a Record.
+var milvusOutputFields = []string{"memory_id", "type", "detail", "text", "created_at", "updated_at", "session_id", "importance", "history"}
+
+// milvusStore keeps memories in a Milvus collection.
+type milvusStore struct {
+	milvusClient milvus.Client
+	collection   string
+	dimensions   int
+}
+
+// openMilvusStore connects to Milvus and creates and loads the collection if needed.
+func openMilvusStore(cfg config.Cfg, dimensions int) (*milvusStore, error) {
+	// The Milvus client blocks until it is connected, so give up if Milvus is not reachable.
+	ctx, cancel := context.WithTimeout(context.Background(), milvusConnectTimeout)
+	defer cancel()
+
+	milvusClient, err := milvus.NewGrpcClient(ctx, cfg.MalvusApiEndpoint())
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to Milvus at %s: %v", cfg.MalvusApiEndpoint(), err)
+	}
+
+	s := &milvusStore{
+		milvusClient: milvusClient,
+		collection:   cfg.MalvusCollectionName(),
+		dimensions:   dimensions,
+	}
+	if err := s.initMilvusSchema(); err != nil {
+		fmt.Println("Error initializing Milvus schema: ", err)
+		milvusClient.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *milvusStore) Insert(ctx context.Context, records []Record) ([]int64, error) {
+	types := make([]string, 0, len(records))
+	details := make([]string, 0, len(records))
+	texts := make([]string, 0, len(records))
+	created := make([]int64, 0, len(records))
+	updated := make([]int64, 0, len(records))
+	sessions := make([]string, 0, len(records))
+	importance := make([]int64, 0, len(records))
+	history := make([]string, 0, len(records))
+	vectors := make([][]float32, 0, len(records))
+
+	for _, record := range records {
+		types = append(types, record.Type)
+		details = append(details, record.Detail)
+		texts = append(texts, record.Text)
+		created = append(created, unixSeconds(record.CreatedAt))
+		updated = append(updated, unixSeconds(record.UpdatedAt))
+		sessions = append(sessions, record.SessionID)
+		importance = append(importance, int64(record.Importance))
+		encoded, err := encodeHistory(record.History)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, encoded)
+		vectors = append(vectors, normalize(record.Vector))
+	}
+
+	ids, err := s.milvusClient.Insert(ctx, s.collection, "",
+		entity.NewColumnVarChar("type", types),
+		entity.NewColumnVarChar("detail", details),
+		entity.NewColumnVarChar("text", texts),
+		entity.NewColumnInt64("created_at", created),
+		entity.NewColumnInt64("updated_at", updated),
+		entity.NewColumnVarChar("session_id", sessions),
+		entity.NewColumnInt64("importance", importance),
+		entity.NewColumnVarChar("history", history),
+		entity.NewColumnFloatVector("embeddings", s.dimensions, vectors))
+	if err != nil {
+		fmt.Println("Error inserting into Milvus client: ", err)
+		return nil, err
+	}
+
+	return int64sFromColumn(ids)
+}
+
+func (s *milvusStore) Search(ctx context.Context, vector []float32, topK int, filter Filter) ([]SearchResult, error) {
+	partitions := []string{}
+	expr := filterExpr(filter)
+	outputFields := milvusOutputFields
+	vectors := []entity.Vector{entity.FloatVector(normalize(vector))}
+	vectorField := "embeddings"
+	metricType := entity.L2
+
+	searchParam, _ := entity.NewIndexFlatSearchParam()
+
+	options := []milvus.SearchQueryOptionFunc{}
+
+	searchResult, err := s.milvusClient.Search(ctx, s.collection, partitions, expr, outputFields, vectors, vectorField, metricType, topK, searchParam, options...)
+
+	if err != nil {
+		fmt.Println("Error searching in Milvus client: ", err)
+		return nil, err
+	}
+	if len(searchResult) == 0 || searchResult[0].ResultCount == 0 {
+		return nil, nil
+	}
+
+	ids, err := int64sFromColumn(searchResult[0].IDs)
+	if err != nil {
+		return nil, err
+	}
+	records := s.recordsFromResult(ids, searchResult[0].Fields)
+
+	results := make([]SearchResult, 0, len(records))
+	for i, record := range records {
+		result := SearchResult{Record: record}
+		if i < len(searchResult[0].Scores) {
+			// The collection is indexed by squared L2 distance. Stored and query vectors are
+			// normalized, and for unit vectors the cosine similarity is 1 - d/2.
+			result.Score = 1 - searchResult[0].Scores[i]/2
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (s *milvusStore) Delete(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	err := s.milvusClient.DeleteByPks(ctx, s.collection, "", entity.NewColumnInt64("memory_id", ids))
+	if err != nil {
+		fmt.Println("Error deleting from Milvus client: ", err)
+	}
+	return err
+}
+
+// List reads every matching row and pages through them in ID order, since Milvus does not sort query results.
+func (s *milvusStore) List(ctx context.Context, filter Filter, offset, limit int) ([]Record, error) {
+	expr := "memory_id > 0"
+	if condition := filterExpr(filter); condition != "" {
+		expr += " && " + condition
+	}
+
+	result, err := s.milvusClient.Query(ctx, s.collection, []string{}, expr, milvusOutputFields,
+		milvus.WithLimit(milvusMaxQueryResults),
+		milvus.WithSearchQueryConsistencyLevel(entity.ClStrong))
+	if err != nil {
+		fmt.Println("Error querying Milvus client: ", err)
+		return nil, err
+	}
+
+	ids, err := int64sFromColumn(result.GetColumn("memory_id"))
+	if err != nil {
+		return nil, err
+	}
+	records := s.recordsFromResult(ids, result)
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+
+	return page(records, offset, limit), nil
+}
+
+// Update inserts a new row and then deletes the old one, because the collection assigns its own
+// IDs. Inserting first means a failed update never loses the memory: if the old row cannot be
+// deleted, the new one is removed again and the old one stays.
+func (s *milvusStore) Update(ctx context.Context, record Record) (int64, error) {
+	ids, err := s.Insert(ctx, []Record{record})
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, fmt.Errorf("no id returned for the updated memory")
+	}
+	if err := s.Delete(ctx, []int64{record.ID}); err != nil {
+		if rollbackErr := s.Delete(ctx, ids); rollbackErr != nil {
+			return 0, fmt.Errorf("%v, and the new row %d could not be removed: %v", err, ids[0], rollbackErr)
+		}
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+func (s *milvusStore) Close() error {
+	return s.milvusClient.Close()
+}
+
+func (s *milvusStore) getStringSliceFromColumn(column entity.Column) []string {
+	if column == nil {
+		return nil
+	}
+	length := column.Len()
+	results := make([]string, length)
+
+	for i := 0; i < length; i++ {
+		val, err := column.GetAsString(i)
+		if err != nil {
+			// handle error or continue with a placeholder value
+			fmt.Println("Error getting string from column: ", err)
+			results[i] = "" // or some placeholder value
+		} else {
+			results[i] = val
+		}
+	}
+
+	return results
+}
+
+// int64sFromColumn reads the primary keys returned by Milvus.
+func int64sFromColumn(column entity.Column) ([]int64, error) {
+	ids, ok := column.(*entity.ColumnInt64)
+	if !ok {
+		return nil, fmt.Errorf("unexpected id column type %T", column)
+	}
+	return ids.Data(), nil
+}
+
+// recordsFromResult builds the records with the given IDs from the scalar columns of a query or search.
+func (s *milvusStore) recordsFromResult(ids []int64, result milvus.ResultSet) []Record {
+	types := s.getStringSliceFromColumn(result.GetColumn("type"))
+	details := s.getStringSliceFromColumn(result.GetColumn("detail"))
+	texts := s.getStringSliceFromColumn(result.GetColumn("text"))
+	sessions := s.getStringSliceFromColumn(result.GetColumn("session_id"))
+	created, _ := int64sFromColumn(result.GetColumn("created_at"))
+	updated, _ := int64sFromColumn(result.GetColumn("updated_at"))
+	importance, _ := int64sFromColumn(result.GetColumn("importance"))
+	history := s.getStringSliceFromColumn(result.GetColumn("history"))
+
+	records := make([]Record, len(ids))
+	for i, id := range ids {
+		records[i] = Record{
+			ID:         id,
+			Type:       stringAt(types, i),
+			Detail:     stringAt(details, i),
+			Text:       stringAt(texts, i),
+			CreatedAt:  unixTime(int64At(created, i)),
+			UpdatedAt:  unixTime(int64At(updated, i)),
+			SessionID:  stringAt(sessions, i),
+			Importance: int(int64At(importance, i)),
+			History:    decodeHistory(stringAt(history, i)),
+		}
+	}
+	return records
+}
+
+// encodeHistory stores the previous values of a memory as a JSON array, empty when there are none.
+func encodeHistory(history []Revision) (string, error) {
+	if len(history) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(history)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeHistory is the reverse of encodeHistory. A history that cannot be read is dropped.
+func decodeHistory(data string) []Revision {
+	if data == "" {
+		return nil
+	}
+	var history []Revision
+	if err := json.Unmarshal([]byte(data), &history); err != nil {
+		fmt.Println("Error reading memory history: ", err)
+		return nil
+	}
+	return history
+}
+
+func stringAt(values []string, i int) string {
+	if i < len(values) {
+		return values[i]
+	}
+	return ""
+}
+
+func int64At(values []int64, i int) int64 {
+	if i < len(values) {
+		return values[i]
+	}
+	return 0
+}
+
+// unixSeconds stores a time as Unix seconds, with 0 for an unknown time.
+func unixSeconds(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// unixTime is the reverse of unixSeconds.
+func unixTime(seconds int64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// filterExpr expresses filter as a Milvus boolean expression, empty when it matches everything.
+func filterExpr(filter Filter) string {
+	var conditions []string
+	if len(filter.IDs) > 0 {
+		ids := make([]string, len(filter.IDs))
+		for i, id := range filter.IDs {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		conditions = append(conditions, "memory_id in ["+strings.Join(ids, ", ")+"]")
+	}
+	if filter.Type != "" {
+		conditions = append(conditions, "type == "+strconv.Quote(filter.Type))
+	}
+	if filter.Detail != "" {
+		conditions = append(conditions, "detail == "+strconv.Quote(filter.Detail))
+	}
+	if filter.SessionID != "" {
+		conditions = append(conditions, "session_id == "+strconv.Quote(filter.SessionID))
+	}
+	if filter.MinImportance > 0 {
+		conditions = append(conditions, fmt.Sprintf("importance >= %d", filter.MinImportance))
+	}
+	if !filter.UpdatedSince.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("updated_at >= %d", filter.UpdatedSince.Unix()))
+	}
+	return strings.Join(conditions, " && ")
+}
+
+func (s *milvusStore) initMilvusSchema() error {
+
+	//check if schema exists
+
+	exists, _ := s.milvusClient.HasCollection(context.Background(), s.collection)
+	if !exists {
+		if err := s.createCollection(); err != nil {
+			return err
+		}
+	} else {
+		collection, err := s.milvusClient.DescribeCollection(context.Background(), s.collection)
+		if err != nil {
+			fmt.Println("Error describing collection in Milvus client: ", err)
+			return err
+		}
+		if !hasField(collection.Schema, "history") {
+			if err := s.migrateCollection(collection.Schema); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.loadCollection()
+}
+
+func (s *milvusStore) createCollection() error {
+	schema := &entity.Schema{
+		CollectionName: s.collection,
+		Description:    "Clara's long term memory",
+		Fields: []*entity.Field{
+			{
+				Name:       "memory_id",
+				DataType:   entity.FieldTypeInt64,
+				PrimaryKey: true,
+				AutoID:     true,
+			},
+			varCharField("type", 1024),
+			varCharField("detail", 1024),
+			varCharField("text", 65535),
+			{Name: "created_at", DataType: entity.FieldTypeInt64},
+			{Name: "updated_at", DataType: entity.FieldTypeInt64},
+			varCharField("session_id", 128),
+			{Name: "importance", DataType: entity.FieldTypeInt64},
+			varCharField("history", 65535),
+			{
+				Name:     "embeddings",
+				DataType: entity.FieldTypeFloatVector,
+				TypeParams: map[string]string{
+					entity.TypeParamDim: strconv.Itoa(s.dimensions),
+				},
+			},
+		},
+	}
+	err := s.milvusClient.CreateCollection(context.Background(), schema, 1)
+	if err != nil {
+		fmt.Println("Error creating collection in Milvus client: ", err)
+		return err
+	}
+
+	idx, err := entity.NewIndexIvfFlat(entity.L2, 2)
+
+	if err != nil {
+		fmt.Println("Error creating index in Milvus client: ", err)
+		return err
+	}
+
+	err = s.milvusClient.CreateIndex(context.Background(), s.collection, "embeddings", idx, false)
+
+	if err != nil {
+		fmt.Println("Error creating index in Milvus client: ", err)
+		return err
+	}
+
+	return nil
+}
+
+func varCharField(name string, maxLength int) *entity.Field {
+	return &entity.Field{
+		Name:     name,
+		DataType: entity.FieldTypeVarChar,
+		TypeParams: map[string]string{
+			entity.TypeParamMaxLength: strconv.Itoa(maxLength),
+		},
+	}
+}
+
+func hasField(schema *entity.Schema, name string) bool {
+	for _, field := range schema.Fields {
+		if field.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *milvusStore) loadCollection() error {
+	//check to see if the collection is loaded
+	loaded, err := s.milvusClient.GetLoadState(context.Background(), s.collection, []string{})
+
+	if err != nil {
+		fmt.Println("Error getting load state from Milvus client: ", err)
+		return err
+	}
+
+	if loaded == entity.LoadStateNotLoad {
+		err = s.milvusClient.LoadCollection(context.Background(), s.collection, false)
+		if err != nil {
+			fmt.Println("Error loading collection from Milvus client: ", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateCollection converts a collection created by an older version to the current schema:
+// one that keeps each memory as a single "type|detail|memory" string, or one without history.
+// Milvus cannot add fields to a collection, so the rows are read, written to a backup file, and
+// inserted again into a recreated collection. Their vectors are kept, since they were computed
+// from the same text.
+func (s *milvusStore) migrateCollection(schema *entity.Schema) error {
+	legacy := hasField(schema, "memory")
+	if !legacy && !hasField(schema, "text") || !hasField(schema, "embeddings") {
+		return fmt.Errorf("collection %s has an unknown schema, use another milvus.collection", s.collection)
+	}
+	if err := s.loadCollection(); err != nil {
+		return err
+	}
+
+	outputFields := []string{"memory_id", "memory", "embeddings"}
+	if !legacy {
+		outputFields = []string{"embeddings"}
+		for _, name := range milvusOutputFields {
+			if hasField(schema, name) {
+				outputFields = append(outputFields, name)
+			}
+		}
+	}
+
+	ctx := context.Background()
+	result, err := s.milvusClient.Query(ctx, s.collection, []string{}, "memory_id > 0", outputFields,
+		milvus.WithLimit(milvusMaxQueryResults),
+		milvus.WithSearchQueryConsistencyLevel(entity.ClStrong))
+	if err != nil {
+		fmt.Println("Error querying Milvus client: ", err)
+		return err
+	}
+
+	ids, err := int64sFromColumn(result.GetColumn("memory_id"))
+	if err != nil {
+		return err
+	}
+	if len(ids) >= milvusMaxQueryResults {
+		return fmt.Errorf("collection %s has too many memories to migrate at once", s.collection)
+	}
+	memoryFields := s.getStringSliceFromColumn(result.GetColumn("memory"))
+	vectorColumn, ok := result.GetColumn("embeddings").(*entity.ColumnFloatVector)
+	if !ok {
+		return fmt.Errorf("unexpected embeddings column type %T", result.GetColumn("embeddings"))
+	}
+	if vectorColumn.Dim() != s.dimensions {
+		return fmt.Errorf("collection %s holds %d dimensional vectors but plugins.memory.dimensions is %d", s.collection, vectorColumn.Dim(), s.dimensions)
+	}
+	vectors := vectorColumn.Data()
+
+	var records []Record
+	if legacy {
+		for i, id := range ids {
+			if i < len(memoryFields) && i < len(vectors) {
+				records = append(records, legacyRecord(id, memoryFields[i], vectors[i]))
+			}
+		}
+	} else {
+		for i, record := range s.recordsFromResult(ids, result) {
+			if i < len(vectors) {
+				record.Vector = vectors[i]
+				records = append(records, record)
+			}
+		}
+	}
+
+	backup := fmt.Sprintf("%s-legacy-%s.jsonl", s.collection, time.Now().Format("20060102150405"))
+	if err := writeBackup(backup, records); err != nil {
+		return fmt.Errorf("error backing up collection %s before migrating it: %v", s.collection, err)
+	}
+
+	if err := s.milvusClient.DropCollection(ctx, s.collection); err != nil {
+		fmt.Println("Error dropping collection in Milvus client: ", err)
+		return err
+	}
+	if err := s.createCollection(); err != nil {
+		return fmt.Errorf("error recreating collection %s, its memories are saved in %s: %v", s.collection, backup, err)
+	}
+	for start := 0; start < len(records); start += milvusInsertBatch {
+		end := start + milvusInsertBatch
+		if end > len(records) {
+			end = len(records)
+		}
+		if _, err := s.Insert(ctx, records[start:end]); err != nil {
+			return fmt.Errorf("error migrating collection %s, its memories are saved in %s: %v", s.collection, backup, err)
+		}
+	}
+	if err := s.milvusClient.Flush(ctx, s.collection, false); err != nil {
+		fmt.Println("Error flushing Milvus client: ", err)
+		return err
+	}
+
+	fmt.Printf("Migrated %d memories in %s to the current schema, a backup is in %s\n", len(records), s.collection, backup)
+	return nil
+}
+
+// writeBackup saves records as JSON Lines, one record per line.
+func writeBackup(path string, records []Record) error {
+	var buf strings.Builder
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return os.WriteFile(path, []byte(buf.String()), 0o644)
+}
@@ -1,438 +1,825 @@
-package main
-
-import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"strings"
-
-	milvus "github.com/milvus-io/milvus-sdk-go/v2/client"
-	"github.com/milvus-io/milvus-sdk-go/v2/entity"
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
-	"github.com/wangergou2023/clara/config"
-	"github.com/wangergou2023/clara/plugins"
-)
-
-var Plugin plugins.Plugin = &Memory{}
-
-type Memory struct {
-	cfg          config.Cfg
-	milvusClient milvus.Client
-	openaiClient *openai.Client
-}
-
-type memory struct {
-	Memory string
-	Vector []float32
-}
-
-type memoryResult struct {
-	Memory string
-	Type   string
-	Detail string
-	Score  float32
-}
-
-type memoryItem struct {
-	Memory string `json:"memory"`
-	Type   string `json:"type"`
-	Detail string `json:"detail"`
-}
-
-type inputDefinition struct {
-	RequestType  string       `json:"requestType"`
-	Memories     []memoryItem `json:"memories"`
-	Num_relevant int          `json:"num_relevant"`
-}
-
-func (c *Memory) Init(cfg config.Cfg, openaiClient *openai.Client) error {
-	c.cfg = cfg
-	c.openaiClient = openaiClient
-
-	ctx := context.Background()
-
-	c.milvusClient, _ = milvus.NewGrpcClient(ctx, c.cfg.MalvusApiEndpoint())
-
-	err := c.initMilvusSchema()
-
-	if err != nil {
-		fmt.Println("Error initializing Milvus schema: ", err)
-		return err
-	}
-
-	fmt.Println("Memory plugin initialized successfully")
-	return nil
-}
-
-func (c Memory) ID() string {
-	return "memory"
-}
-
-func (c Memory) Description() string {
-	return "store and retrieve memories from long term memory."
-}
-
-func (c Memory) FunctionDefinition() openai.FunctionDefinition {
-	return openai.FunctionDefinition{
-		Name:        "memory",
-		Description: "从长期记忆中存储和检索记忆。使用requestType 'set'向数据库添加记忆，使用requestType 'get'检索最相关的记忆。首次启动时，你应该使用'hydrate'功能回顾用户的过往记忆。",
-		Parameters: jsonschema.Definition{
-			Type: jsonschema.Object,
-			Properties: map[string]jsonschema.Definition{
-				"requestType": {
-					Type:        jsonschema.String,
-					Description: "要进行的请求类型 'set'，'get' 或 'hydrate'。'set' 将记忆添加到数据库中，'get' 将返回最相关的记忆。获取记忆时，你应该总是包含记忆字段。'hydrate'将返回包含用户所有记忆的提示。",
-				},
-				"memories": {
-					Type: jsonschema.Array,
-					Items: &jsonschema.Definition{
-						Type: jsonschema.Object,
-						Properties: map[string]jsonschema.Definition{
-							"memory": {
-								Type:        jsonschema.String,
-								Description: "要添加的个别记忆。你应该提供尽可能多的上下文来配合记忆。",
-							},
-							"type": {
-								Type:        jsonschema.String,
-								Description: "记忆的类型，例如：'personality', 'food'等。",
-							},
-							"detail": {
-								Type:        jsonschema.String,
-								Description: "关于类型的具体细节，例如：'喜欢披萨'，'是风情万种的'等。",
-							},
-						},
-						Required: []string{"memory", "type", "detail"},
-					},
-					Description: "要添加或获取的记忆数组。每个记忆包含其个别内容、类型和细节。这对于'set'和'get'请求都是必需的。",
-				},
-				"num_relevant": {
-					Type:        jsonschema.Integer,
-					Description: "要返回的相关记忆的数量，例如：5。",
-				},
-			},
-			Required: []string{"requestType"},
-		},
-	}
-}
-
-func (c Memory) Execute(jsonInput string) (string, error) {
-	// marshal jsonInput to inputDefinition
-	var args inputDefinition
-	err := json.Unmarshal([]byte(jsonInput), &args)
-	if err != nil {
-		fmt.Println("Error unmarshalling JSON input: ", err)
-		return "", err
-	}
-
-	if args.Num_relevant == 0 {
-		args.Num_relevant = 5
-	}
-
-	// Check if memories slice is empty
-	if args.RequestType != "hydrate" && len(args.Memories) == 0 {
-		return fmt.Sprintf(`%v`, "memories are required but was empty"), nil
-	}
-
-	switch args.RequestType {
-	case "set":
-		// Iterate over all memories and set them
-		for _, memory := range args.Memories {
-			ok, err := c.setMemory(memory.Memory, memory.Type, memory.Detail)
-			if err != nil {
-				fmt.Println("Error setting memory: ", err)
-				return fmt.Sprintf(`%v`, err), err
-			}
-			if !ok {
-				return "Failed to set a memory", nil
-			}
-		}
-		fmt.Println("Memories set successfully")
-		return "Memories set successfully", nil
-
-	case "get":
-		// Note: This assumes that for 'get', you'll retrieve memories based on the first item in the memories slice. Adjust as needed.
-		memoryResponse, err := c.getMemory(args.Memories[0], args.Num_relevant)
-		if err != nil {
-			fmt.Println("Error getting memory: ", err)
-			return fmt.Sprintf(`%v`, err), err
-		}
-		fmt.Println("Memories get successfully")
-		return fmt.Sprintf(`%v`, memoryResponse), nil
-	case "hydrate":
-		prompt, err := c.HydrateUserMemories()
-		if err != nil {
-			fmt.Println("Error hydrating user memories: ", err)
-			return fmt.Sprintf(`%v`, err), err
-		}
-		fmt.Println("Memories hydrate successfully")
-		return prompt, nil
-	default:
-		return "unknown request type check out Example for how to use the memory plug", nil
-	}
-}
-
-func (c Memory) getEmbeddingsFromOpenAI(data string) openai.Embedding {
-	embeddings, err := c.openaiClient.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{
-		Input: []string{data},
-		Model: openai.AdaEmbeddingV2,
-	})
-	if err != nil {
-		fmt.Println("Error getting embeddings from OpenAI: ", err)
-		fmt.Println(err)
-	}
-
-	return embeddings.Data[0]
-}
-
-func (c Memory) setMemory(newMemory, memoryType, memoryDetail string) (bool, error) {
-	// Step 1: Combine the three fields into a single string
-	combinedMemory := memoryType + "|" + memoryDetail + "|" + newMemory
-
-	embeddings := c.getEmbeddingsFromOpenAI(combinedMemory)
-
-	longTermMemory := memory{
-		Memory: combinedMemory, // Use combinedMemory here
-		Vector: embeddings.Embedding,
-	}
-
-	memories := []memory{
-		longTermMemory,
-	}
-
-	memoryData := make([]string, 0, len(memories))
-	vectors := make([][]float32, 0, len(memories))
-
-	for _, memory := range memories {
-		memoryData = append(memoryData, memory.Memory)
-		vectors = append(vectors, memory.Vector)
-	}
-
-	memoryColumn := entity.NewColumnVarChar("memory", memoryData)
-	vectorColumn := entity.NewColumnFloatVector("embeddings", 1536, vectors)
-
-	_, err := c.milvusClient.Insert(context.Background(), c.cfg.MalvusCollectionName(), "", memoryColumn, vectorColumn)
-
-	if err != nil {
-		fmt.Println("Error inserting into Milvus client: ", err)
-		return false, err
-	}
-
-	return true, nil
-}
-
-func (c Memory) getMemory(memory memoryItem, num_relevant int) ([]memoryResult, error) {
-	combinedMemory := memory.Type + "|" + memory.Detail + "|" + memory.Memory + ","
-	embeddings := c.getEmbeddingsFromOpenAI(combinedMemory)
-
-	ctx := context.Background()
-	partitions := []string{}
-	expr := ""
-	outputFields := []string{"memory"}
-	vectors := []entity.Vector{entity.FloatVector(embeddings.Embedding)}
-	vectorField := "embeddings"
-	metricType := entity.L2
-	topK := num_relevant
-
-	searchParam, _ := entity.NewIndexFlatSearchParam()
-
-	options := []milvus.SearchQueryOptionFunc{}
-
-	searchResult, err := c.milvusClient.Search(ctx, c.cfg.MalvusCollectionName(), partitions, expr, outputFields, vectors, vectorField, metricType, topK, searchParam, options...)
-
-	if err != nil {
-		fmt.Println("Error searching in Milvus client: ", err)
-		return nil, err
-	}
-
-	memoryFields := c.getStringSliceFromColumn(searchResult[0].Fields.GetColumn("memory"))
-
-	var allMemories []string
-	if len(memoryFields) == 1 {
-		allMemories = strings.Split(memoryFields[0], ",")
-	} else {
-		allMemories = memoryFields
-	}
-
-	memoryResults := make([]memoryResult, len(allMemories))
-
-	for idx, memory := range allMemories {
-		parts := strings.Split(memory, "|")
-
-		if len(parts) >= 3 {
-			memoryResults[idx] = memoryResult{
-				Type:   strings.TrimSpace(parts[0]),
-				Detail: strings.TrimSpace(parts[1]),
-				Memory: strings.TrimSpace(parts[2]),
-			}
-		}
-	}
-
-	return memoryResults, nil
-}
-
-func (c Memory) getStringSliceFromColumn(column entity.Column) []string {
-	length := column.Len()
-	results := make([]string, length)
-
-	for i := 0; i < length; i++ {
-		val, err := column.GetAsString(i)
-		if err != nil {
-			// handle error or continue with a placeholder value
-			fmt.Println("Error getting string from column: ", err)
-			results[i] = "" // or some placeholder value
-		} else {
-			results[i] = val
-		}
-	}
-
-	return results
-}
-
-func (c Memory) initMilvusSchema() error {
-
-	//check if schema exists
-
-	if exists, _ := c.milvusClient.HasCollection(context.Background(), c.cfg.MalvusCollectionName()); !exists {
-		schema := &entity.Schema{
-			CollectionName: c.cfg.MalvusCollectionName(),
-			Description:    "Clara's long term memory",
-			Fields: []*entity.Field{
-				{
-					Name:       "memory_id",
-					DataType:   entity.FieldTypeInt64,
-					PrimaryKey: true,
-					AutoID:     true,
-				},
-				{
-					Name:     "memory",
-					DataType: entity.FieldTypeVarChar,
-					TypeParams: map[string]string{
-						entity.TypeParamMaxLength: "65535",
-					},
-				},
-				{
-					Name:     "embeddings",
-					DataType: entity.FieldTypeFloatVector,
-					TypeParams: map[string]string{
-						entity.TypeParamDim: "1536",
-					},
-				},
-			},
-		}
-		err := c.milvusClient.CreateCollection(context.Background(), schema, 1)
-		if err != nil {
-			fmt.Println("Error creating collection in Milvus client: ", err)
-			return err
-		}
-
-		idx, err := entity.NewIndexIvfFlat(entity.L2, 2)
-
-		if err != nil {
-			fmt.Println("Error creating index in Milvus client: ", err)
-			return err
-		}
-
-		err = c.milvusClient.CreateIndex(context.Background(), c.cfg.MalvusCollectionName(), "embeddings", idx, false)
-
-		if err != nil {
-			fmt.Println("Error creating index in Milvus client: ", err)
-			return err
-		}
-
-	}
-
-	//check to see if the collection is loaded
-	loaded, err := c.milvusClient.GetLoadState(context.Background(), c.cfg.MalvusCollectionName(), []string{})
-
-	if err != nil {
-		fmt.Println("Error getting load state from Milvus client: ", err)
-		return err
-	}
-
-	if loaded == entity.LoadStateNotLoad {
-		err = c.milvusClient.LoadCollection(context.Background(), c.cfg.MalvusCollectionName(), false)
-		if err != nil {
-			fmt.Println("Error loading collection from Milvus client: ", err)
-			return err
-		}
-	}
-
-	return nil
-}
-
-func (c *Memory) HydrateUserMemories() (string, error) {
-
-	var memories = []memoryItem{
-		{Type: "Basic Personal Information", Detail: "name"},
-		{Type: "Basic Personal Information", Detail: "age"},
-		{Type: "Basic Personal Information", Detail: "gender"},
-		{Type: "Basic Personal Information", Detail: "location"},
-
-		{Type: "Preferences", Detail: "music_preference"},
-		{Type: "Preferences", Detail: "movie_preference"},
-		{Type: "Preferences", Detail: "book_preference"},
-		{Type: "Preferences", Detail: "food_preference"},
-
-		{Type: "Professional and Educational Background", Detail: "profession"},
-		{Type: "Professional and Educational Background", Detail: "education"},
-		{Type: "Professional and Educational Background", Detail: "skills"},
-
-		{Type: "Hobbies and Interests", Detail: "hobbies"},
-		{Type: "Hobbies and Interests", Detail: "sports"},
-		{Type: "Hobbies and Interests", Detail: "travel"},
-		{Type: "Hobbies and Interests", Detail: "games"},
-
-		{Type: "Lifestyle and Habits", Detail: "exercise_habit"},
-		{Type: "Lifestyle and Habits", Detail: "reading_habit"},
-		{Type: "Lifestyle and Habits", Detail: "diet"},
-		{Type: "Lifestyle and Habits", Detail: "pets"},
-
-		{Type: "Tech and Media Consumption", Detail: "favorite_apps"},
-		{Type: "Tech and Media Consumption", Detail: "device_preference"},
-		{Type: "Tech and Media Consumption", Detail: "news_source"},
-
-		{Type: "Social and Personal Relationships", Detail: "family"},
-		{Type: "Social and Personal Relationships", Detail: "friends"},
-		{Type: "Social and Personal Relationships", Detail: "relationship_status"},
-
-		{Type: "Past Interactions", Detail: "past_questions"},
-		{Type: "Past Interactions", Detail: "feedback"},
-		{Type: "Past Interactions", Detail: "topics_of_interest"},
-
-		{Type: "Moods and Feelings", Detail: "current_mood"},
-		{Type: "Moods and Feelings", Detail: "life_events"},
-		{Type: "Moods and Feelings", Detail: "challenges"},
-
-		{Type: "Custom User Data", Detail: "custom_data"},
-	}
-
-	uniqueMemories := make(map[string]bool)
-	var memoryList []string
-
-	prompt := "你是一个名叫Clara的AI助手，你拥有长期记忆，以下是一些关于用户的记忆，你可以使用："
-
-	for _, m := range memories {
-		// Get each memory from the vector database based on user ID and memory type
-		results, err := c.getMemory(m, 5)
-		if err != nil {
-			return "", err
-		}
-
-		for _, res := range results {
-			// Remove the trailing comma from each memory if it exists
-			cleanMemory := strings.TrimSuffix(res.Memory, ",")
-			if _, exists := uniqueMemories[cleanMemory]; !exists {
-				uniqueMemories[cleanMemory] = true
-				memoryList = append(memoryList, cleanMemory)
-			}
-		}
-	}
-
-	// Convert unique memories into a CSV list
-	memoriesCSV := strings.Join(memoryList, ", ")
-
-	prompt += memoriesCSV
-
-	return prompt, nil
-}
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/wangergou2023/clara/config"
+	"github.com/wangergou2023/clara/llm"
+	"github.com/wangergou2023/clara/plugins"
+)
+
+func init() {
+	plugins.Register(&Memory{})
+}
+
+// defaultDimensions is the size of the vectors returned by text-embedding-ada-002.
+const defaultDimensions = 1536
+
+// defaultForgetThreshold is how similar a memory must be to the query for forget to remove it.
+const defaultForgetThreshold = 0.85
+
+// forgetCandidates is the most memories a single forget can remove.
+const forgetCandidates = 100
+
+// Defaults for how set treats memories close to ones it already has. A memory at least
+// duplicateThreshold similar to another is the same memory, one at least conflictThreshold
+// similar to another with the same type and detail is a new value for it.
+const (
+	defaultDuplicateThreshold = 0.95
+	defaultConflictThreshold  = 0.8
+)
+
+// maxHistory is how many earlier values a memory keeps.
+const maxHistory = 10
+
+// setCheckTimeout bounds the search CallCapabilities runs to find out whether a set replaces a memory.
+const setCheckTimeout = 30 * time.Second
+
+type Memory struct {
+	cfg        config.Cfg
+	store      MemoryStore
+	provider   llm.Provider
+	dimensions int // size of the embedding vectors, fixed when the store is created
+	pending    *pendingDeletion
+	probes     *probeCache
+
+	hydrateTokens int // size of the hydrate prompt
+
+	duplicateThreshold float32
+	conflictThreshold  float32
+}
+
+// pendingDeletion holds the memories picked by /memory delete or /memory forget
+// until the user confirms them with /memory confirm.
+type pendingDeletion struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+// memoryResult is a memory as returned to the model by get.
+type memoryResult struct {
+	ID         int64    `json:"id"`
+	Type       string   `json:"type"`
+	Detail     string   `json:"detail"`
+	Memory     string   `json:"memory"`
+	Importance int      `json:"importance"`
+	CreatedAt  string   `json:"created_at,omitempty"`
+	UpdatedAt  string   `json:"updated_at,omitempty"`
+	SessionID  string   `json:"session_id,omitempty"`
+	Previous   []string `json:"previous,omitempty"` // earlier values, oldest first
+	Score      float32  `json:"score"`
+}
+
+type memoryItem struct {
+	ID         int64  `json:"id,omitempty"` // the memory to change, for update
+	Memory     string `json:"memory"`
+	Type       string `json:"type"`
+	Detail     string `json:"detail"`
+	Importance int    `json:"importance,omitempty"`
+}
+
+// filterInput narrows a get request down to memories with matching fields.
+type filterInput struct {
+	Type          string `json:"type"`
+	Detail        string `json:"detail"`
+	SessionID     string `json:"session_id"`
+	MinImportance int    `json:"min_importance"`
+	UpdatedSince  string `json:"updated_since"`
+}
+
+type inputDefinition struct {
+	RequestType  string       `json:"requestType"`
+	Memories     []memoryItem `json:"memories"`
+	IDs          []int64      `json:"ids"`
+	Num_relevant int          `json:"num_relevant"`
+	Threshold    float32      `json:"threshold"`
+	Filter       filterInput  `json:"filter"`
+}
+
+func (c *Memory) Init(cfg config.Cfg, provider llm.Provider) error {
+	c.cfg = cfg
+	c.provider = provider
+
+	// Local embedding models produce vectors of a different size, e.g. 768 for nomic-embed-text.
+	c.dimensions = defaultDimensions
+	if v, ok := cfg.Lookup("plugins.memory.dimensions"); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 1 {
+			return fmt.Errorf("plugins.memory.dimensions %q should be a positive integer", v)
+		}
+		c.dimensions = n
+	}
+
+	c.hydrateTokens = defaultHydrateTokens
+	if v, ok := cfg.Lookup("plugins.memory.hydrate_tokens"); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 1 {
+			return fmt.Errorf("plugins.memory.hydrate_tokens %q should be a positive integer", v)
+		}
+		c.hydrateTokens = n
+	}
+
+	var err error
+	if c.duplicateThreshold, err = similarityOption(cfg, "plugins.memory.duplicate_threshold", defaultDuplicateThreshold); err != nil {
+		return err
+	}
+	if c.conflictThreshold, err = similarityOption(cfg, "plugins.memory.conflict_threshold", defaultConflictThreshold); err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg, c.dimensions)
+	if err != nil {
+		return err
+	}
+	c.store = store
+	c.pending = &pendingDeletion{}
+	c.probes = &probeCache{}
+
+	fmt.Println("Memory plugin initialized successfully")
+	return nil
+}
+
+func (c Memory) ID() string {
+	return "memory"
+}
+
+func (c Memory) Description() string {
+	return "store and retrieve memories from long term memory."
+}
+
+// Capabilities reports that the plugin talks to the embeddings API and Milvus over the network,
+// and can delete memories. The local store only writes its own file, so it does not count as filesystem-write.
+func (c Memory) Capabilities() []string {
+	return []string{plugins.CapabilityNetwork, plugins.CapabilityDestructive}
+}
+
+// CallCapabilities marks update, delete and forget as destructive, so the permission policy
+// asks the user before the model changes or removes memories. A set is destructive too when one
+// of its memories would be merged into or supersede a stored one, or when that cannot be checked.
+// Other requests only use the network.
+func (c Memory) CallCapabilities(jsonInput string) []string {
+	var args inputDefinition
+	if err := json.Unmarshal([]byte(jsonInput), &args); err != nil {
+		return nil
+	}
+	destructive := []string{plugins.CapabilityNetwork, plugins.CapabilityDestructive}
+	switch args.RequestType {
+	case "update", "delete", "forget":
+		return destructive
+	case "set":
+		ctx, cancel := context.WithTimeout(context.Background(), setCheckTimeout)
+		defer cancel()
+		for _, memory := range args.Memories {
+			embedding, err := c.getEmbedding(ctx, embeddingText(memory.Type, memory.Detail, memory.Memory))
+			if err != nil {
+				return destructive
+			}
+			if _, _, found, err := c.existingMemory(ctx, memory, embedding); err != nil || found {
+				return destructive
+			}
+		}
+	}
+	return []string{plugins.CapabilityNetwork}
+}
+
+func (c Memory) FunctionDefinition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{
+		Name:        "memory",
+		Description: "从长期记忆中存储和检索记忆。使用requestType 'set'向数据库添加记忆，使用requestType 'get'检索最相关的记忆。首次启动时，你应该使用'hydrate'功能回顾用户的过往记忆。记忆有误或过时时，用'get'找到它的ID，再用'update'修改或'delete'删除；用户要求忘记某件事时使用'forget'。",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"requestType": {
+					Type:        jsonschema.String,
+					Enum:        []string{"set", "get", "hydrate", "update", "delete", "forget"},
+					Description: "要进行的请求类型 'set'，'get'，'hydrate'，'update'，'delete' 或 'forget'。'set' 将记忆添加到数据库中，'get' 将返回最相关的记忆及其ID。获取记忆时，你应该总是包含记忆字段。'hydrate'将返回包含用户所有记忆的提示。'update'用memories中每项的内容替换ID为id的记忆。'delete'删除ids中的记忆。'forget'删除与memories中第一项的相似度不低于threshold的所有记忆。",
+				},
+				"memories": {
+					Type: jsonschema.Array,
+					Items: &jsonschema.Definition{
+						Type: jsonschema.Object,
+						Properties: map[string]jsonschema.Definition{
+							"id": {
+								Type:        jsonschema.Integer,
+								Description: "要修改的记忆的ID，来自'get'的结果。只用于'update'。",
+							},
+							"memory": {
+								Type:        jsonschema.String,
+								Description: "要添加的个别记忆。你应该提供尽可能多的上下文来配合记忆。",
+							},
+							"type": {
+								Type:        jsonschema.String,
+								Description: "记忆的类型，例如：'personality', 'food'等。",
+							},
+							"detail": {
+								Type:        jsonschema.String,
+								Description: "关于类型的具体细节，例如：'喜欢披萨'，'是风情万种的'等。",
+							},
+							"importance": {
+								Type:        jsonschema.Integer,
+								Description: "记忆的重要程度，1（琐事）到5（必须记住），默认3。只用于'set'。",
+							},
+						},
+						Required: []string{"memory", "type", "detail"},
+					},
+					Description: "要添加或获取的记忆数组。每个记忆包含其个别内容、类型和细节。这对于'set'和'get'请求都是必需的。",
+				},
+				"filter": {
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"type": {
+							Type:        jsonschema.String,
+							Description: "只返回这个类型的记忆。",
+						},
+						"detail": {
+							Type:        jsonschema.String,
+							Description: "只返回这个细节的记忆。",
+						},
+						"session_id": {
+							Type:        jsonschema.String,
+							Description: "只返回在这个会话中记住的记忆。",
+						},
+						"min_importance": {
+							Type:        jsonschema.Integer,
+							Description: "只返回重要程度不低于这个值的记忆。",
+						},
+						"updated_since": {
+							Type:        jsonschema.String,
+							Description: "只返回在这个时间之后更新的记忆，格式为'2006-01-02'或RFC 3339。",
+						},
+					},
+					Description: "'get'请求的可选过滤条件，所有条件同时满足的记忆才会返回。",
+				},
+				"ids": {
+					Type:        jsonschema.Array,
+					Items:       &jsonschema.Definition{Type: jsonschema.Integer},
+					Description: "要删除的记忆的ID，来自'get'的结果。'delete'请求必需。",
+				},
+				"threshold": {
+					Type:        jsonschema.Number,
+					Description: "'forget'请求的相似度阈值，0到1之间，默认0.85。阈值越低删除的记忆越多。",
+				},
+				"num_relevant": {
+					Type:        jsonschema.Integer,
+					Description: "要返回的相关记忆的数量，例如：5。",
+				},
+			},
+			Required: []string{"requestType"},
+		},
+	}
+}
+
+func (c Memory) Execute(jsonInput string) (string, error) {
+	return c.ExecuteContext(context.Background(), jsonInput)
+}
+
+// ExecuteContext passes ctx on to the embedding requests and store calls, so a
+// slow or unreachable Milvus no longer blocks the conversation.
+func (c Memory) ExecuteContext(ctx context.Context, jsonInput string) (string, error) {
+	// marshal jsonInput to inputDefinition
+	var args inputDefinition
+	err := json.Unmarshal([]byte(jsonInput), &args)
+	if err != nil {
+		fmt.Println("Error unmarshalling JSON input: ", err)
+		return "", err
+	}
+
+	if args.Num_relevant == 0 {
+		args.Num_relevant = 5
+	}
+
+	// Check if memories slice is empty
+	if args.RequestType != "hydrate" && args.RequestType != "delete" && len(args.Memories) == 0 {
+		return fmt.Sprintf(`%v`, "memories are required but was empty"), nil
+	}
+
+	switch args.RequestType {
+	case "set":
+		// Iterate over all memories and set them
+		outcomes := []string{"Memories set successfully"}
+		for _, memory := range args.Memories {
+			outcome, err := c.setMemory(ctx, memory)
+			if err != nil {
+				fmt.Println("Error setting memory: ", err)
+				return fmt.Sprintf(`%v`, err), err
+			}
+			outcomes = append(outcomes, "- "+outcome)
+		}
+		fmt.Println("Memories set successfully")
+		return strings.Join(outcomes, "\n"), nil
+
+	case "get":
+		filter, err := args.Filter.filter()
+		if err != nil {
+			return fmt.Sprintf(`%v`, err), nil
+		}
+		// Note: This assumes that for 'get', you'll retrieve memories based on the first item in the memories slice. Adjust as needed.
+		memoryResponse, err := c.getMemory(ctx, args.Memories[0], args.Num_relevant, filter)
+		if err != nil {
+			fmt.Println("Error getting memory: ", err)
+			return fmt.Sprintf(`%v`, err), err
+		}
+		response, err := json.Marshal(memoryResponse)
+		if err != nil {
+			return "", err
+		}
+		fmt.Println("Memories get successfully")
+		return string(response), nil
+	case "hydrate":
+		prompt, err := c.HydrateUserMemories(ctx)
+		if err != nil {
+			fmt.Println("Error hydrating user memories: ", err)
+			return fmt.Sprintf(`%v`, err), err
+		}
+		fmt.Println("Memories hydrate successfully")
+		return prompt, nil
+	case "update":
+		// Check the whole batch first, so a bad item doesn't leave the earlier ones applied
+		// and get them applied twice when the model retries.
+		for _, memory := range args.Memories {
+			if memory.ID == 0 {
+				return "every memory to update needs the id returned by get, nothing was updated", nil
+			}
+		}
+		var updates []string
+		for _, memory := range args.Memories {
+			id, err := c.updateMemory(ctx, memory)
+			if err != nil {
+				fmt.Println("Error updating memory: ", err)
+				if len(updates) > 0 {
+					err = fmt.Errorf("%v (already done: %s)", err, strings.Join(updates, "; "))
+				}
+				return fmt.Sprintf(`%v`, err), err
+			}
+			if id == 0 {
+				updates = append(updates, fmt.Sprintf("memory %d not found", memory.ID))
+			} else if id != memory.ID {
+				updates = append(updates, fmt.Sprintf("memory %d updated, its id is now %d", memory.ID, id))
+			} else {
+				updates = append(updates, fmt.Sprintf("memory %d updated", memory.ID))
+			}
+		}
+		return strings.Join(updates, "\n"), nil
+	case "delete":
+		if len(args.IDs) == 0 {
+			return "ids are required but was empty", nil
+		}
+		records, err := c.deleteMemories(ctx, args.IDs)
+		if err != nil {
+			fmt.Println("Error deleting memories: ", err)
+			return fmt.Sprintf(`%v`, err), err
+		}
+		return deletedResponse(records)
+	case "forget":
+		threshold := args.Threshold
+		if threshold <= 0 || threshold > 1 {
+			threshold = defaultForgetThreshold
+		}
+		records, err := c.similarMemories(ctx, args.Memories[0], threshold)
+		if err != nil {
+			fmt.Println("Error finding memories to forget: ", err)
+			return fmt.Sprintf(`%v`, err), err
+		}
+		records, err = c.deleteMemories(ctx, recordIDs(records))
+		if err != nil {
+			fmt.Println("Error deleting memories: ", err)
+			return fmt.Sprintf(`%v`, err), err
+		}
+		return deletedResponse(records)
+	default:
+		return "unknown request type check out Example for how to use the memory plug", nil
+	}
+}
+
+// Commands contributes the /memory command to the REPL.
+func (c *Memory) Commands() []plugins.Command {
+	return []plugins.Command{
+		{
+			Name:        "memory",
+			Usage:       "/memory search <q> | list [type] | delete <id>... | forget <q> | confirm",
+			Description: "search, list and prune long term memory; delete and forget wait for confirm",
+			Run:         c.runCommand,
+		},
+	}
+}
+
+func (c *Memory) runCommand(args []string) (string, error) {
+	usage := fmt.Errorf("usage: /memory search <q> | list [type] | delete <id>... | forget <q> | confirm")
+	if len(args) == 0 {
+		return "", usage
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "search":
+		if len(args) < 2 {
+			return "", usage
+		}
+		results, err := c.getMemory(ctx, memoryItem{Memory: strings.Join(args[1:], " ")}, 5, Filter{})
+		if err != nil {
+			return "", err
+		}
+		records := make([]Record, 0, len(results))
+		for _, res := range results {
+			records = append(records, Record{ID: res.ID, Type: res.Type, Detail: res.Detail, Text: res.Memory, Importance: res.Importance})
+		}
+		if len(records) == 0 {
+			return "no memories found", nil
+		}
+		return formatRecords(records), nil
+
+	case "list":
+		records, err := c.store.List(ctx, Filter{Type: strings.Join(args[1:], " ")}, 0, -1)
+		if err != nil {
+			return "", err
+		}
+		if len(records) == 0 {
+			return "no memories found", nil
+		}
+		return fmt.Sprintf("%s\n%d memories", formatRecords(records), len(records)), nil
+
+	case "delete":
+		if len(args) < 2 {
+			return "", usage
+		}
+		ids := make([]int64, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			id, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return "", fmt.Errorf("%q is not a memory id", arg)
+			}
+			ids = append(ids, id)
+		}
+		records, err := c.store.List(ctx, Filter{IDs: ids}, 0, -1)
+		if err != nil {
+			return "", err
+		}
+		return c.stageDeletion(records), nil
+
+	case "forget":
+		if len(args) < 2 {
+			return "", usage
+		}
+		records, err := c.similarMemories(ctx, memoryItem{Memory: strings.Join(args[1:], " ")}, defaultForgetThreshold)
+		if err != nil {
+			return "", err
+		}
+		return c.stageDeletion(records), nil
+
+	case "confirm":
+		c.pending.mu.Lock()
+		records := c.pending.records
+		c.pending.records = nil
+		c.pending.mu.Unlock()
+
+		if len(records) == 0 {
+			return "nothing to confirm, use /memory delete or /memory forget first", nil
+		}
+		deleted, err := c.deleteMemories(ctx, recordIDs(records))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("deleted %d memories", len(deleted)), nil
+
+	default:
+		return "", usage
+	}
+}
+
+// stageDeletion shows the memories that /memory confirm will delete.
+func (c *Memory) stageDeletion(records []Record) string {
+	c.pending.mu.Lock()
+	defer c.pending.mu.Unlock()
+
+	c.pending.records = records
+	if len(records) == 0 {
+		return "no matching memories"
+	}
+	return fmt.Sprintf("%s\nrun /memory confirm to delete these %d memories", formatRecords(records), len(records))
+}
+
+// formatRecords lists memories one per line with the IDs /memory delete takes.
+func formatRecords(records []Record) string {
+	lines := make([]string, 0, len(records))
+	for _, record := range records {
+		line := fmt.Sprintf("- #%d [%s / %s] %s (importance %d", record.ID, record.Type, record.Detail, record.Text, record.Importance)
+		if !record.UpdatedAt.IsZero() {
+			line += ", updated " + record.UpdatedAt.Format("2006-01-02")
+		}
+		if len(record.History) > 0 {
+			line += fmt.Sprintf(", was %q", record.History[len(record.History)-1].Text)
+		}
+		lines = append(lines, line+")")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// getEmbedding asks the provider's embedding model for the vector of data.
+func (c Memory) getEmbedding(ctx context.Context, data string) ([]float32, error) {
+	embeddings, err := c.provider.Embeddings(ctx, []string{data})
+	if err != nil {
+		fmt.Println("Error getting embeddings: ", err)
+		return nil, err
+	}
+	if len(embeddings) == 0 {
+		return nil, fmt.Errorf("no embeddings returned")
+	}
+	if len(embeddings[0]) != c.dimensions {
+		return nil, fmt.Errorf("the embedding model returned %d dimensions but the collection expects %d, set plugins.memory.dimensions to match", len(embeddings[0]), c.dimensions)
+	}
+
+	return embeddings[0], nil
+}
+
+// setMemory stores a memory, remembering which session it came from, and describes what it did.
+// A near-duplicate of a stored memory is merged into it, which refreshes its timestamp. A memory
+// with the same type and detail as a similar stored one supersedes it. Either way a stored value
+// that differs from the new one is kept in the memory's history.
+func (c Memory) setMemory(ctx context.Context, memory memoryItem) (string, error) {
+	embedding, err := c.getEmbedding(ctx, embeddingText(memory.Type, memory.Detail, memory.Memory))
+	if err != nil {
+		return "", err
+	}
+
+	existing, duplicate, found, err := c.existingMemory(ctx, memory, embedding)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		id, err := c.revise(ctx, existing, memory, embedding)
+		if err != nil {
+			return "", err
+		}
+		if duplicate {
+			return fmt.Sprintf("merged with memory %d, which is already known", id), nil
+		}
+		return fmt.Sprintf("memory %d updated, it was %q", id, existing.Text), nil
+	}
+
+	now := time.Now()
+	record := Record{
+		Type:       memory.Type,
+		Detail:     memory.Detail,
+		Text:       memory.Memory,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		SessionID:  plugins.SessionID(ctx),
+		Importance: clampImportance(memory.Importance),
+		Vector:     embedding,
+	}
+	ids, err := c.store.Insert(ctx, []Record{record})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("stored as memory %d", ids[0]), nil
+}
+
+// existingMemory finds the stored memory that a new one replaces: a near duplicate of any type,
+// or else a memory with the same type and detail that the new one conflicts with. duplicate
+// tells which of the two was found.
+func (c Memory) existingMemory(ctx context.Context, memory memoryItem, embedding []float32) (existing Record, duplicate bool, found bool, err error) {
+	nearest, err := c.store.Search(ctx, embedding, 1, Filter{})
+	if err != nil {
+		return Record{}, false, false, err
+	}
+	if len(nearest) > 0 && nearest[0].Score >= c.duplicateThreshold {
+		return nearest[0].Record, true, true, nil
+	}
+
+	if memory.Type != "" && memory.Detail != "" {
+		same, err := c.store.Search(ctx, embedding, 1, Filter{Type: memory.Type, Detail: memory.Detail})
+		if err != nil {
+			return Record{}, false, false, err
+		}
+		if len(same) > 0 && same[0].Score >= c.conflictThreshold {
+			return same[0].Record, false, true, nil
+		}
+	}
+	return Record{}, false, false, nil
+}
+
+// revise replaces a stored memory with a newer value of it and returns its ID. The memory keeps
+// its creation time and the higher of the two importances, and the stored text is added to the
+// history unless it is the same as the new one.
+func (c Memory) revise(ctx context.Context, existing Record, memory memoryItem, embedding []float32) (int64, error) {
+	record := existing
+	if existing.Text != memory.Memory {
+		history := append([]Revision(nil), existing.History...) // the store may share the slice
+		record.History = append(history, Revision{Text: existing.Text, UpdatedAt: existing.UpdatedAt, SessionID: existing.SessionID})
+		if len(record.History) > maxHistory {
+			record.History = record.History[len(record.History)-maxHistory:]
+		}
+	}
+	record.Type = memory.Type
+	record.Detail = memory.Detail
+	record.Text = memory.Memory
+	record.UpdatedAt = time.Now()
+	record.SessionID = plugins.SessionID(ctx)
+	if importance := clampImportance(memory.Importance); importance > record.Importance {
+		record.Importance = importance
+	}
+	record.Vector = embedding
+
+	return c.store.Update(ctx, record)
+}
+
+// similarityOption reads a similarity threshold between 0 and 1 from the configuration.
+func similarityOption(cfg config.Cfg, key string, fallback float32) (float32, error) {
+	v, ok := cfg.Lookup(key)
+	if !ok || strings.TrimSpace(v) == "" {
+		return fallback, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 32)
+	if err != nil || f <= 0 || f > 1 {
+		return 0, fmt.Errorf("%s %q should be a number between 0 and 1", key, v)
+	}
+	return float32(f), nil
+}
+
+func (c Memory) getMemory(ctx context.Context, memory memoryItem, num_relevant int, filter Filter) ([]memoryResult, error) {
+	embedding, err := c.getEmbedding(ctx, embeddingText(memory.Type, memory.Detail, memory.Memory))
+	if err != nil {
+		return nil, err
+	}
+
+	searchResults, err := c.store.Search(ctx, embedding, num_relevant, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	memoryResults := make([]memoryResult, 0, len(searchResults))
+	for _, res := range searchResults {
+		memoryResults = append(memoryResults, newMemoryResult(res.Record, res.Score))
+	}
+
+	return memoryResults, nil
+}
+
+// updateMemory replaces the fields of the memory with memory.ID and returns its ID, which
+// changes on stores that cannot update in place, or 0 when there is no such memory.
+// The importance is kept unless a new one is given.
+func (c Memory) updateMemory(ctx context.Context, memory memoryItem) (int64, error) {
+	existing, err := c.store.List(ctx, Filter{IDs: []int64{memory.ID}}, 0, 1)
+	if err != nil {
+		return 0, err
+	}
+	if len(existing) == 0 {
+		return 0, nil
+	}
+
+	record := existing[0]
+	record.Type = memory.Type
+	record.Detail = memory.Detail
+	record.Text = memory.Memory
+	if memory.Importance != 0 {
+		record.Importance = clampImportance(memory.Importance)
+	}
+	record.UpdatedAt = time.Now()
+
+	record.Vector, err = c.getEmbedding(ctx, embeddingText(record.Type, record.Detail, record.Text))
+	if err != nil {
+		return 0, err
+	}
+	return c.store.Update(ctx, record)
+}
+
+// deleteMemories deletes the memories with the given IDs and returns the ones that existed.
+func (c Memory) deleteMemories(ctx context.Context, ids []int64) ([]Record, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	records, err := c.store.List(ctx, Filter{IDs: ids}, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	if err := c.store.Delete(ctx, recordIDs(records)); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// similarMemories returns the memories at least threshold similar to memory.
+func (c Memory) similarMemories(ctx context.Context, memory memoryItem, threshold float32) ([]Record, error) {
+	embedding, err := c.getEmbedding(ctx, embeddingText(memory.Type, memory.Detail, memory.Memory))
+	if err != nil {
+		return nil, err
+	}
+
+	searchResults, err := c.store.Search(ctx, embedding, forgetCandidates, Filter{})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for _, res := range searchResults {
+		if res.Score >= threshold {
+			records = append(records, res.Record)
+		}
+	}
+	return records, nil
+}
+
+func recordIDs(records []Record) []int64 {
+	ids := make([]int64, len(records))
+	for i, record := range records {
+		ids[i] = record.ID
+	}
+	return ids
+}
+
+// deletedResponse tells the model which memories were removed.
+func deletedResponse(records []Record) (string, error) {
+	if len(records) == 0 {
+		return "no matching memories, nothing was deleted", nil
+	}
+	deleted := make([]memoryResult, 0, len(records))
+	for _, record := range records {
+		deleted = append(deleted, newMemoryResult(record, 0))
+	}
+	response, err := json.Marshal(deleted)
+	if err != nil {
+		return "", err
+	}
+	fmt.Println("Memories deleted successfully")
+	return fmt.Sprintf("Deleted %d memories: %s", len(records), response), nil
+}
+
+func newMemoryResult(record Record, score float32) memoryResult {
+	return memoryResult{
+		ID:         record.ID,
+		Type:       record.Type,
+		Detail:     record.Detail,
+		Memory:     record.Text,
+		Importance: record.Importance,
+		CreatedAt:  formatTime(record.CreatedAt),
+		UpdatedAt:  formatTime(record.UpdatedAt),
+		SessionID:  record.SessionID,
+		Previous:   previousValues(record.History),
+		Score:      score,
+	}
+}
+
+func previousValues(history []Revision) []string {
+	values := make([]string, 0, len(history))
+	for _, revision := range history {
+		values = append(values, revision.Text)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// formatTime formats t for the model, empty for memories migrated without a timestamp.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// clampImportance keeps importance within 1 to 5, using the default when it is not given.
+func clampImportance(importance int) int {
+	switch {
+	case importance == 0:
+		return defaultImportance
+	case importance < minImportance:
+		return minImportance
+	case importance > maxImportance:
+		return maxImportance
+	}
+	return importance
+}
+
+// filter converts the filter of a get request, which may give updated_since as a date or an RFC 3339 time.
+func (f filterInput) filter() (Filter, error) {
+	filter := Filter{
+		Type:          strings.TrimSpace(f.Type),
+		Detail:        strings.TrimSpace(f.Detail),
+		SessionID:     strings.TrimSpace(f.SessionID),
+		MinImportance: f.MinImportance,
+	}
+	if since := strings.TrimSpace(f.UpdatedSince); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			t, err = time.ParseInLocation("2006-01-02", since, time.Local)
+		}
+		if err != nil {
+			return Filter{}, fmt.Errorf("filter.updated_since %q should be a date like 2006-01-02 or an RFC 3339 time", since)
+		}
+		filter.UpdatedSince = t
+	}
+	return filter, nil
+}
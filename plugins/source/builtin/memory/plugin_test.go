@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"context"
+	"testing"
+)
+
+func TestExistingMemory(t *testing.T) {
+	ctx := context.Background()
+	s, err := openLocalStore(writeLog(t), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Insert(ctx, []Record{
+		{Type: "food", Detail: "drink", Text: "likes tea", Vector: []float32{1, 0}},
+		{Type: "pets", Detail: "cat", Text: "has a cat", Vector: []float32{0, 1}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c := Memory{store: s, duplicateThreshold: defaultDuplicateThreshold, conflictThreshold: defaultConflictThreshold}
+
+	tests := []struct {
+		name      string
+		memory    memoryItem
+		vector    []float32
+		id        int64
+		duplicate bool
+	}{
+		{
+			name:      "near duplicate of another type",
+			memory:    memoryItem{Type: "hobby", Detail: "tea", Memory: "likes tea"},
+			vector:    []float32{1, 0.01},
+			id:        1,
+			duplicate: true,
+		},
+		{
+			name:   "conflict with the same type and detail",
+			memory: memoryItem{Type: "food", Detail: "drink", Memory: "likes coffee"},
+			vector: []float32{1, 0.6},
+			id:     1,
+		},
+		{
+			name:   "similar but another type and detail",
+			memory: memoryItem{Type: "work", Detail: "job", Memory: "works at a tea shop"},
+			vector: []float32{1, 0.6},
+		},
+		{
+			name:   "unrelated",
+			memory: memoryItem{Type: "food", Detail: "drink", Memory: "has a dog"},
+			vector: []float32{-1, 0.2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing, duplicate, found, err := c.existingMemory(ctx, tt.memory, tt.vector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != (tt.id != 0) || existing.ID != tt.id || duplicate != tt.duplicate {
+				t.Errorf("existingMemory() = memory %d, duplicate %v, found %v; want memory %d, duplicate %v", existing.ID, duplicate, found, tt.id, tt.duplicate)
+			}
+		})
+	}
+}
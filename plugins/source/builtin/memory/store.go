@@ -0,0 +1,183 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/wangergou2023/clara/config"
+)
+
+// Backends for plugins.memory.store.
+const (
+	storeMilvus = "milvus" // a Milvus server, see docker-compose.yml
+	storeLocal  = "local"  // a file on disk, searched in process
+)
+
+// defaultLocalPath is where the local store keeps its memories unless plugins.memory.path is set.
+const defaultLocalPath = "./memories.jsonl"
+
+// Importance of a memory, from trivia to something Clara should never forget.
+const (
+	minImportance     = 1
+	defaultImportance = 3
+	maxImportance     = 5
+)
+
+// Record is a single memory as kept by a MemoryStore.
+type Record struct {
+	ID         int64      `json:"id"`
+	Type       string     `json:"type"`   // category, e.g. "Preferences"
+	Detail     string     `json:"detail"` // what the memory is about within the category, e.g. "food_preference"
+	Text       string     `json:"text"`   // the memory itself
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	SessionID  string     `json:"session_id,omitempty"` // the session the memory was learned in
+	Importance int        `json:"importance"`
+	History    []Revision `json:"history,omitempty"` // earlier values, oldest first
+	Vector     []float32  `json:"vector,omitempty"`
+}
+
+// Revision is an earlier value of a memory that a newer one superseded.
+type Revision struct {
+	Text      string    `json:"text"`
+	UpdatedAt time.Time `json:"updated_at"`
+	SessionID string    `json:"session_id,omitempty"`
+}
+
+// embeddingText is the text a record's vector is computed from. It is the format the memory
+// column used before the fields were split, so migrated vectors stay comparable with new ones.
+func embeddingText(memoryType, detail, text string) string {
+	return memoryType + "|" + detail + "|" + text
+}
+
+// normalize returns vector scaled to unit length, or vector itself if it is all zeros. OpenAI
+// embeddings already have unit length but local models don't guarantee it, and the similarity
+// thresholds of set and forget only mean something for unit vectors.
+func normalize(vector []float32) []float32 {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	unit := make([]float32, len(vector))
+	for i, v := range vector {
+		unit[i] = float32(float64(v) / norm)
+	}
+	return unit
+}
+
+// legacyRecord converts a memory stored as a single "type|detail|memory" string. Only the first
+// two pipes separate fields, so a pipe inside the memory itself survives.
+func legacyRecord(id int64, combined string, vector []float32) Record {
+	record := Record{ID: id, Text: combined, Importance: defaultImportance, Vector: vector}
+	if parts := strings.SplitN(combined, "|", 3); len(parts) == 3 {
+		record.Type = strings.TrimSpace(parts[0])
+		record.Detail = strings.TrimSpace(parts[1])
+		record.Text = strings.TrimSpace(parts[2])
+	}
+	return record
+}
+
+// Filter narrows Search and List down to the records matching all of its non-zero fields.
+type Filter struct {
+	IDs           []int64
+	Type          string
+	Detail        string
+	SessionID     string
+	MinImportance int
+	UpdatedSince  time.Time
+}
+
+// Match reports whether record passes the filter.
+func (f Filter) Match(record Record) bool {
+	switch {
+	case len(f.IDs) > 0 && !containsID(f.IDs, record.ID):
+		return false
+	case f.Type != "" && record.Type != f.Type:
+		return false
+	case f.Detail != "" && record.Detail != f.Detail:
+		return false
+	case f.SessionID != "" && record.SessionID != f.SessionID:
+		return false
+	case f.MinImportance > 0 && record.Importance < f.MinImportance:
+		return false
+	case !f.UpdatedSince.IsZero() && record.UpdatedAt.Before(f.UpdatedSince):
+		return false
+	}
+	return true
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchResult is a record found by Search together with how close it is to the query.
+type SearchResult struct {
+	Record
+	Score float32 // cosine similarity to the query vector, 1 means identical
+}
+
+// MemoryStore is where the memory plugin keeps its memories and their embeddings.
+type MemoryStore interface {
+	// Insert stores the records and returns their new IDs, in order. Record IDs are ignored.
+	Insert(ctx context.Context, records []Record) ([]int64, error)
+	// Search returns up to topK records matching filter that are closest to vector, best match first.
+	Search(ctx context.Context, vector []float32, topK int, filter Filter) ([]SearchResult, error)
+	// Delete removes the records with the given IDs. Unknown IDs are ignored.
+	Delete(ctx context.Context, ids []int64) error
+	// List returns up to limit records matching filter after skipping offset, ordered by ID.
+	// A negative limit returns every record. Vectors are not included.
+	List(ctx context.Context, filter Filter, offset, limit int) ([]Record, error)
+	// Update replaces the fields and vector of an existing record and returns its ID,
+	// which changes on stores that cannot update a record in place.
+	Update(ctx context.Context, record Record) (int64, error)
+	// Close releases the connection or file held by the store.
+	Close() error
+}
+
+// openStore opens the backend chosen by plugins.memory.store, Milvus unless configured otherwise.
+func openStore(cfg config.Cfg, dimensions int) (MemoryStore, error) {
+	backend := storeMilvus
+	if v, ok := cfg.Lookup("plugins.memory.store"); ok && strings.TrimSpace(v) != "" {
+		backend = strings.TrimSpace(v)
+	}
+
+	switch backend {
+	case storeMilvus:
+		return openMilvusStore(cfg, dimensions)
+	case storeLocal:
+		path := defaultLocalPath
+		if v, ok := cfg.Lookup("plugins.memory.path"); ok && strings.TrimSpace(v) != "" {
+			path = strings.TrimSpace(v)
+		}
+		return openLocalStore(path, dimensions)
+	default:
+		return nil, fmt.Errorf("plugins.memory.store %q should be %s or %s", backend, storeMilvus, storeLocal)
+	}
+}
+
+// page returns up to limit records after skipping offset. A negative limit means no limit.
+func page(records []Record, offset, limit int) []Record {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(records) {
+		return nil
+	}
+	records = records[offset:]
+	if limit >= 0 && len(records) > limit {
+		records = records[:limit]
+	}
+	return records
+}
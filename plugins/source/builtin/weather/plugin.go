@@ -1,102 +1,120 @@
-package main
-
-import (
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-
-	"github.com/sashabaranov/go-openai"
-	"github.com/sashabaranov/go-openai/jsonschema"
-	"github.com/wangergou2023/clara/config"
-	"github.com/wangergou2023/clara/plugins"
-)
-
-var Plugin plugins.Plugin = &WeatherPlugin{}
-
-type WeatherPlugin struct {
-	cfg          config.Cfg
-	openaiClient *openai.Client
-}
-
-func (w *WeatherPlugin) Init(cfg config.Cfg, openaiClient *openai.Client) error {
-	w.cfg = cfg
-	w.openaiClient = openaiClient
-	return nil
-}
-
-func (w WeatherPlugin) ID() string {
-	return "weather"
-}
-
-func (w WeatherPlugin) Description() string {
-	return "获取指定地点的当前天气情况。"
-}
-
-func (w WeatherPlugin) FunctionDefinition() openai.FunctionDefinition {
-	return openai.FunctionDefinition{
-		Name:        "weather",
-		Description: "返回指定地点的当前天气情况。",
-		Parameters: jsonschema.Definition{
-			Type: jsonschema.Object,
-			Properties: map[string]jsonschema.Definition{
-				"location": {
-					Type:        jsonschema.String,
-					Description: "查询天气的地点。",
-				},
-			},
-			Required: []string{"location"},
-		},
-	}
-}
-
-func (w WeatherPlugin) Execute(jsonInput string) (string, error) {
-	var input struct {
-		Location string `json:"location"`
-	}
-	err := json.Unmarshal([]byte(jsonInput), &input)
-	if err != nil {
-		return "", err
-	}
-
-	weatherInfo, err := w.getWeather(input.Location)
-	if err != nil {
-		return "", err
-	}
-
-	return weatherInfo, nil
-}
-
-func (w WeatherPlugin) getWeather(location string) (string, error) {
-	// 使用配置中的API密钥
-	apiKey := w.cfg.OpenWeatherMapAPIKey()
-	url := fmt.Sprintf("http://api.openweathermap.org/data/2.5/weather?q=%s&appid=%s&units=metric", location, apiKey)
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	var result struct {
-		Weather []struct {
-			Description string `json:"description"`
-		} `json:"weather"`
-		Main struct {
-			Temp float64 `json:"temp"`
-		} `json:"main"`
-	}
-
-	if err := json.Unmarshal(body, &result); err != nil {
-		return "", err
-	}
-
-	if len(result.Weather) > 0 {
-		return fmt.Sprintf("%s, 温度: %.1f°C", result.Weather[0].Description, result.Main.Temp), nil
-	}
-
-	return "未能获取天气信息", nil
-}
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"github.com/wangergou2023/clara/config"
+	"github.com/wangergou2023/clara/llm"
+	"github.com/wangergou2023/clara/plugins"
+)
+
+func init() {
+	plugins.Register(&WeatherPlugin{})
+}
+
+type WeatherPlugin struct {
+	cfg      config.Cfg
+	provider llm.Provider
+}
+
+func (w *WeatherPlugin) Init(cfg config.Cfg, provider llm.Provider) error {
+	w.cfg = cfg
+	w.provider = provider
+	return nil
+}
+
+func (w WeatherPlugin) ID() string {
+	return "weather"
+}
+
+func (w WeatherPlugin) Description() string {
+	return "获取指定地点的当前天气情况。"
+}
+
+// Capabilities方法返回插件的能力，查询天气需要访问OpenWeatherMap
+func (w WeatherPlugin) Capabilities() []string {
+	return []string{plugins.CapabilityNetwork}
+}
+
+func (w WeatherPlugin) FunctionDefinition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{
+		Name:        "weather",
+		Description: "返回指定地点的当前天气情况。",
+		Parameters: jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"location": {
+					Type:        jsonschema.String,
+					Description: "查询天气的地点。",
+				},
+			},
+			Required: []string{"location"},
+		},
+	}
+}
+
+func (w WeatherPlugin) Execute(jsonInput string) (string, error) {
+	return w.ExecuteContext(context.Background(), jsonInput)
+}
+
+// ExecuteContext在ctx结束时中止对天气服务的请求
+func (w WeatherPlugin) ExecuteContext(ctx context.Context, jsonInput string) (string, error) {
+	var input struct {
+		Location string `json:"location"`
+	}
+	err := json.Unmarshal([]byte(jsonInput), &input)
+	if err != nil {
+		return "", err
+	}
+
+	weatherInfo, err := w.getWeather(ctx, input.Location)
+	if err != nil {
+		return "", err
+	}
+
+	return weatherInfo, nil
+}
+
+func (w WeatherPlugin) getWeather(ctx context.Context, location string) (string, error) {
+	// 使用配置中的API密钥
+	apiKey := w.cfg.OpenWeatherMapAPIKey()
+	url := fmt.Sprintf("http://api.openweathermap.org/data/2.5/weather?q=%s&appid=%s&units=metric", location, apiKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Weather []struct {
+			Description string `json:"description"`
+		} `json:"weather"`
+		Main struct {
+			Temp float64 `json:"temp"`
+		} `json:"main"`
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", err
+	}
+
+	if len(result.Weather) > 0 {
+		return fmt.Sprintf("%s, 温度: %.1f°C", result.Weather[0].Description, result.Main.Temp), nil
+	}
+
+	return "未能获取天气信息", nil
+}
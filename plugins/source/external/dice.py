@@ -0,0 +1,61 @@
+#!/usr/bin/env python3
+"""Clara外部插件示例：掷骰子。
+
+复制到 <plugins_path>/external/ 并加上可执行权限即可使用。
+Clara通过标准输入输出逐行发送和接收JSON-RPC 2.0消息，日志请写到标准错误。
+"""
+import json
+import random
+import sys
+
+config = {}
+
+
+def describe(params):
+    return {
+        "id": "dice",
+        "description": "掷骰子。",
+        "function": {
+            "name": "dice",
+            "description": "掷一个或多个骰子并返回点数。",
+            "parameters": {
+                "type": "object",
+                "properties": {
+                    "count": {"type": "integer", "description": "骰子的数量，默认为1"},
+                    "sides": {"type": "integer", "description": "骰子的面数，默认为6"},
+                },
+            },
+        },
+    }
+
+
+def init(params):
+    config.update(params.get("config", {}))
+    print("dice plugin initialized", file=sys.stderr)
+    return None
+
+
+def execute(params):
+    args = json.loads(params.get("arguments") or "{}")
+    count = int(args.get("count", 1))
+    sides = int(args.get("sides", config.get("plugins.dice.sides", 6)))
+    rolls = [random.randint(1, sides) for _ in range(count)]
+    return "掷出了: " + ", ".join(str(r) for r in rolls)
+
+
+methods = {"describe": describe, "init": init, "execute": execute}
+
+for line in sys.stdin:
+    if not line.strip():
+        continue
+    request = json.loads(line)
+    response = {"jsonrpc": "2.0", "id": request.get("id")}
+    try:
+        method = methods[request["method"]]
+        response["result"] = method(request.get("params") or {})
+    except KeyError:
+        response["error"] = {"code": -32601, "message": "method not found: " + request.get("method", "")}
+    except Exception as e:  # 插件自己的错误作为JSON-RPC错误返回
+        response["error"] = {"code": -32000, "message": str(e)}
+    sys.stdout.write(json.dumps(response, ensure_ascii=False) + "\n")
+    sys.stdout.flush()
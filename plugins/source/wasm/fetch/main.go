@@ -0,0 +1,173 @@
+//go:build wasip1
+
+// fetch是一个WebAssembly插件示例，读取允许访问的网页并返回开头的内容。
+// 编译：GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o plugins/wasm/fetch.wasm ./plugins/source/wasm/fetch
+// 需要在配置中设置允许访问的主机，例如 plugins.fetch_page.allowed_hosts: ["example.com"]
+package main
+
+import (
+	"encoding/json" // 用于编码和解码JSON
+	"fmt"           // 用于格式化输出
+	"runtime"       // 用于在宿主函数执行期间保持切片存活
+	"strconv"       // 用于解析配置和计数
+	"unsafe"        // 用于在内存地址和切片之间转换
+)
+
+// Clara提供的宿主函数
+//
+//go:wasmimport clara log
+func hostLog(ptr, size uint32)
+
+//go:wasmimport clara config_get
+func hostConfigGet(ptr, size uint32) uint64
+
+//go:wasmimport clara kv_get
+func hostKVGet(ptr, size uint32) uint64
+
+//go:wasmimport clara kv_set
+func hostKVSet(keyPtr, keySize, valuePtr, valueSize uint32) uint32
+
+//go:wasmimport clara http_fetch
+func hostHTTPFetch(ptr, size uint32) uint64
+
+// pinned保存交给Clara的内存，避免被垃圾回收。每次调用都是新的实例，不需要释放
+var pinned = make(map[uint32][]byte)
+
+//go:wasmexport alloc
+func alloc(size uint32) uint32 {
+	if size == 0 {
+		return 0
+	}
+	buf := make([]byte, size)
+	ptr := uint32(uintptr(unsafe.Pointer(&buf[0])))
+	pinned[ptr] = buf
+	return ptr
+}
+
+//go:wasmexport describe
+func describe() uint64 {
+	return output(map[string]interface{}{
+		"id":          "fetch_page",
+		"description": "读取网页并返回开头的内容。",
+		"function": map[string]interface{}{
+			"name":        "fetch_page",
+			"description": "读取一个网页，返回状态码和开头的内容。只能访问配置中允许的主机。",
+			"parameters": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"url": map[string]interface{}{
+						"type":        "string",
+						"description": "要读取的网页地址",
+					},
+				},
+				"required": []string{"url"},
+			},
+		},
+	})
+}
+
+//go:wasmexport execute
+func execute(ptr, size uint32) uint64 {
+	var args struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(bytes(ptr, size), &args); err != nil {
+		return output("invalid arguments: " + err.Error())
+	}
+
+	// 记录调用次数，演示键值存储
+	calls, _ := strconv.Atoi(get(hostKVGet, "calls"))
+	calls++
+	set("calls", strconv.Itoa(calls))
+	log(fmt.Sprintf("fetching %s (call #%d)", args.URL, calls))
+
+	request, _ := json.Marshal(map[string]string{"method": "GET", "url": args.URL})
+	var response struct {
+		Status int    `json:"status"`
+		Body   string `json:"body"`
+		Error  string `json:"error"`
+	}
+	if err := json.Unmarshal(call(hostHTTPFetch, request), &response); err != nil {
+		return output("invalid response: " + err.Error())
+	}
+	if response.Error != "" {
+		return output("error: " + response.Error)
+	}
+
+	maxChars := 2000
+	if v, err := strconv.Atoi(get(hostConfigGet, "max_chars")); err == nil && v > 0 {
+		maxChars = v
+	}
+	body := []rune(response.Body)
+	if len(body) > maxChars {
+		body = body[:maxChars]
+	}
+	return output(fmt.Sprintf("status %d\n%s", response.Status, string(body)))
+}
+
+// bytes函数把内存地址和长度转换为切片
+func bytes(ptr, size uint32) []byte {
+	if size == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Add(nil, ptr)), size)
+}
+
+// unpack函数把宿主函数返回的i64转换为切片
+func unpack(packed uint64) []byte {
+	return bytes(uint32(packed>>32), uint32(packed))
+}
+
+// pointer函数返回切片的地址和长度
+func pointer(b []byte) (uint32, uint32) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	return uint32(uintptr(unsafe.Pointer(&b[0]))), uint32(len(b))
+}
+
+// output函数把结果交给Clara，字符串原样返回，其他值编码为JSON
+func output(v interface{}) uint64 {
+	var b []byte
+	if s, ok := v.(string); ok {
+		b = []byte(s)
+	} else {
+		b, _ = json.Marshal(v)
+	}
+	ptr, size := pointer(b)
+	if size > 0 {
+		pinned[ptr] = b
+	}
+	return uint64(ptr)<<32 | uint64(size)
+}
+
+// call函数以(地址, 长度)调用宿主函数并读取结果
+func call(fn func(ptr, size uint32) uint64, in []byte) []byte {
+	ptr, size := pointer(in)
+	out := unpack(fn(ptr, size))
+	runtime.KeepAlive(in) // 宿主函数可能调用alloc触发垃圾回收
+	return out
+}
+
+func get(fn func(ptr, size uint32) uint64, key string) string {
+	return string(call(fn, []byte(key)))
+}
+
+func set(key, value string) {
+	k, v := []byte(key), []byte(value)
+	keyPtr, keySize := pointer(k)
+	valuePtr, valueSize := pointer(v)
+	if hostKVSet(keyPtr, keySize, valuePtr, valueSize) != 0 {
+		log("storage is full")
+	}
+	runtime.KeepAlive(k)
+	runtime.KeepAlive(v)
+}
+
+func log(msg string) {
+	b := []byte(msg)
+	hostLog(pointer(b))
+	runtime.KeepAlive(b)
+}
+
+func main() {}
@@ -0,0 +1,337 @@
+package plugins
+
+import (
+	"bytes"         // 用于按行切分插件输出
+	"context"       // 用于限制每次调用的执行时间
+	"encoding/json" // 用于解析插件的描述
+	"errors"        // 用于判断错误类型
+	"fmt"           // 用于格式化输出
+	"net/http"      // 用于插件的HTTP请求
+	"os"            // 用于读取插件目录
+	"path/filepath" // 用于文件路径操作
+	"strings"       // 用于处理主机列表
+	"time"          // 用于超时设置
+
+	"github.com/sashabaranov/go-openai"                            // OpenAI GPT库
+	"github.com/tetratelabs/wazero"                                // 纯Go实现的WebAssembly运行时
+	"github.com/tetratelabs/wazero/api"                            // WebAssembly模块接口
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1" // WASI支持
+	"github.com/wangergou2023/clara/config"                        // 配置包
+	"github.com/wangergou2023/clara/llm"                           // 模型服务
+)
+
+// WebAssembly插件放在插件目录的wasm子目录中，每个.wasm文件是一个插件。插件运行在沙箱里，
+// 不能访问文件系统、环境变量和网络，只能使用Clara在clara模块中提供的宿主函数。
+//
+// 插件需要是reactor模块（有_initialize时会先调用），并导出：
+//
+//	memory                         线性内存
+//	alloc(size i32) i32            分配size字节的内存，Clara用它向插件写入数据
+//	describe() i64                 返回描述JSON，格式与外部插件的describe相同
+//	execute(ptr i32, len i32) i64  参数是模型给出的JSON参数，返回插件结果字符串
+//
+// 返回值i64的高32位是数据在内存中的地址，低32位是长度，0表示没有数据。
+// 宿主函数使用同样的约定，字符串参数以(地址, 长度)传入：
+//
+//	log(ptr, len)                                    输出日志
+//	config_get(key_ptr, key_len) i64                 读取配置plugins.<插件ID>.<key>
+//	kv_get(key_ptr, key_len) i64                     读取键值存储
+//	kv_set(key_ptr, key_len, val_ptr, val_len) i32   写入键值存储，0表示成功，1表示超出容量
+//	kv_delete(key_ptr, key_len)                      删除键
+//	http_fetch(req_ptr, req_len) i64                 发送HTTP请求，只能访问plugins.<插件ID>.allowed_hosts中的主机
+//
+// 每次调用都会创建新的模块实例，内存上限为wasm.memory_limit_mb，执行时间上限为wasm.timeout。
+const wasmDir = "wasm"
+
+// wasmHostModule是宿主函数所在的模块名
+const wasmHostModule = "clara"
+
+// wasmCache在所有WebAssembly插件之间共享编译结果
+var wasmCache = wazero.NewCompilationCache()
+
+// wasmPlugin在wazero运行时中执行WebAssembly模块，实现Plugin接口
+type wasmPlugin struct {
+	path     string
+	describe describeResult
+	timeout  time.Duration
+
+	cfg          config.Cfg
+	allowedHosts []string // 允许http_fetch访问的主机，"*.example.com"匹配所有子域名
+	kv           *kvStore
+	httpClient   *http.Client
+
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+}
+
+// loadWasmPlugins函数加载插件目录wasm子目录中的所有.wasm文件，
+// 单个插件失败只会输出错误，不影响其他插件
+func loadWasmPlugins(cfg config.Cfg, provider llm.Provider) {
+	dir := filepath.Join(cfg.PluginsPath(), wasmDir)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println("Error reading WebAssembly plugins: ", err)
+		}
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".wasm" {
+			continue
+		}
+
+		fmt.Println("Loading WebAssembly plugin: ", file.Name())
+		p, err := loadWasmPlugin(filepath.Join(dir, file.Name()), cfg, provider)
+		if err != nil {
+			fmt.Printf("Error loading WebAssembly plugin %s: %v\n", file.Name(), err)
+			continue
+		}
+		if err := addPlugin(p, p.path); err != nil {
+			fmt.Printf("Error loading WebAssembly plugin %s: %v\n", file.Name(), err)
+			p.close()
+		}
+	}
+}
+
+// loadWasmPlugin函数编译并初始化单个WebAssembly插件，不会把插件加入映射
+func loadWasmPlugin(path string, cfg config.Cfg, provider llm.Provider) (*wasmPlugin, error) {
+	p := &wasmPlugin{path: path}
+	if err := p.Init(cfg, provider); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Init函数编译模块并读取插件的描述
+func (p *wasmPlugin) Init(cfg config.Cfg, provider llm.Provider) error {
+	p.cfg = cfg
+	p.timeout = cfg.WasmTimeout()
+
+	code, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	runtimeConfig := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(cfg.WasmMemoryLimitMB()) * 16). // 每页64KB
+		WithCloseOnContextDone(true).                               // 超时后中断正在执行的代码
+		WithCompilationCache(wasmCache)
+	p.runtime = wazero.NewRuntimeWithConfig(ctx, runtimeConfig)
+
+	// WASI只提供时钟、随机数等基础功能，没有挂载任何目录
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, p.runtime); err != nil {
+		return err
+	}
+	if err := p.instantiateHostModule(ctx); err != nil {
+		return err
+	}
+
+	p.compiled, err = p.runtime.CompileModule(ctx, code)
+	if err != nil {
+		return fmt.Errorf("error compiling module: %v", err)
+	}
+	for _, name := range []string{"alloc", "describe", "execute"} {
+		if _, ok := p.compiled.ExportedFunctions()[name]; !ok {
+			return fmt.Errorf("module does not export %s", name)
+		}
+	}
+
+	raw, err := p.call(context.Background(), "describe", nil)
+	if err != nil {
+		return fmt.Errorf("describe: %v", err)
+	}
+	if err := json.Unmarshal(raw, &p.describe); err != nil {
+		return fmt.Errorf("describe: invalid result: %v", err)
+	}
+	if p.describe.ID == "" {
+		return fmt.Errorf("describe: plugin has no id")
+	}
+	if p.describe.Function.Name == "" {
+		p.describe.Function.Name = p.describe.ID
+	}
+	// 模型按函数名称调用插件，名称与ID不同的模块加载后无法使用
+	if err := checkDescribedID(p.describe.ID, p.describe.Function.Name); err != nil {
+		return fmt.Errorf("describe: %v", err)
+	}
+	if err := checkCapabilities(p.describe.Capabilities); err != nil {
+		return fmt.Errorf("describe: %v", err)
+	}
+
+	if hosts, ok := p.config("allowed_hosts"); ok {
+		for _, host := range strings.Split(hosts, ",") {
+			if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+				p.allowedHosts = append(p.allowedHosts, host)
+			}
+		}
+	}
+	p.httpClient = &http.Client{CheckRedirect: p.checkRedirect}
+
+	p.kv, err = openKVStore(filepath.Join(cfg.PluginsPath(), wasmDir, "data", p.describe.ID+".json"))
+	return err
+}
+
+func (p *wasmPlugin) ID() string {
+	return p.describe.ID
+}
+
+func (p *wasmPlugin) Description() string {
+	return p.describe.Description
+}
+
+func (p *wasmPlugin) FunctionDefinition() openai.FunctionDefinition {
+	return p.describe.Function
+}
+
+// Capabilities函数返回插件在describe中声明的能力
+func (p *wasmPlugin) Capabilities() []string {
+	return p.describe.Capabilities
+}
+
+func (p *wasmPlugin) Execute(jsonInput string) (string, error) {
+	return p.ExecuteContext(context.Background(), jsonInput)
+}
+
+// ExecuteContext函数在新的模块实例中执行插件，ctx结束时会中断正在执行的代码
+func (p *wasmPlugin) ExecuteContext(ctx context.Context, jsonInput string) (string, error) {
+	result, err := p.call(ctx, "execute", []byte(jsonInput))
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// call函数创建一个新的模块实例并调用导出函数fn，input不为nil时作为(地址, 长度)参数传入。
+// 每次调用都使用新实例，一次调用中的内存使用和死循环不会影响下一次调用
+func (p *wasmPlugin) call(parent context.Context, fn string, input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(parent, p.timeout)
+	defer cancel()
+
+	logger := &pluginLogger{name: filepath.Base(p.path)}
+	defer logger.flush()
+	moduleConfig := wazero.NewModuleConfig().
+		WithName(""). // 匿名实例，允许同时执行多次调用
+		WithStartFunctions("_initialize").
+		WithStdout(logger).
+		WithStderr(logger)
+
+	mod, err := p.runtime.InstantiateModule(ctx, p.compiled, moduleConfig)
+	if err != nil {
+		return nil, p.callError(parent, ctx, err, nil)
+	}
+	defer mod.Close(context.Background())
+
+	var params []uint64
+	if input != nil {
+		ptr, err := writeGuest(ctx, mod, input)
+		if err != nil {
+			return nil, p.callError(parent, ctx, err, nil)
+		}
+		params = []uint64{uint64(ptr), uint64(len(input))}
+	}
+
+	results, err := mod.ExportedFunction(fn).Call(ctx, params...)
+	if err != nil {
+		return nil, p.callError(parent, ctx, err, mod)
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("%s should return a single i64", fn)
+	}
+	return readPacked(mod, results[0])
+}
+
+// callError函数把取消、超时和内存耗尽导致的中断转换为更容易理解的错误，只保留错误的第一行，
+// 省略WebAssembly的调用栈
+func (p *wasmPlugin) callError(parent, ctx context.Context, err error, mod api.Module) error {
+	if parent.Err() != nil {
+		return parent.Err() // 调用方取消了调用
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("plugin exceeded the time limit of %v", p.timeout)
+	}
+	message := strings.SplitN(err.Error(), "\n", 2)[0]
+	limit := uint64(p.cfg.WasmMemoryLimitMB()) << 20
+	if mod != nil && uint64(mod.Memory().Size()) >= limit*9/10 {
+		return fmt.Errorf("%s: plugin used %d MB of the %d MB memory limit", message, mod.Memory().Size()>>20, limit>>20)
+	}
+	return errors.New(message)
+}
+
+// config函数读取插件自己的配置plugins.<插件ID>.<key>，插件不能读取其他配置
+func (p *wasmPlugin) config(key string) (string, bool) {
+	if p.describe.ID == "" {
+		return "", false
+	}
+	return p.cfg.Lookup("plugins." + p.describe.ID + "." + key)
+}
+
+// close函数释放运行时
+func (p *wasmPlugin) close() {
+	if p.runtime != nil {
+		p.runtime.Close(context.Background())
+	}
+}
+
+// pluginLogger把插件的输出按行加上插件名称后打印
+type pluginLogger struct {
+	name string
+	buf  []byte
+}
+
+func (l *pluginLogger) Write(b []byte) (int, error) {
+	l.buf = append(l.buf, b...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		fmt.Printf("[%s] %s\n", l.name, l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// flush函数打印最后一行没有换行的输出
+func (l *pluginLogger) flush() {
+	if len(l.buf) > 0 {
+		fmt.Printf("[%s] %s\n", l.name, l.buf)
+		l.buf = nil
+	}
+}
+
+// packPtr函数把地址和长度打包为i64
+func packPtr(ptr, size uint32) uint64 {
+	return uint64(ptr)<<32 | uint64(size)
+}
+
+// readGuest函数从模块内存中复制一段数据
+func readGuest(mod api.Module, ptr, size uint32) ([]byte, error) {
+	data, ok := mod.Memory().Read(ptr, size)
+	if !ok {
+		return nil, fmt.Errorf("memory access out of range: %d+%d", ptr, size)
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// readPacked函数读取打包为i64的数据，0表示没有数据
+func readPacked(mod api.Module, packed uint64) ([]byte, error) {
+	if packed == 0 {
+		return []byte{}, nil
+	}
+	return readGuest(mod, uint32(packed>>32), uint32(packed))
+}
+
+// writeGuest函数调用模块的alloc分配内存并写入数据，返回数据的地址
+func writeGuest(ctx context.Context, mod api.Module, data []byte) (uint32, error) {
+	results, err := mod.ExportedFunction("alloc").Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, fmt.Errorf("alloc: %v", err)
+	}
+	ptr := uint32(results[0])
+	if !mod.Memory().Write(ptr, data) {
+		return 0, fmt.Errorf("alloc returned an invalid address %d", ptr)
+	}
+	return ptr, nil
+}
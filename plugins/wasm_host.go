@@ -0,0 +1,267 @@
+package plugins
+
+import (
+	"context"       // 宿主函数在调用的context中执行
+	"encoding/json" // 用于编码HTTP请求和响应
+	"fmt"           // 用于格式化输出
+	"io"            // 用于读取响应体
+	"net/http"      // 用于插件的HTTP请求
+	"net/url"       // 用于检查请求地址
+	"os"            // 用于读写键值存储文件
+	"path/filepath" // 用于文件路径操作
+	"strings"       // 用于匹配主机名
+	"sync"          // 用于保护键值存储
+
+	"github.com/tetratelabs/wazero/api" // WebAssembly模块接口
+)
+
+// 宿主函数的资源限制
+const (
+	maxFetchBodyBytes = 1 << 20 // http_fetch响应体的最大长度
+	maxKVBytes        = 1 << 20 // 每个插件键值存储的总大小
+)
+
+// fetchRequest是插件传给http_fetch的请求
+type fetchRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// fetchResponse是http_fetch返回给插件的响应，请求失败时只有Error
+type fetchResponse struct {
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// instantiateHostModule函数创建clara宿主模块，插件只能通过这些函数访问外部世界。
+// 宿主函数中的panic会中止本次调用，并作为错误返回
+func (p *wasmPlugin) instantiateHostModule(ctx context.Context) error {
+	name := filepath.Base(p.path)
+
+	_, err := p.runtime.NewHostModuleBuilder(wasmHostModule).
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod api.Module, ptr, size uint32) {
+			fmt.Printf("[%s] %s\n", name, mustReadGuest(mod, ptr, size))
+		}).
+		Export("log").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod api.Module, ptr, size uint32) uint64 {
+			value, ok := p.config(string(mustReadGuest(mod, ptr, size)))
+			if !ok {
+				return 0
+			}
+			return mustWriteGuest(ctx, mod, []byte(value))
+		}).
+		Export("config_get").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod api.Module, ptr, size uint32) uint64 {
+			value, ok := p.kv.get(string(mustReadGuest(mod, ptr, size)))
+			if !ok {
+				return 0
+			}
+			return mustWriteGuest(ctx, mod, []byte(value))
+		}).
+		Export("kv_get").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod api.Module, keyPtr, keySize, valuePtr, valueSize uint32) uint32 {
+			key := string(mustReadGuest(mod, keyPtr, keySize))
+			value := string(mustReadGuest(mod, valuePtr, valueSize))
+			if err := p.kv.set(key, value); err != nil {
+				fmt.Printf("[%s] kv_set: %v\n", name, err)
+				return 1
+			}
+			return 0
+		}).
+		Export("kv_set").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod api.Module, ptr, size uint32) {
+			if err := p.kv.delete(string(mustReadGuest(mod, ptr, size))); err != nil {
+				fmt.Printf("[%s] kv_delete: %v\n", name, err)
+			}
+		}).
+		Export("kv_delete").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod api.Module, ptr, size uint32) uint64 {
+			var req fetchRequest
+			var resp fetchResponse
+			if err := json.Unmarshal(mustReadGuest(mod, ptr, size), &req); err != nil {
+				resp.Error = fmt.Sprintf("invalid request: %v", err)
+			} else {
+				resp = p.fetch(ctx, req)
+			}
+			data, _ := json.Marshal(resp)
+			return mustWriteGuest(ctx, mod, data)
+		}).
+		Export("http_fetch").
+		Instantiate(ctx)
+	return err
+}
+
+// fetch函数执行插件的HTTP请求，请求在本次调用的剩余时间内有效
+func (p *wasmPlugin) fetch(ctx context.Context, req fetchRequest) fetchResponse {
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return fetchResponse{Error: fmt.Sprintf("invalid url: %v", err)}
+	}
+	if err := p.checkURL(u); err != nil {
+		return fetchResponse{Error: err.Error()}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, u.String(), strings.NewReader(req.Body))
+	if err != nil {
+		return fetchResponse{Error: err.Error()}
+	}
+	for k, v := range req.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	httpResp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return fetchResponse{Error: err.Error()}
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, maxFetchBodyBytes+1))
+	if err != nil {
+		return fetchResponse{Error: err.Error()}
+	}
+	if len(body) > maxFetchBodyBytes {
+		return fetchResponse{Error: fmt.Sprintf("response body exceeds %d bytes", maxFetchBodyBytes)}
+	}
+
+	headers := make(map[string]string, len(httpResp.Header))
+	for k := range httpResp.Header {
+		headers[k] = httpResp.Header.Get(k)
+	}
+	return fetchResponse{Status: httpResp.StatusCode, Headers: headers, Body: string(body)}
+}
+
+// checkURL函数检查地址的协议和主机是否允许访问
+func (p *wasmPlugin) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme %q is not allowed", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range p.allowedHosts {
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %q is not in plugins.%s.allowed_hosts", host, p.describe.ID)
+}
+
+// checkRedirect函数对重定向的地址做同样的检查，避免通过重定向访问未允许的主机
+func (p *wasmPlugin) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	return p.checkURL(req.URL)
+}
+
+// mustReadGuest函数读取模块内存，越界时panic以中止本次调用
+func mustReadGuest(mod api.Module, ptr, size uint32) []byte {
+	data, err := readGuest(mod, ptr, size)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// mustWriteGuest函数把数据写入模块内存并返回打包后的地址和长度，失败时panic以中止本次调用
+func mustWriteGuest(ctx context.Context, mod api.Module, data []byte) uint64 {
+	if len(data) == 0 {
+		return 0
+	}
+	ptr, err := writeGuest(ctx, mod, data)
+	if err != nil {
+		panic(err)
+	}
+	return packPtr(ptr, uint32(len(data)))
+}
+
+// kvStore是插件的键值存储，保存在一个JSON文件中，插件之间互相隔离
+type kvStore struct {
+	mu   sync.Mutex
+	path string
+	data map[string]string
+	size int
+}
+
+// openKVStore函数读取键值存储文件，文件不存在时创建空的存储
+func openKVStore(path string) (*kvStore, error) {
+	s := &kvStore{path: path, data: make(map[string]string)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.data); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+	for k, v := range s.data {
+		s.size += len(k) + len(v)
+	}
+	return s, nil
+}
+
+func (s *kvStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.data[key]
+	return value, ok
+}
+
+func (s *kvStore) set(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	size := s.size + len(key) + len(value)
+	if old, ok := s.data[key]; ok {
+		size -= len(key) + len(old)
+	}
+	if size > maxKVBytes {
+		return fmt.Errorf("storage limit of %d bytes exceeded", maxKVBytes)
+	}
+
+	s.data[key] = value
+	s.size = size
+	return s.save()
+}
+
+func (s *kvStore) delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	delete(s.data, key)
+	s.size -= len(key) + len(old)
+	return s.save()
+}
+
+// save函数先写入临时文件再重命名，避免写到一半时留下损坏的文件，调用方需要持有s.mu
+func (s *kvStore) save() error {
+	data, err := json.Marshal(s.data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,31 @@
+package plugins
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckDescribedID(t *testing.T) {
+	tests := []struct {
+		id, function string
+		valid        bool
+	}{
+		{"dice", "dice", true},
+		{"fetch_url-2", "fetch_url-2", true},
+		{strings.Repeat("a", 64), strings.Repeat("a", 64), true},
+		{strings.Repeat("a", 65), strings.Repeat("a", 65), false},
+		{"../../../x", "../../../x", false},
+		{"a/b", "a/b", false},
+		{"ab.c", "ab.c", false},
+		{"", "dice", false},
+		{"dice", "roll_dice", false},
+		{"fetch_page", "", false},
+	}
+
+	for _, tt := range tests {
+		err := checkDescribedID(tt.id, tt.function)
+		if (err == nil) != tt.valid {
+			t.Errorf("checkDescribedID(%q, %q) = %v, want valid %v", tt.id, tt.function, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package plugins
+
+import (
+	"context"       // 用于停止监视
+	"fmt"           // 用于格式化输出
+	"os"            // 用于读取插件目录
+	"path/filepath" // 用于文件路径操作
+	"sort"          // 用于按路径排序变化
+	"time"          // 用于定时检查
+
+	"github.com/wangergou2023/clara/config" // 配置包
+	"github.com/wangergou2023/clara/llm"    // 模型服务
+)
+
+// 插件变化的类型
+const (
+	ChangeAdded   = "added"
+	ChangeUpdated = "updated"
+	ChangeRemoved = "removed"
+	ChangeFailed  = "failed"
+)
+
+// Change描述插件目录中一个插件的变化
+type Change struct {
+	Action string // ChangeAdded、ChangeUpdated、ChangeRemoved或ChangeFailed
+	ID     string // 插件ID，加载失败时可能为空
+	Path   string // 插件文件的路径
+	Err    error  // 加载失败的原因，只有ChangeFailed才有
+}
+
+func (c Change) String() string {
+	if c.Action == ChangeFailed {
+		return fmt.Sprintf("failed to load %s: %v", filepath.Base(c.Path), c.Err)
+	}
+	return fmt.Sprintf("%s plugin %s", c.Action, c.ID)
+}
+
+// fileState是插件文件的大小和修改时间，任意一个变化都视为文件被修改
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// Watcher定时检查插件目录，加载新增的插件、重新加载修改过的插件并移除被删除的插件。
+// 外部插件和WebAssembly插件可以随时重新加载；Go插件无法卸载，修改后需要重启Clara
+type Watcher struct {
+	cfg      config.Cfg
+	provider llm.Provider
+	files    map[string]fileState
+}
+
+// NewWatcher函数记录插件目录的当前状态，通常在LoadPlugins之后立即调用
+func NewWatcher(cfg config.Cfg, provider llm.Provider) *Watcher {
+	return &Watcher{cfg: cfg, provider: provider, files: scanPluginFiles(cfg)}
+}
+
+// Run函数每隔interval检查一次插件目录，有变化时调用onChange，直到ctx被取消
+func (w *Watcher) Run(ctx context.Context, interval time.Duration, onChange func([]Change)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if changes := w.Check(); len(changes) > 0 {
+				onChange(changes)
+			}
+		}
+	}
+}
+
+// Check函数比较插件目录与上一次检查时的状态，并按变化加载、重新加载或移除插件
+func (w *Watcher) Check() []Change {
+	files := scanPluginFiles(w.cfg)
+
+	paths := make([]string, 0, len(files)+len(w.files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	for path := range w.files {
+		if _, ok := files[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var changes []Change
+	for _, path := range paths {
+		old, existed := w.files[path]
+		state, exists := files[path]
+		switch {
+		case !exists:
+			if p := removePlugin(path); p != nil {
+				closePlugin(p)
+				changes = append(changes, Change{Action: ChangeRemoved, ID: p.ID(), Path: path})
+			}
+		case !existed:
+			if loadedFrom(path) {
+				continue // 已经通过Install加载
+			}
+			changes = append(changes, w.load(path))
+		case state != old:
+			changes = append(changes, w.reload(path))
+		}
+	}
+
+	w.files = files
+	return changes
+}
+
+// load函数加载新增的插件文件
+func (w *Watcher) load(path string) Change {
+	p, err := openPluginFile(path, w.cfg, w.provider)
+	if err == nil {
+		if err = addPlugin(p, path); err != nil {
+			closePlugin(p)
+		}
+	}
+	if err != nil {
+		return Change{Action: ChangeFailed, Path: path, Err: err}
+	}
+	return Change{Action: ChangeAdded, ID: p.ID(), Path: path}
+}
+
+// reload函数重新加载修改过的插件文件，新版本加载失败时保留原来的插件
+func (w *Watcher) reload(path string) Change {
+	if filepath.Ext(path) == ".so" && loadedFrom(path) {
+		return Change{Action: ChangeFailed, Path: path, Err: fmt.Errorf("Go plugins cannot be reloaded, restart Clara to use the new version")}
+	}
+
+	p, err := openPluginFile(path, w.cfg, w.provider)
+	if err != nil {
+		return Change{Action: ChangeFailed, Path: path, Err: fmt.Errorf("%v, keeping the previous version", err)}
+	}
+	old, err := replacePlugin(path, p)
+	if err != nil {
+		closePlugin(p)
+		return Change{Action: ChangeFailed, Path: path, Err: err}
+	}
+	if old == nil {
+		return Change{Action: ChangeAdded, ID: p.ID(), Path: path}
+	}
+	closePlugin(old)
+	return Change{Action: ChangeUpdated, ID: p.ID(), Path: path}
+}
+
+// scanPluginFiles函数列出插件目录中所有会被加载的插件文件
+func scanPluginFiles(cfg config.Cfg) map[string]fileState {
+	files := make(map[string]fileState)
+	scan := func(dir string, match func(info os.FileInfo) bool) {
+		entries, err := os.ReadDir(filepath.Join(cfg.PluginsPath(), dir))
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !match(info) {
+				continue
+			}
+			path := filepath.Join(cfg.PluginsPath(), dir, entry.Name())
+			files[path] = fileState{size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+
+	scan("compiled", func(info os.FileInfo) bool { return !info.IsDir() && filepath.Ext(info.Name()) == ".so" })
+	scan(externalDir, isExecutable)
+	scan(wasmDir, func(info os.FileInfo) bool { return !info.IsDir() && filepath.Ext(info.Name()) == ".wasm" })
+	return files
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/wangergou2023/clara/config"
+	"github.com/wangergou2023/clara/server"
+)
+
+// serve函数以HTTP服务器的方式运行Clara，收到SIGINT或SIGTERM后等待进行中的请求完成再退出
+func serve(args []string) {
+	fs := flag.NewFlagSet("clara serve", flag.ExitOnError)
+	flags := config.BindFlags(fs)
+	fs.Parse(args)
+
+	cfg, clara := start(flags)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.New(cfg, clara).Run(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println("Server stopped.")
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/rand"   // 用于生成回复ID
+	"encoding/hex"  // 用于把回复ID编码为字符串
+	"encoding/json" // 用于解码请求
+	"net/http"      // HTTP服务器
+	"strings"       // 用于判断模型名称
+	"time"          // 用于回复的创建时间
+
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/assistant" // 助手和会话
+)
+
+// claraModel是/v1/models中列出的模型名称，使用它时请求会发送给配置的模型
+const claraModel = "clara"
+
+// openaiError是OpenAI格式的错误响应
+type openaiError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+// writeOpenAIError函数以OpenAI的格式写入错误响应，使OpenAI客户端能够正确显示错误
+func writeOpenAIError(w http.ResponseWriter, status int, errorType string, message string) {
+	writeJSON(w, status, map[string]openaiError{"error": {Message: message, Type: errorType}})
+}
+
+// handleModels函数处理GET /v1/models
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	created := time.Now().Unix()
+	models := []openai.Model{
+		{ID: claraModel, Object: "model", CreatedAt: created, OwnedBy: "clara"},
+	}
+	if s.model != claraModel {
+		models = append(models, openai.Model{ID: s.model, Object: "model", CreatedAt: created, OwnedBy: "clara"})
+	}
+
+	writeJSON(w, http.StatusOK, openai.ModelsList{Models: models})
+}
+
+// handleChatCompletions函数处理POST /v1/chat/completions。请求会加上Clara的系统提示、
+// 用户记忆和插件，插件调用在内部完成，返回的是普通的（或流式的）对话回复
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var req openai.ChatCompletionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid request body: "+err.Error())
+		return
+	}
+	switch {
+	case len(req.Messages) == 0:
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "messages must not be empty")
+		return
+	case len(req.Functions) > 0 || req.FunctionCall != nil:
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "functions are not supported, use tools instead")
+		return
+	case req.N > 1:
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "n greater than 1 is not supported")
+		return
+	}
+
+	// 模型名称为空或clara时使用配置的模型，其他名称原样传给上游
+	requested := req.Model
+	if req.Model == "" || strings.EqualFold(req.Model, claraModel) {
+		req.Model = s.model
+	}
+
+	ctx, cancel := s.withTimeout(r)
+	defer cancel()
+
+	if !req.Stream {
+		resp, err := s.assistant.Complete(ctx, req, assistant.Handler{})
+		if err != nil {
+			writeOpenAIError(w, turnErrorStatus(err), "api_error", err.Error())
+			return
+		}
+		if requested != "" {
+			resp.Model = requested
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	events, err := newSSEWriter(w)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, "api_error", err.Error())
+		return
+	}
+
+	chunk := openai.ChatCompletionStreamResponse{
+		ID:      newCompletionID(),
+		Object:  "chat.completion.chunk",
+		Created: time.Now().Unix(),
+		Model:   requested,
+	}
+	if chunk.Model == "" {
+		chunk.Model = req.Model
+	}
+	sendDelta := func(delta openai.ChatCompletionStreamChoiceDelta, finishReason openai.FinishReason) {
+		chunk.Choices = []openai.ChatCompletionStreamChoice{{Delta: delta, FinishReason: finishReason}}
+		events.data(chunk)
+	}
+
+	sendDelta(openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant}, "")
+	resp, err := s.assistant.Complete(ctx, req, assistant.Handler{
+		OnChunk: func(content string) {
+			sendDelta(openai.ChatCompletionStreamChoiceDelta{Content: content}, "")
+		},
+	})
+	if err != nil {
+		// 响应头已经发出，只能在流中报告错误
+		events.data(map[string]openaiError{"error": {Message: err.Error(), Type: "api_error"}})
+		events.done()
+		return
+	}
+
+	choice := resp.Choices[0]
+	if len(choice.Message.ToolCalls) > 0 {
+		toolCalls := make([]openai.ToolCall, len(choice.Message.ToolCalls))
+		for i, toolCall := range choice.Message.ToolCalls {
+			index := i
+			toolCall.Index = &index
+			toolCalls[i] = toolCall
+		}
+		sendDelta(openai.ChatCompletionStreamChoiceDelta{ToolCalls: toolCalls}, "")
+	}
+	finishReason := choice.FinishReason
+	if finishReason == "" {
+		finishReason = openai.FinishReasonStop
+	}
+	sendDelta(openai.ChatCompletionStreamChoiceDelta{}, finishReason)
+	events.done()
+}
+
+// newCompletionID函数生成流式回复的ID
+func newCompletionID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "chatcmpl-clara"
+	}
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,171 @@
+// server包通过HTTP/JSON API提供Clara的会话，供其他服务调用
+package server
+
+import (
+	"context"       // 用于控制请求超时和优雅关闭
+	"crypto/subtle" // 用于比较API密钥
+	"encoding/json" // 用于编码和解码JSON
+	"errors"        // 用于判断错误类型
+	"fmt"           // 用于格式化输出
+	"net"           // 用于监听端口
+	"net/http"      // HTTP服务器
+	"strings"       // 用于解析请求头
+	"time"          // 用于超时设置
+
+	"github.com/wangergou2023/clara/assistant" // 助手和会话
+	"github.com/wangergou2023/clara/config"    // 配置
+)
+
+// maxBodyBytes是请求体的最大长度
+const maxBodyBytes = 1 << 20
+
+// Server把助手的会话以REST接口的形式提供出去，所有请求都经过与命令行相同的assistant代码
+type Server struct {
+	assistant      *assistant.Assistant
+	model          string // 请求clara模型时实际使用的模型
+	addr           string
+	apiKey         string
+	requestTimeout time.Duration
+	sessionIdle    time.Duration // 会话空闲多久后从内存中移除，0表示不移除
+}
+
+// New函数根据配置创建服务器
+func New(cfg config.Cfg, clara *assistant.Assistant) *Server {
+	return &Server{
+		assistant:      clara,
+		model:          cfg.Model(),
+		addr:           cfg.ServerAddr(),
+		apiKey:         cfg.ServerAPIKey(),
+		requestTimeout: cfg.ServerRequestTimeout(),
+		sessionIdle:    cfg.ServerSessionIdleTimeout(),
+	}
+}
+
+// Handler函数返回服务器的路由，所有接口都需要通过API密钥校验
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/plugins", s.handlePlugins)
+	mux.HandleFunc("/api/sessions", s.handleSessions)
+	mux.HandleFunc("/api/sessions/", s.handleSession)
+	mux.HandleFunc("/v1/models", s.handleModels)
+	mux.HandleFunc("/v1/chat/completions", s.handleChatCompletions)
+	return s.authenticate(mux)
+}
+
+// Run函数在配置的地址上监听，直到ctx被取消，然后等待正在处理的请求完成后关闭
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      s.requestTimeout + 10*time.Second, // 流式回复在整个请求期间都在写入
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(listener)
+	}()
+	fmt.Printf("Clara API listening on http://%s\n", listener.Addr())
+	go s.evictIdleSessions(ctx)
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	// 停止接受新请求，正在进行的对话最多再等待一个请求超时
+	fmt.Println("Shutting down, waiting for in-flight requests...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.requestTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("error shutting down server: %v", err)
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// evictIdleSessions函数定期从内存中移除空闲的会话，直到ctx被取消。通过API创建和加载的会话
+// 没有人会关闭，不移除的话长时间运行的服务器占用的内存会一直增长
+func (s *Server) evictIdleSessions(ctx context.Context) {
+	if s.sessionIdle <= 0 {
+		return
+	}
+	interval := s.sessionIdle / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.assistant.EvictIdleSessions(s.sessionIdle)
+		}
+	}
+}
+
+// authenticate函数在配置了API密钥时校验Authorization: Bearer请求头
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if s.apiKey == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.apiKey)) != 1 {
+			if strings.HasPrefix(r.URL.Path, "/v1/") {
+				writeOpenAIError(w, http.StatusUnauthorized, "invalid_request_error", "invalid API key")
+				return
+			}
+			writeError(w, http.StatusUnauthorized, "invalid API key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withTimeout函数为一次请求创建带超时的context，客户端断开连接时同样会被取消
+func (s *Server) withTimeout(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), s.requestTimeout)
+}
+
+// writeJSON函数以JSON格式写入响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println("Error writing response: ", err)
+	}
+}
+
+// writeError函数以{"error": "..."}的格式写入错误响应
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// readJSON函数解码请求体，请求体过大或格式错误时返回错误
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+// methodNotAllowed函数返回405并列出允许的方法
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
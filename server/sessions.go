@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"  // 用于判断超时
+	"errors"   // 用于判断错误类型
+	"net/http" // HTTP服务器
+	"sort"     // 用于对插件排序
+	"strings"  // 用于解析路径
+	"time"     // 用于会话创建时间
+
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/assistant" // 助手和会话
+	"github.com/wangergou2023/clara/plugins"   // 插件系统
+	"github.com/wangergou2023/clara/store"     // 会话持久化
+)
+
+// sessionResponse是返回给客户端的会话信息
+type sessionResponse struct {
+	ID           string    `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"`
+	Plugins      []string  `json:"plugins"`
+	TokensUsed   int       `json:"tokens_used"`
+	TokensBudget int       `json:"tokens_budget"`
+}
+
+// pluginResponse是返回给客户端的插件信息
+type pluginResponse struct {
+	ID           string      `json:"id"`
+	Description  string      `json:"description"`
+	Parameters   interface{} `json:"parameters,omitempty"`
+	Capabilities []string    `json:"capabilities,omitempty"`
+}
+
+// messageRequest是发送消息的请求体，stream为true或Accept为text/event-stream时以SSE返回
+type messageRequest struct {
+	Content string `json:"content"`
+	Stream  bool   `json:"stream"`
+}
+
+// messageResponse是非流式发送消息时的响应
+type messageResponse struct {
+	SessionID string `json:"session_id"`
+	Content   string `json:"content"`
+}
+
+// toolCallEvent是流式回复中插件开始执行和执行完成的事件
+type toolCallEvent struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// newSessionResponse函数汇总会话的信息
+func newSessionResponse(session *assistant.Session) sessionResponse {
+	used, budget := session.TokenUsage()
+	return sessionResponse{
+		ID:           session.ID(),
+		CreatedAt:    session.CreatedAt(),
+		Provider:     session.Provider(),
+		Model:        session.Model(),
+		Plugins:      session.Plugins(),
+		TokensUsed:   used,
+		TokensBudget: budget,
+	}
+}
+
+// handlePlugins函数处理GET /api/plugins，列出所有已加载的插件
+func (s *Server) handlePlugins(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	all := plugins.GetAllPlugins()
+	list := make([]pluginResponse, 0, len(all))
+	for id, p := range all {
+		list = append(list, pluginResponse{
+			ID:           id,
+			Description:  p.Description(),
+			Parameters:   p.FunctionDefinition().Parameters,
+			Capabilities: plugins.CapabilitiesOf(p),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"plugins": list})
+}
+
+// handleSessions函数处理GET /api/sessions（列出已保存的会话）和POST /api/sessions（创建会话）
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sessions, err := s.assistant.ListSessions()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if sessions == nil {
+			sessions = []store.SessionInfo{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"sessions": sessions})
+
+	case http.MethodPost:
+		ctx, cancel := s.withTimeout(r)
+		defer cancel()
+
+		// 新会话会先把系统提示发送给模型，失败时会话仍然存在，可以继续使用
+		session, err := s.assistant.NewSessionContext(ctx)
+		if err != nil {
+			writeJSON(w, turnErrorStatus(err), map[string]interface{}{
+				"error":   "error starting session: " + err.Error(),
+				"session": newSessionResponse(session),
+			})
+			return
+		}
+		writeJSON(w, http.StatusCreated, newSessionResponse(session))
+
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// handleSession函数处理/api/sessions/{id}和/api/sessions/{id}/messages
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sessions/"), "/"), "/")
+	if parts[0] == "" || len(parts) > 2 || (len(parts) == 2 && parts[1] != "messages") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	id := parts[0]
+
+	// 删除不需要先把会话加载到内存
+	if len(parts) == 1 && r.Method == http.MethodDelete {
+		s.deleteSession(w, id)
+		return
+	}
+
+	session, err := s.assistant.LoadSession(id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+			return
+		}
+		writeJSON(w, http.StatusOK, newSessionResponse(session))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"messages": session.History()})
+	case http.MethodPost:
+		s.postMessage(w, r, session)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// deleteSession函数处理DELETE /api/sessions/{id}
+func (s *Server) deleteSession(w http.ResponseWriter, id string) {
+	err := s.assistant.DeleteSession(id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// postMessage函数处理POST /api/sessions/{id}/messages，发送一条用户消息并返回回复。
+// 同一个会话的消息按顺序处理，不同会话之间互不影响
+func (s *Server) postMessage(w http.ResponseWriter, r *http.Request, session *assistant.Session) {
+	var req messageRequest
+	if err := readJSON(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		writeError(w, http.StatusBadRequest, "content must not be empty")
+		return
+	}
+
+	ctx, cancel := s.withTimeout(r)
+	defer cancel()
+
+	if !req.Stream && !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		response, err := session.MessageContext(ctx, req.Content, assistant.Handler{})
+		if err != nil {
+			writeError(w, turnErrorStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, messageResponse{SessionID: session.ID(), Content: response})
+		return
+	}
+
+	events, err := newSSEWriter(w)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// 事件依次为chunk、tool_call、tool_result，最后是done或error
+	response, err := session.MessageContext(ctx, req.Content, assistant.Handler{
+		OnChunk: func(chunk string) {
+			events.send("chunk", map[string]string{"content": chunk})
+		},
+		OnToolCall: func(call openai.ToolCall) {
+			events.send("tool_call", toolCallEvent{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+		},
+		OnToolResult: func(call openai.ToolCall, result string, err error) {
+			event := toolCallEvent{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments, Result: result}
+			if err != nil {
+				event.Error = err.Error()
+			}
+			events.send("tool_result", event)
+		},
+	})
+	if err != nil {
+		events.send("error", map[string]interface{}{"error": err.Error(), "status": turnErrorStatus(err)})
+		return
+	}
+	events.send("done", messageResponse{SessionID: session.ID(), Content: response})
+}
+
+// turnErrorStatus函数把一轮对话的错误转换为HTTP状态码
+func turnErrorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json" // 用于编码事件数据
+	"fmt"           // 用于格式化输出
+	"net/http"      // HTTP服务器
+	"sync"          // 并行执行的插件会同时发送事件
+)
+
+// sseWriter以Server-Sent Events的格式向客户端发送事件
+type sseWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newSSEWriter函数写入SSE响应头，ResponseWriter不支持刷新时返回错误
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming is not supported by this connection")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // 关闭反向代理的缓冲
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &sseWriter{w: w, flusher: flusher}, nil
+}
+
+// send函数发送一个事件，data会被编码为JSON
+func (s *sseWriter) send(event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		fmt.Println("Error encoding event: ", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload)
+	s.flusher.Flush()
+}
+
+// data函数发送一个没有事件名的消息，OpenAI的流式接口使用这种格式
+func (s *sseWriter) data(v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		fmt.Println("Error encoding event: ", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintf(s.w, "data: %s\n\n", payload)
+	s.flusher.Flush()
+}
+
+// done函数发送OpenAI流式接口的结束标记
+func (s *sseWriter) done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprint(s.w, "data: [DONE]\n\n")
+	s.flusher.Flush()
+}
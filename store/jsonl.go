@@ -0,0 +1,257 @@
+package store
+
+import (
+	"bufio"         // 用于逐行读取
+	"encoding/json" // 用于JSON编解码
+	"errors"        // 用于判断错误类型
+	"fmt"           // 用于格式化错误
+	"io"            // 用于定位文件末尾
+	"os"            // 用于文件操作
+	"path/filepath" // 用于拼接路径
+	"regexp"        // 用于校验会话ID
+	"sort"          // 用于对会话排序
+	"strings"       // 用于字符串处理
+	"sync"          // 用于串行化写入
+)
+
+// jsonlExt是会话文件的扩展名
+const jsonlExt = ".jsonl"
+
+// titleLength是会话标题的最大长度（按字符计）
+const titleLength = 60
+
+// sessionIDPattern限制会话ID只能包含安全的文件名字符
+var sessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// JSONLStore把每个会话保存为目录下的一个JSONL文件，每行一条记录
+type JSONLStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewJSONLStore函数创建一个JSONL存储，目录不存在时会自动创建
+func NewJSONLStore(dir string) (*JSONLStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating sessions directory: %v", err)
+	}
+	return &JSONLStore{dir: dir}, nil
+}
+
+// path函数返回会话文件的路径
+func (s *JSONLStore) path(sessionID string) (string, error) {
+	if !sessionIDPattern.MatchString(sessionID) {
+		return "", fmt.Errorf("%w: invalid session id %q", ErrNotFound, sessionID)
+	}
+	return filepath.Join(s.dir, sessionID+jsonlExt), nil
+}
+
+// Append函数把记录追加到会话文件末尾。文件以不完整的一行结尾时（例如写入时崩溃），
+// 先去掉这一行，否则新记录会接在它后面，整个文件都无法读取
+func (s *JSONLStore) Append(sessionID string, records ...Record) error {
+	path, err := s.path(sessionID)
+	if err != nil {
+		return err
+	}
+
+	var buf strings.Builder
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("error marshaling record: %v", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	end, err := dropPartialLine(f)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt([]byte(buf.String()), end)
+	return err
+}
+
+// dropPartialLine函数去掉文件末尾没有换行符的不完整的一行，返回去掉之后的文件长度
+func dropPartialLine(f *os.File) (int64, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil || size == 0 {
+		return size, err
+	}
+
+	// 从文件末尾向前按块查找最后一个换行符
+	chunk := make([]byte, 4096)
+	for end := size; end > 0; {
+		start := end - int64(len(chunk))
+		if start < 0 {
+			start = 0
+		}
+		n, err := f.ReadAt(chunk[:end-start], start)
+		if err != nil {
+			return 0, err
+		}
+		if i := strings.LastIndexByte(string(chunk[:n]), '\n'); i >= 0 {
+			if start+int64(i)+1 == size {
+				return size, nil // 最后一行是完整的
+			}
+			return start + int64(i) + 1, f.Truncate(start + int64(i) + 1)
+		}
+		end = start
+	}
+	return 0, f.Truncate(0)
+}
+
+// Load函数读取会话的所有记录，最后一行不完整（例如写入时崩溃）会被忽略
+func (s *JSONLStore) Load(sessionID string) ([]Record, error) {
+	path, err := s.path(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			// 只有最后一行允许损坏
+			if scanner.Scan() {
+				return nil, fmt.Errorf("error parsing %s: %v", path, err)
+			}
+			break
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// List函数列出所有会话，最近更新的排在前面，无法读取的会话文件会被报告并跳过
+func (s *JSONLStore) List() ([]SessionInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []SessionInfo
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != jsonlExt {
+			continue
+		}
+
+		id := strings.TrimSuffix(entry.Name(), jsonlExt)
+		records, err := s.Load(id)
+		if err != nil {
+			fmt.Printf("Error reading session %s: %v\n", id, err)
+			continue
+		}
+		infos = append(infos, summarize(id, records))
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].UpdatedAt.After(infos[j].UpdatedAt)
+	})
+	return infos, nil
+}
+
+// Delete函数删除会话文件
+func (s *JSONLStore) Delete(sessionID string) error {
+	path, err := s.path(sessionID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// summarize函数根据会话记录生成摘要信息
+func summarize(id string, records []Record) SessionInfo {
+	info := SessionInfo{ID: id}
+	var counted []bool // 每条消息记录是否计入了Messages，撤销消息时用来减去对应的数量
+	titleIndex := -1   // 作为标题的消息在counted中的位置，这条消息被撤销时清空标题
+
+	for _, record := range records {
+		if info.CreatedAt.IsZero() {
+			info.CreatedAt = record.Time
+		}
+		info.UpdatedAt = record.Time
+
+		switch record.Type {
+		case RecordMeta:
+			info.ParentID = record.ParentID
+		case RecordMessage:
+			if record.Message == nil {
+				continue
+			}
+			count := record.Message.Content != "" && (record.Message.Role == "user" || record.Message.Role == "assistant")
+			counted = append(counted, count)
+			if !count {
+				continue
+			}
+			if record.Message.Role == "user" && info.Title == "" {
+				info.Title = truncate(record.Message.Content, titleLength)
+				titleIndex = len(counted) - 1
+			}
+			info.Messages++
+		case RecordReset:
+			counted = nil
+			info.Messages = 0
+			info.Title = ""
+			titleIndex = -1
+		case RecordRollback:
+			for i := 0; i < record.Count && len(counted) > 0; i++ {
+				if counted[len(counted)-1] {
+					info.Messages--
+				}
+				counted = counted[:len(counted)-1]
+			}
+			if titleIndex >= len(counted) {
+				info.Title = ""
+				titleIndex = -1
+			}
+		}
+	}
+
+	return info
+}
+
+// truncate函数把文本截断到最多n个字符，并去掉换行
+func truncate(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n]) + "…"
+}
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// message函数返回一条消息记录
+func message(role, content string) Record {
+	return Record{Type: RecordMessage, Message: &openai.ChatCompletionMessage{Role: role, Content: content}}
+}
+
+// newTestStore函数在临时目录中创建存储，并写入会话的原始内容
+func newTestStore(t *testing.T, id, content string) *JSONLStore {
+	t.Helper()
+	s, err := NewJSONLStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != "" {
+		if err := os.WriteFile(filepath.Join(s.dir, id+jsonlExt), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestJSONLStoreLoad(t *testing.T) {
+	meta := `{"type":"meta","time":"2024-01-01T00:00:00Z"}` + "\n"
+	user := `{"type":"message","time":"2024-01-01T00:00:01Z","message":{"role":"user","content":"hi"}}` + "\n"
+
+	tests := []struct {
+		name    string
+		content string
+		types   []string
+		wantErr bool
+	}{
+		{
+			name:    "complete file",
+			content: meta + user,
+			types:   []string{RecordMeta, RecordMessage},
+		},
+		{
+			name:    "truncated last line is ignored",
+			content: meta + user + `{"type":"message","time":"2024-01-01T00:00:02Z","mess`,
+			types:   []string{RecordMeta, RecordMessage},
+		},
+		{
+			name:    "truncated last line with a newline is ignored",
+			content: meta + user + `{"type":"mess` + "\n",
+			types:   []string{RecordMeta, RecordMessage},
+		},
+		{
+			name:    "empty lines are skipped",
+			content: meta + "\n" + user + "\n",
+			types:   []string{RecordMeta, RecordMessage},
+		},
+		{
+			name:    "corrupt line in the middle is an error",
+			content: meta + `{"type":"mess` + "\n" + user,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t, "s1", tt.content)
+
+			records, err := s.Load("s1")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Load() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() = %v", err)
+			}
+			var types []string
+			for _, r := range records {
+				types = append(types, r.Type)
+			}
+			if strings.Join(types, ",") != strings.Join(tt.types, ",") {
+				t.Errorf("record types = %v, want %v", types, tt.types)
+			}
+		})
+	}
+}
+
+func TestJSONLStoreAppendAfterTruncatedLine(t *testing.T) {
+	meta := `{"type":"meta","time":"2024-01-01T00:00:00Z"}` + "\n"
+	for _, partial := range []string{`{"type":"message","mess`, strings.Repeat("x", 10000)} {
+		s := newTestStore(t, "s1", meta+partial)
+
+		if err := s.Append("s1", message("user", "again")); err != nil {
+			t.Fatalf("Append() = %v", err)
+		}
+		records, err := s.Load("s1")
+		if err != nil {
+			t.Fatalf("Load() after Append = %v", err)
+		}
+		if len(records) != 2 || records[1].Message == nil || records[1].Message.Content != "again" {
+			t.Errorf("records = %+v, want meta and the appended message", records)
+		}
+	}
+
+	// 整个文件只有不完整的一行时，追加后只剩新记录
+	s := newTestStore(t, "s2", `{"type":"me`)
+	if err := s.Append("s2", Record{Type: RecordMeta}); err != nil {
+		t.Fatalf("Append() = %v", err)
+	}
+	if records, err := s.Load("s2"); err != nil || len(records) != 1 {
+		t.Errorf("Load() = %d records, %v; want 1 record", len(records), err)
+	}
+}
+
+func TestJSONLStoreInvalidID(t *testing.T) {
+	s := newTestStore(t, "s1", "")
+	for _, id := range []string{"", "../s1", "a/b", "a.b"} {
+		if _, err := s.Load(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Load(%q) = %v, want ErrNotFound", id, err)
+		}
+		if err := s.Append(id, Record{Type: RecordMeta}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Append(%q) = %v, want ErrNotFound", id, err)
+		}
+	}
+}
+
+func TestJSONLStoreListSkipsUnreadableSession(t *testing.T) {
+	s := newTestStore(t, "broken", "not json\n"+`{"type":"meta","time":"2024-01-01T00:00:00Z"}`+"\n")
+	if err := s.Append("good", message("user", "hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := s.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(infos) != 1 || infos[0].ID != "good" || infos[0].Title != "hello" {
+		t.Errorf("List() = %+v, want only the readable session", infos)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  []Record
+		title    string
+		messages int
+	}{
+		{
+			name:     "counts user and assistant messages",
+			records:  []Record{{Type: RecordMeta}, message("system", "prompt"), message("user", "hello"), message("assistant", "hi"), message("tool", "{}")},
+			title:    "hello",
+			messages: 2,
+		},
+		{
+			name:     "rolled back turn is not counted",
+			records:  []Record{{Type: RecordMeta}, message("user", "first"), message("assistant", "ok"), message("user", "failed"), message("tool", "{}"), {Type: RecordRollback, Count: 2}},
+			title:    "first",
+			messages: 2,
+		},
+		{
+			name:     "rolled back first message clears the title",
+			records:  []Record{{Type: RecordMeta}, message("user", "failed"), {Type: RecordRollback, Count: 1}},
+			title:    "",
+			messages: 0,
+		},
+		{
+			name:     "title comes from the first message after a rollback",
+			records:  []Record{message("user", "failed"), message("tool", "{}"), {Type: RecordRollback, Count: 2}, message("user", "again"), message("assistant", "ok")},
+			title:    "again",
+			messages: 2,
+		},
+		{
+			name:     "reset drops earlier messages",
+			records:  []Record{message("user", "old"), message("assistant", "ok"), {Type: RecordReset}, message("user", "new")},
+			title:    "new",
+			messages: 1,
+		},
+		{
+			name:     "title is truncated to one line",
+			records:  []Record{message("user", "line one\nline two "+strings.Repeat("很长", 40))},
+			title:    "line one line two " + strings.Repeat("很长", 21) + "…",
+			messages: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := summarize("s1", tt.records)
+			if info.Title != tt.title || info.Messages != tt.messages {
+				t.Errorf("summarize() = title %q, %d messages; want %q, %d", info.Title, info.Messages, tt.title, tt.messages)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// store包负责把会话持久化到磁盘，以便在重启后恢复对话
+package store
+
+import (
+	"errors" // 用于定义错误
+	"time"   // 用于记录时间
+
+	openai "github.com/sashabaranov/go-openai" // OpenAI GPT的Go客户端
+)
+
+// ErrNotFound表示会话不存在
+var ErrNotFound = errors.New("session not found")
+
+// 记录的类型
+const (
+	RecordMeta         = "meta"          // 会话的元信息，总是第一条记录
+	RecordMessage      = "message"       // 一条对话消息
+	RecordReset        = "reset"         // 清空之前的对话历史
+	RecordSystemPrompt = "system_prompt" // 修改系统提示
+	RecordPlugins      = "plugins"       // 修改会话启用的插件
+	RecordModel        = "model"         // 修改会话使用的模型
+	RecordProvider     = "provider"      // 修改会话使用的模型服务
+	RecordCompact      = "compact"       // 把较早的消息压缩成摘要
+	RecordPolicy       = "policy"        // 修改会话的权限策略
+	RecordRollback     = "rollback"      // 撤销失败或被取消的一轮对话
+)
+
+// Record是会话日志中的一条记录，会话的状态由按顺序重放所有记录得到
+type Record struct {
+	Type     string                        `json:"type"`
+	Time     time.Time                     `json:"time"`
+	ParentID string                        `json:"parent_id,omitempty"` // meta：分叉来源的会话ID
+	Message  *openai.ChatCompletionMessage `json:"message,omitempty"`   // message：对话消息
+	Text     string                        `json:"text,omitempty"`      // system_prompt：新的系统提示；compact：新的摘要；model：新的模型；provider：新的模型服务
+	Plugins  []string                      `json:"plugins,omitempty"`   // plugins：启用的插件ID
+	Count    int                           `json:"count,omitempty"`     // compact：系统提示之后被压缩掉的消息数量；rollback：从末尾撤销的消息数量
+	Policy   map[string]string             `json:"policy,omitempty"`    // policy：会话中覆盖配置的所有权限规则
+}
+
+// SessionInfo是列出会话时返回的摘要信息
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	ParentID  string    `json:"parent_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Messages  int       `json:"messages"` // 用户和助手消息的数量
+	Title     string    `json:"title"`    // 第一条用户消息
+}
+
+// Store定义了会话存储需要实现的方法，记录只追加不修改
+type Store interface {
+	Append(sessionID string, records ...Record) error // 向会话追加记录
+	Load(sessionID string) ([]Record, error)          // 按顺序读取会话的所有记录
+	List() ([]SessionInfo, error)                     // 列出所有会话
+	Delete(sessionID string) error                    // 删除会话
+}
@@ -0,0 +1,25 @@
+package tui
+
+import "github.com/charmbracelet/lipgloss" // 终端样式
+
+// 各个角色和界面元素使用的样式
+var (
+	userStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("39")).Bold(true)  // 用户：蓝色
+	assistantStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("205")).Bold(true) // Clara：粉色
+	toolStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))            // 插件：橙色
+	errorStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))            // 错误：红色
+	infoStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("244")).Italic(true)
+	dimStyle       = lipgloss.NewStyle().Foreground(lipgloss.Color("244"))
+
+	toolPanelStyle = lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("214")).
+			Padding(0, 1)
+	selectedPanelStyle = toolPanelStyle.Copy().BorderForeground(lipgloss.Color("231"))
+
+	statusStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("231")).
+			Background(lipgloss.Color("62")).
+			Padding(0, 1)
+	busyStyle = statusStyle.Copy().Background(lipgloss.Color("205"))
+)
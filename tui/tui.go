@@ -0,0 +1,582 @@
+// tui包提供基于bubbletea的全屏交互界面
+package tui
+
+import (
+	"bytes"         // 用于格式化JSON
+	"context"       // 用于取消进行中的对话
+	"encoding/json" // 用于格式化插件参数
+	"errors"        // 用于判断退出命令
+	"fmt"           // 用于格式化输出
+	"strings"       // 用于字符串处理
+
+	"github.com/charmbracelet/bubbles/spinner"  // 等待动画
+	"github.com/charmbracelet/bubbles/textarea" // 多行输入框
+	"github.com/charmbracelet/bubbles/viewport" // 可滚动的对话区域
+	tea "github.com/charmbracelet/bubbletea"    // TUI框架
+	"github.com/charmbracelet/lipgloss"         // 终端样式
+	openai "github.com/sashabaranov/go-openai"  // OpenAI GPT的Go客户端
+	"github.com/wangergou2023/clara/assistant"  // 助手和会话
+	"github.com/wangergou2023/clara/commands"   // 斜杠命令
+	"github.com/wangergou2023/clara/plugins"    // 插件系统
+)
+
+// inputHeight是输入框的行数
+const inputHeight = 3
+
+// 对话中条目的角色
+const (
+	roleUser      = "user"
+	roleAssistant = "assistant"
+	roleTool      = "tool"
+	roleError     = "error"
+	roleInfo      = "info"
+)
+
+// toolCall记录一次插件调用，在界面中显示为可折叠的面板
+type toolCall struct {
+	id       string
+	name     string
+	args     string
+	result   string
+	err      error
+	done     bool
+	expanded bool
+}
+
+// entry是对话区域中的一个条目
+type entry struct {
+	role string
+	text string
+	tool *toolCall // 只有插件调用条目才有
+}
+
+// 对话进行时从后台发送给界面的消息
+type (
+	chunkMsg      string
+	toolCallMsg   openai.ToolCall
+	toolResultMsg struct {
+		call   openai.ToolCall
+		result string
+		err    error
+	}
+	turnDoneMsg    struct{ err error }
+	noticeMsg      string
+	commandDoneMsg struct {
+		input   string
+		output  string
+		err     error
+		session *assistant.Session
+	}
+	approvalMsg struct {
+		approval assistant.Approval
+		reply    chan<- bool // 用户的回答，true表示允许
+	}
+)
+
+// model是界面的状态
+type model struct {
+	assistant *assistant.Assistant
+	session   *assistant.Session
+	commands  *commands.Registry
+
+	viewport viewport.Model
+	input    textarea.Model
+	spinner  spinner.Model
+
+	entries   []entry
+	streaming bool               // 最后一个助手条目是否仍在接收回复
+	busy      bool               // 是否有一轮对话正在进行
+	cancel    context.CancelFunc // 取消进行中的一轮对话，没有对话进行时为nil
+	pending   *approvalMsg       // 等待用户确认的插件调用
+	events    chan tea.Msg
+	selected  int // 选中的插件面板在entries中的位置，-1表示没有选中
+
+	tokensUsed   int
+	tokensBudget int
+
+	width  int
+	height int
+	ready  bool
+}
+
+// Run函数启动全屏界面，直到用户退出。斜杠命令可能切换会话，返回退出时使用的会话
+func Run(clara *assistant.Assistant, session *assistant.Session, registry *commands.Registry) (*assistant.Session, error) {
+	m := newModel(clara, session, registry)
+	p := tea.NewProgram(m, tea.WithAltScreen(), tea.WithMouseCellMotion())
+
+	// 插件变化等通知显示在对话区域中，而不是直接输出到被界面占用的终端
+	clara.SetNoticeHandler(func(notice string) { p.Send(noticeMsg(notice)) })
+	defer clara.SetNoticeHandler(nil)
+
+	_, err := p.Run()
+	return m.session, err
+}
+
+// newModel函数创建界面状态，并把会话已有的历史显示出来
+func newModel(clara *assistant.Assistant, session *assistant.Session, registry *commands.Registry) *model {
+	input := textarea.New()
+	input.Placeholder = "输入消息，Enter发送，Alt+Enter换行，/help查看命令"
+	input.ShowLineNumbers = false
+	input.CharLimit = 0
+	input.SetHeight(inputHeight)
+	input.KeyMap.InsertNewline.SetKeys("alt+enter", "ctrl+j")
+	input.Focus()
+
+	s := spinner.New()
+	s.Spinner = spinner.Dot
+
+	m := &model{
+		assistant: clara,
+		session:   session,
+		commands:  registry,
+		input:     input,
+		spinner:   s,
+		selected:  -1,
+	}
+	m.entries = historyEntries(session.History())
+	m.updateTokens()
+	return m
+}
+
+// historyEntries函数把会话历史转换为界面条目，插件调用和结果会合并为一个面板
+func historyEntries(history []openai.ChatCompletionMessage) []entry {
+	var entries []entry
+	calls := make(map[string]*toolCall)
+
+	for i, message := range history {
+		switch message.Role {
+		case openai.ChatMessageRoleSystem:
+			// 第一条系统消息是系统提示，之后的系统消息是插件变化等通知
+			if i > 0 {
+				entries = append(entries, entry{role: roleInfo, text: message.Content})
+			}
+		case openai.ChatMessageRoleUser:
+			entries = append(entries, entry{role: roleUser, text: message.Content})
+		case openai.ChatMessageRoleAssistant:
+			if message.Content != "" {
+				entries = append(entries, entry{role: roleAssistant, text: message.Content})
+			}
+			for _, tc := range message.ToolCalls {
+				call := &toolCall{id: tc.ID, name: tc.Function.Name, args: tc.Function.Arguments}
+				calls[tc.ID] = call
+				entries = append(entries, entry{role: roleTool, tool: call})
+			}
+		case openai.ChatMessageRoleTool:
+			if call, ok := calls[message.ToolCallID]; ok {
+				call.result = message.Content
+				call.done = true
+			}
+		}
+	}
+
+	return entries
+}
+
+func (m *model) Init() tea.Cmd {
+	return tea.Batch(textarea.Blink, m.spinner.Tick)
+}
+
+func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
+
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.input.SetWidth(msg.Width)
+		viewportHeight := msg.Height - inputHeight - 2 // 减去状态栏和分隔线
+		if !m.ready {
+			m.viewport = viewport.New(msg.Width, viewportHeight)
+			m.ready = true
+		} else {
+			m.viewport.Width, m.viewport.Height = msg.Width, viewportHeight
+		}
+		m.refresh(true)
+
+	case tea.MouseMsg:
+		var cmd tea.Cmd
+		m.viewport, cmd = m.viewport.Update(msg)
+		return m, cmd
+
+	case tea.KeyMsg:
+		if m.pending != nil {
+			// 等待确认时y允许、n或Enter拒绝，Ctrl+C拒绝并取消这一轮对话
+			switch msg.String() {
+			case "y", "Y":
+				m.answer(true)
+				return m, nil
+			case "n", "N", "enter":
+				m.answer(false)
+				return m, nil
+			case "ctrl+c", "esc":
+				m.pending = nil
+			default:
+				return m, nil
+			}
+		}
+		switch msg.String() {
+		case "ctrl+c", "esc":
+			// 对话进行时只取消这一轮对话，否则退出
+			if m.cancel != nil {
+				m.cancel()
+				m.cancel = nil
+				return m, nil
+			}
+			return m, tea.Quit
+		case "enter":
+			return m, m.submit()
+		case "pgup":
+			m.viewport.ViewUp()
+			return m, nil
+		case "pgdown":
+			m.viewport.ViewDown()
+			return m, nil
+		case "tab":
+			m.selectTool(1)
+			return m, nil
+		case "shift+tab":
+			m.selectTool(-1)
+			return m, nil
+		case "ctrl+o":
+			m.toggleTool()
+			return m, nil
+		}
+
+	case chunkMsg:
+		if !m.streaming {
+			m.entries = append(m.entries, entry{role: roleAssistant})
+			m.streaming = true
+		}
+		m.entries[len(m.entries)-1].text += string(msg)
+		m.refresh(false)
+		return m, waitForEvent(m.events)
+
+	case toolCallMsg:
+		m.streaming = false
+		m.entries = append(m.entries, entry{role: roleTool, tool: &toolCall{
+			id:   msg.ID,
+			name: msg.Function.Name,
+			args: msg.Function.Arguments,
+		}})
+		m.refresh(false)
+		return m, waitForEvent(m.events)
+
+	case toolResultMsg:
+		for i := range m.entries {
+			if t := m.entries[i].tool; t != nil && t.id == msg.call.ID && !t.done {
+				t.result, t.err, t.done = msg.result, msg.err, true
+				break
+			}
+		}
+		m.refresh(false)
+		return m, waitForEvent(m.events)
+
+	case approvalMsg:
+		m.streaming = false
+		m.pending = &msg
+		a := msg.approval
+		m.entries = append(m.entries, entry{role: roleInfo, text: fmt.Sprintf("Clara想调用 %s（%s，规则 %s），参数：\n%s\n按 y 允许，n 拒绝",
+			a.Call.Function.Name, plugins.FormatCapabilities(a.Capabilities), a.Rule, a.Arguments())})
+		m.refresh(true)
+		return m, waitForEvent(m.events)
+
+	case turnDoneMsg:
+		m.busy, m.streaming, m.pending = false, false, nil
+		if m.cancel != nil {
+			m.cancel()
+			m.cancel = nil
+		}
+		if errors.Is(msg.err, context.Canceled) {
+			m.entries = append(m.entries, entry{role: roleInfo, text: "已取消"})
+		} else if msg.err != nil {
+			m.entries = append(m.entries, entry{role: roleError, text: msg.err.Error()})
+		}
+		m.updateTokens()
+		m.refresh(false)
+		return m, nil
+
+	case commandDoneMsg:
+		m.busy = false
+		if errors.Is(msg.err, commands.ErrExit) {
+			return m, tea.Quit
+		}
+		// 命令可能切换或重置了会话，按会话历史重新生成对话区域
+		m.session = msg.session
+		m.entries = append(historyEntries(m.session.History()), entry{role: roleInfo, text: msg.input})
+		m.selected = -1
+		if msg.err != nil {
+			m.entries = append(m.entries, entry{role: roleError, text: msg.err.Error()})
+		} else if msg.output != "" {
+			m.entries = append(m.entries, entry{role: roleInfo, text: msg.output})
+		}
+		m.updateTokens()
+		m.refresh(true)
+		return m, nil
+
+	case noticeMsg:
+		notice := entry{role: roleInfo, text: string(msg)}
+		if m.streaming {
+			// 插在正在接收的回复之前，后续的回复片段仍然追加到最后一个条目
+			last := len(m.entries) - 1
+			m.entries = append(m.entries[:last], notice, m.entries[last])
+		} else {
+			m.entries = append(m.entries, notice)
+		}
+		m.refresh(false)
+		return m, nil
+
+	case spinner.TickMsg:
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	cmds = append(cmds, cmd)
+	return m, tea.Batch(cmds...)
+}
+
+// submit函数发送输入框中的消息，并在后台开始一轮对话
+func (m *model) submit() tea.Cmd {
+	text := strings.TrimSpace(m.input.Value())
+	if text == "" || m.busy {
+		return nil
+	}
+	m.input.Reset()
+
+	if commands.IsCommand(text) {
+		m.busy = true
+		m.refresh(true)
+		return runCommand(m.commands, &commands.Context{Assistant: m.assistant, Session: m.session}, text)
+	}
+
+	m.entries = append(m.entries, entry{role: roleUser, text: text})
+	m.busy, m.streaming = true, false
+	m.refresh(true)
+
+	var ctx context.Context
+	ctx, m.cancel = context.WithCancel(context.Background())
+	m.events = make(chan tea.Msg, 64)
+	go runTurn(ctx, m.session, text, m.events)
+	return waitForEvent(m.events)
+}
+
+// runTurn函数在后台执行一轮对话，把进展通过events发送给界面，ctx被取消时中止这一轮对话
+func runTurn(ctx context.Context, session *assistant.Session, text string, events chan<- tea.Msg) {
+	_, err := session.MessageContext(ctx, text, assistant.Handler{
+		OnChunk: func(chunk string) {
+			events <- chunkMsg(chunk)
+		},
+		OnToolCall: func(call openai.ToolCall) {
+			events <- toolCallMsg(call)
+		},
+		OnToolResult: func(call openai.ToolCall, result string, err error) {
+			events <- toolResultMsg{call: call, result: result, err: err}
+		},
+		OnApproval: func(ctx context.Context, approval assistant.Approval) (bool, error) {
+			reply := make(chan bool, 1)
+			events <- approvalMsg{approval: approval, reply: reply}
+			select {
+			case allowed := <-reply:
+				return allowed, nil
+			case <-ctx.Done():
+				return false, ctx.Err()
+			}
+		},
+	})
+	events <- turnDoneMsg{err: err}
+}
+
+// answer函数把用户的回答交给等待确认的插件调用
+func (m *model) answer(allowed bool) {
+	m.pending.reply <- allowed
+	m.pending = nil
+
+	text := "已拒绝"
+	if allowed {
+		text = "已允许"
+	}
+	m.entries = append(m.entries, entry{role: roleInfo, text: text})
+	m.refresh(true)
+}
+
+// runCommand函数返回一个在后台执行斜杠命令的命令，命令可能需要请求模型或读写磁盘
+func runCommand(registry *commands.Registry, ctx *commands.Context, text string) tea.Cmd {
+	return func() tea.Msg {
+		output, err := registry.Dispatch(ctx, text)
+		return commandDoneMsg{input: text, output: output, err: err, session: ctx.Session}
+	}
+}
+
+// waitForEvent函数返回一个等待下一条后台消息的命令
+func waitForEvent(events <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-events
+	}
+}
+
+// selectTool函数在插件面板之间移动选中位置
+func (m *model) selectTool(step int) {
+	var tools []int
+	for i, e := range m.entries {
+		if e.tool != nil {
+			tools = append(tools, i)
+		}
+	}
+	if len(tools) == 0 {
+		return
+	}
+
+	pos := -1
+	for i, idx := range tools {
+		if idx == m.selected {
+			pos = i
+		}
+	}
+	switch {
+	case pos == -1 && step > 0:
+		pos = 0
+	case pos == -1:
+		pos = len(tools) - 1
+	default:
+		pos = (pos + step + len(tools)) % len(tools)
+	}
+	m.selected = tools[pos]
+	m.refresh(false)
+}
+
+// toggleTool函数展开或折叠选中的插件面板，没有选中时操作最近的一个
+func (m *model) toggleTool() {
+	idx := m.selected
+	if idx < 0 {
+		for i := len(m.entries) - 1; i >= 0; i-- {
+			if m.entries[i].tool != nil {
+				idx = i
+				break
+			}
+		}
+	}
+	if idx < 0 || idx >= len(m.entries) || m.entries[idx].tool == nil {
+		return
+	}
+	m.entries[idx].tool.expanded = !m.entries[idx].tool.expanded
+	m.refresh(false)
+}
+
+// updateTokens函数更新状态栏中显示的token用量
+func (m *model) updateTokens() {
+	m.tokensUsed, m.tokensBudget = m.session.TokenUsage()
+}
+
+// refresh函数重新渲染对话区域，在底部时保持滚动到最新的内容
+func (m *model) refresh(forceBottom bool) {
+	if !m.ready {
+		return
+	}
+	atBottom := m.viewport.AtBottom()
+	m.viewport.SetContent(m.renderEntries())
+	if forceBottom || atBottom {
+		m.viewport.GotoBottom()
+	}
+}
+
+// renderEntries函数渲染所有对话条目
+func (m *model) renderEntries() string {
+	width := m.width
+	if width <= 0 {
+		width = 80
+	}
+	body := lipgloss.NewStyle().Width(width)
+
+	var blocks []string
+	for i, e := range m.entries {
+		switch e.role {
+		case roleUser:
+			blocks = append(blocks, userStyle.Render("你")+"\n"+body.Render(e.text))
+		case roleAssistant:
+			blocks = append(blocks, assistantStyle.Render("Clara")+"\n"+body.Render(e.text))
+		case roleTool:
+			blocks = append(blocks, renderTool(e.tool, i == m.selected, width))
+		case roleError:
+			blocks = append(blocks, errorStyle.Width(width).Render("错误: "+e.text))
+		case roleInfo:
+			blocks = append(blocks, infoStyle.Width(width).Render(e.text))
+		}
+	}
+
+	return strings.Join(blocks, "\n\n")
+}
+
+// renderTool函数渲染插件调用面板，折叠时只显示一行
+func renderTool(t *toolCall, selected bool, width int) string {
+	state := "…"
+	switch {
+	case t.done && t.err != nil:
+		state = errorStyle.Render("✗")
+	case t.done:
+		state = "✓"
+	}
+
+	if !t.expanded {
+		line := fmt.Sprintf("▸ 插件 %s %s %s", t.name, state, dimStyle.Render("(Ctrl+O展开)"))
+		if selected {
+			return selectedPanelStyle.Render(line)
+		}
+		return toolStyle.Render(line)
+	}
+
+	content := toolStyle.Render(fmt.Sprintf("▾ 插件 %s %s", t.name, state)) + "\n" +
+		dimStyle.Render("参数:") + "\n" + prettyJSON(t.args)
+	if t.done {
+		result := t.result
+		if t.err != nil {
+			result = t.err.Error()
+		}
+		content += "\n" + dimStyle.Render("结果:") + "\n" + prettyJSON(result)
+	}
+
+	style := toolPanelStyle
+	if selected {
+		style = selectedPanelStyle
+	}
+	return style.Width(width - 2).Render(content)
+}
+
+// prettyJSON函数缩进JSON，不是JSON时原样返回
+func prettyJSON(text string) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(text), "", "  "); err != nil {
+		return text
+	}
+	return buf.String()
+}
+
+func (m *model) View() string {
+	if !m.ready {
+		return "Loading..."
+	}
+	separator := dimStyle.Render(strings.Repeat("─", m.width))
+	return m.viewport.View() + "\n" + m.statusBar() + "\n" + separator + "\n" + m.input.View()
+}
+
+// statusBar函数渲染状态栏：模型、token用量、启用的插件和会话ID
+func (m *model) statusBar() string {
+	style := statusStyle
+	state := "就绪"
+	if m.busy {
+		style = busyStyle
+		state = m.spinner.View() + " 思考中（Ctrl+C取消）"
+	}
+	if m.pending != nil {
+		state = "等待确认（y允许，n拒绝）"
+	}
+
+	enabled := strings.Join(m.session.Plugins(), ",")
+	if enabled == "" {
+		enabled = "无"
+	}
+
+	text := fmt.Sprintf("%s │ 模型 %s │ tokens %d/%d │ 插件 %s │ 会话 %s",
+		state, m.session.Model(), m.tokensUsed, m.tokensBudget, enabled, m.session.ID())
+	return style.Width(m.width).MaxHeight(1).Render(text)
+}